@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/agent"
+	"remote-pull/internal/checksum"
+	"remote-pull/internal/console"
+)
+
+// newAgentCmd is run on remote hosts by the agent mode (--agent), not by
+// users.
+func newAgentCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "agent",
+		Short:  "Helper run on remote hosts by --agent",
+		Hidden: true,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "version",
+		Short: "Print the agent identity",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := agent.Identity()
+			if err != nil {
+				return err
+			}
+			console.Println(id)
+			return nil
+		},
+	})
+
+	var (
+		load      string
+		algorithm string
+		size      int64
+		digest    string
+		cacheSize int64
+	)
+	loadCmd := &cobra.Command{
+		Use:   "load",
+		Short: "Verify an encoded archive from stdin and pipe it into --load",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return agent.Load(os.Stdin, load, algorithm, size, digest, cacheSize, os.Stdout)
+		},
+	}
+	loadCmd.Flags().StringVar(&load, "load", "docker load", "Shell command loading the archive")
+	loadCmd.Flags().StringVar(&algorithm, "checksum", checksum.SHA256, "Checksum algorithm of the frames")
+	loadCmd.Flags().Int64Var(&size, "size", 0, "Archive size for progress reports")
+	loadCmd.Flags().StringVar(&digest, "digest", "", "SHA-256 of the archive, to keep it in the cache")
+	loadCmd.Flags().Int64Var(&cacheSize, "cache", 0, "Cache size in bytes (0 disables the cache)")
+	cmd.AddCommand(loadCmd)
+
+	cachedCmd := &cobra.Command{
+		Use:   "cached <digest>",
+		Short: "Load a cached archive, printing hit or miss",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hit, err := agent.Cached(algorithm, args[0], load, os.Stdout)
+			if err != nil {
+				return err
+			}
+			if hit {
+				console.Println("hit")
+			} else {
+				console.Println("miss")
+			}
+			return nil
+		},
+	}
+	cachedCmd.Flags().StringVar(&load, "load", "docker load", "Shell command loading the archive")
+	cachedCmd.Flags().StringVar(&algorithm, "checksum", checksum.SHA256, "Checksum algorithm of the digest")
+	cmd.AddCommand(cachedCmd)
+	return cmd
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+func newAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect audit logs",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify <file>",
+		Short: "Verify the hash chain of an audit log",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := ssh.VerifyAuditLog(args[0])
+			if err != nil {
+				return err
+			}
+			console.Printf("[SUCCESS] %d records verified\n", n)
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newBuildRemoteCmd(opts *transfer.Options) *cobra.Command {
+	var bopts transfer.BuildOptions
+	cmd := &cobra.Command{
+		Use:   "build-remote <context> <[user@]host[:port]>",
+		Short: "Build an image on a remote host from a local build context",
+		Long: `Stream the local build context (honoring .dockerignore) over SSH and run the
+build on the remote daemon, e.g. to use better hardware or to get
+architecture-native builds.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.BuildRemote(cmd.Context(), args[0], args[1], bopts, *opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&bopts.Dockerfile, "file", "f", "", "Path of the Dockerfile (default <context>/Dockerfile)")
+	flags.StringArrayVarP(&bopts.Tags, "tag", "t", nil, "Name of the built image (repeatable)")
+	flags.StringArrayVar(&bopts.BuildArgs, "build-arg", nil, "Build-time variable (repeatable)")
+	flags.StringVar(&bopts.Target, "target", "", "Build stage to build")
+	flags.StringVar(&bopts.Platform, "platform", "", "Platform of the built image")
+	return cmd
+}
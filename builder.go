@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newBuilderCmd(opts *transfer.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "builder",
+		Short: "Manage remote buildx builders",
+	}
+
+	var bopts transfer.BuilderOptions
+	setup := &cobra.Command{
+		Use:   "setup <[user@]host[:port]>",
+		Short: "Configure a remote host as buildx builder node",
+		Long: `Check the remote docker, transfer the BuildKit image, create a buildx builder
+using the docker-container driver on the remote and bootstrap it to verify
+that BuildKit is available.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.SetupBuilder(cmd.Context(), args[0], bopts, *opts)
+		},
+	}
+	flags := setup.Flags()
+	flags.StringVar(&bopts.Name, "name", "", "Name of the builder (default remote-<host>)")
+	flags.StringVar(&bopts.BuildkitImage, "buildkit-image", transfer.DefaultBuildkitImage, "BuildKit image run on the remote")
+	flags.BoolVar(&bopts.SkipTransfer, "skip-transfer", false, "Let the remote daemon pull the BuildKit image itself")
+
+	cmd.AddCommand(setup)
+	return cmd
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newContainerCmd(opts *transfer.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "container",
+		Short: "Transfer container snapshots",
+	}
+
+	var copts transfer.ContainerOptions
+	push := &cobra.Command{
+		Use:   "push <container> <[user@]host[:port]>",
+		Short: "Snapshot a local container's filesystem as image on a remote host",
+		Long: `Export the filesystem of a local container (docker export), transfer it and
+import it as an image on the remote host. Entrypoint, command, environment,
+working directory, user and exposed ports are carried over from the container
+unless overridden.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.PushContainer(cmd.Context(), args[0], args[1], copts, *opts)
+		},
+	}
+	flags := push.Flags()
+	flags.StringVarP(&copts.Tag, "tag", "t", "", "Name of the image created on the remote (default <container>:snapshot)")
+	flags.StringVar(&copts.Entrypoint, "entrypoint", "", `Entrypoint of the image, e.g. '["/app/run"]'`)
+	flags.StringVar(&copts.Cmd, "cmd", "", `Command of the image, e.g. '["--serve"]'`)
+	flags.StringArrayVarP(&copts.Changes, "change", "c", nil, "Additional Dockerfile instruction applied on import (repeatable)")
+
+	cmd.AddCommand(push)
+	return cmd
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newCopyCmd(opts *transfer.Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "copy <[user@]source[:port]> <[user@]destination[:port]> <image>",
+		Short: "Copy an image from one remote host to another",
+		Long: `Run docker save on the source host and relay its output over SSH into docker
+load on the destination host. The image passes through this machine as a
+stream and is never written to disk here.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.CopyImage(cmd.Context(), args[0], args[1], args[2], *opts)
+		},
+	}
+}
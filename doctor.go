@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newDoctorCmd(opts *transfer.Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor [[user@]host]",
+		Short: "Check the local environment",
+		Long: `Check the local side without connecting anywhere: the SSH agent, usable
+identities, the ssh_config settings for the given host, known_hosts, the local
+docker or podman and the temp directory, and print a checklist with fixes.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			host := ""
+			if len(args) > 0 {
+				host = args[0]
+			}
+			return transfer.Doctor(host, *opts)
+		},
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newFetchCmd(opts *transfer.Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "fetch <[user@]host[:port]> <image>",
+		Short: "Copy an image from a remote host into the local Docker",
+		Long: `Run docker save on the remote host and stream the image over SSH into the
+local docker load, e.g. to retrieve images that only exist on an air-gapped
+machine.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.FetchImage(cmd.Context(), args[0], args[1], *opts)
+		},
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/report"
+	"remote-pull/internal/transfer"
+)
+
+func newFleetReportCmd(opts *transfer.Options) *cobra.Command {
+	var (
+		targets   targetFlags
+		format    string
+		output    string
+		images    []string
+		driftOnly bool
+	)
+	cmd := &cobra.Command{
+		Use:   "report [<[user@]host[:port]> | @<group>]...",
+		Short: "Report which version of every image each host runs",
+		Long: `Collect the image inventory of all hosts in parallel and report, per
+repository, which hosts run which image ID, tags and digests, highlighting
+repositories whose hosts run different versions. Hosts are given as arguments,
+as @<group> patterns of the --inventory, or through the target selection
+flags.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var hosts []string
+			for _, arg := range args {
+				group, ok := strings.CutPrefix(arg, "@")
+				if !ok {
+					hosts = append(hosts, arg)
+					continue
+				}
+				if targets.inventoryFile == "" {
+					return fmt.Errorf("%s requires --inventory", arg)
+				}
+				resolved, err := targets.inventoryTargets(group)
+				if err != nil {
+					return err
+				}
+				hosts = append(hosts, resolved...)
+			}
+			if targets.active() && len(args) == 0 {
+				resolved, err := targets.resolve()
+				if err != nil {
+					return err
+				}
+				hosts = append(hosts, resolved...)
+			}
+			if len(hosts) == 0 {
+				return fmt.Errorf("no hosts given")
+			}
+
+			if err := checkHostKeyPins(opts.HostKeys, hosts); err != nil {
+				return err
+			}
+			fleetOpts := *opts
+			fleetOpts.HostKeys = maps.Clone(opts.HostKeys)
+			if fleetOpts.HostKeys == nil {
+				fleetOpts.HostKeys = map[string][]string{}
+			}
+			targets.pinHostKeys(fleetOpts.HostKeys)
+			fleet := transfer.InventoryFleet(cmd.Context(), hosts, images, fleetOpts)
+			if err := report.WriteFleet(fleet, format, output, driftOnly); err != nil {
+				return err
+			}
+			if failed := fleet.Failed(); failed > 0 {
+				return fmt.Errorf("inventory failed on %d of %d hosts", failed, len(hosts))
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&format, "format", "text", "Report format: "+strings.Join(report.FleetFormats, ", "))
+	flags.StringVarP(&output, "output", "o", "", "Write the report to this file instead of printing it")
+	flags.StringArrayVar(&images, "image", nil, "Only report repositories matching this pattern, e.g. registry.corp/* (repeatable)")
+	flags.BoolVar(&driftOnly, "drift-only", false, "Only report repositories whose hosts run different versions")
+	targets.register(flags)
+	return cmd
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"remote-pull/pkg/ssh"
+)
+
+// splitArgs separates the images from the hosts among the arguments. The
+// images are given before "--" or in imagesFile, with all other arguments
+// being hosts. Without either, the first argument is an image and so are the
+// arguments following it that look like image references (see imageLike);
+// with compose (the images come from the compose file) all are hosts. An
+// image reference among the hosts is an error, as it would be connected to
+// as a host.
+func splitArgs(cmd *cobra.Command, args []string, imagesFile string, compose bool) ([]string, []string, error) {
+	if compose {
+		return nil, args, nil
+	}
+	var images []string
+	if imagesFile != "" {
+		var err error
+		if images, err = readImageList(imagesFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	dash := cmd.ArgsLenAtDash()
+	isImage := imageOnly
+	switch {
+	case dash >= 0:
+		images = append(images, args[:dash]...)
+		args = args[dash:]
+	case imagesFile == "":
+		n := 1
+		for n < len(args) && imageLike(args[n]) {
+			n++
+		}
+		images = append(images, args[:n]...)
+		args = args[n:]
+		isImage = imageLike
+	}
+	if len(images) == 0 {
+		return nil, nil, fmt.Errorf("no images given")
+	}
+	for _, host := range args {
+		if isImage(host) {
+			return nil, nil, fmt.Errorf("%q is an image reference, not a host; give the images before \"--\" and the hosts after it, e.g. remote-pull app:1.2 redis:7 -- user@example.com", host)
+		}
+	}
+	// An image given twice is transferred once
+	var unique []string
+	for _, image := range images {
+		if !slices.Contains(unique, image) {
+			unique = append(unique, image)
+		}
+	}
+	return unique, args, nil
+}
+
+// imageOnly reports whether arg can only be an image reference and not a
+// [user@]host[:port] target: it has a path, a digest or a tag that is not a
+// port number.
+func imageOnly(arg string) bool {
+	if strings.Contains(arg, "/") || strings.Contains(arg, "@sha256:") {
+		return true
+	}
+	// IPv6 addresses contain colons as well
+	if strings.HasPrefix(arg, "[") || strings.Count(arg, ":") > 1 {
+		return false
+	}
+	_, tag, ok := strings.Cut(arg, ":")
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(tag)
+	return err != nil
+}
+
+// imageLike reports whether arg is taken as an image reference when images
+// and hosts are not separated by "--": besides the references of imageOnly,
+// those with a numeric tag such as redis:7, unless they name a host with a
+// port (user@host:port, an IP address or an ssh_config alias). Untagged
+// names such as "nginx" look like hosts.
+func imageLike(arg string) bool {
+	if imageOnly(arg) {
+		return true
+	}
+	name, _, ok := strings.Cut(arg, ":")
+	if !ok || strings.Contains(arg, "@") || strings.HasPrefix(arg, "[") || strings.Count(arg, ":") > 1 {
+		return false
+	}
+	return net.ParseIP(name) == nil && !ssh.IsConfigAlias(name)
+}
+
+// readImageList reads the images listed in path, one per line. Empty lines
+// and lines starting with # are ignored.
+func readImageList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images: %v", err)
+	}
+	defer f.Close()
+	var images []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		images = append(images, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read images from %s: %v", path, err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images listed in %s", path)
+	}
+	return images, nil
+}
@@ -0,0 +1,313 @@
+// Package agent is the helper remote-pull installs on a remote host and runs
+// over SSH for what plain shell commands cannot do: verifying the archive
+// chunk by chunk as it arrives, reporting progress from the remote side and
+// keeping a cache of loaded archives. The agent is the remote-pull binary
+// itself, started with the hidden "agent" subcommand.
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"remote-pull/internal/checksum"
+)
+
+// ChunkSize is the payload of a frame.
+const ChunkSize = 1 << 20
+
+var hexPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// Identity identifies the running binary by the hash of its contents, so a
+// rebuilt or updated remote-pull installs a new agent.
+func Identity() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// Encode writes r to w as frames: the length, checksum and data of every
+// chunk, followed by a zero length and the checksum of the whole stream,
+// computed with algorithm (see package checksum).
+func Encode(w io.Writer, r io.Reader, algorithm string) error {
+	total, err := checksum.New(algorithm)
+	if err != nil {
+		return err
+	}
+	chunk, _ := checksum.New(algorithm)
+	header := 4 + chunk.Size()
+	buf := make([]byte, header+ChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[header:])
+		if n > 0 {
+			data := buf[header : header+n]
+			chunk.Reset()
+			chunk.Write(data)
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			chunk.Sum(buf[4:4])
+			total.Write(data)
+			if _, err := w.Write(buf[:header+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(buf, 0)
+	total.Sum(buf[4:4])
+	_, err = w.Write(buf[:header])
+	return err
+}
+
+// EncodedSize returns the size of size bytes after Encode.
+func EncodedSize(size int64, algorithm string) int64 {
+	h, err := checksum.New(algorithm)
+	if err != nil {
+		return size
+	}
+	header := int64(4 + h.Size())
+	return size + (size+ChunkSize-1)/ChunkSize*header + header
+}
+
+// decode reads frames from r, verifies them and writes the data to w. It
+// calls progress with the number of bytes received so far and returns the
+// checksum of the stream.
+func decode(r io.Reader, w io.Writer, algorithm string, progress func(int64)) (string, error) {
+	total, err := checksum.New(algorithm)
+	if err != nil {
+		return "", err
+	}
+	chunkSum, _ := checksum.New(algorithm)
+	header := make([]byte, 4+total.Size())
+	buf := make([]byte, ChunkSize)
+	var received int64
+	for chunk := 0; ; chunk++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", fmt.Errorf("stream ended after %d bytes: %v", received, err)
+		}
+		n := binary.BigEndian.Uint32(header)
+		if n == 0 {
+			sum := total.Sum(nil)
+			if !bytes.Equal(header[4:], sum) {
+				return "", fmt.Errorf("archive checksum mismatch after %d bytes", received)
+			}
+			return hex.EncodeToString(sum), nil
+		}
+		if n > ChunkSize {
+			return "", fmt.Errorf("invalid length %d of chunk %d", n, chunk)
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return "", fmt.Errorf("stream ended in chunk %d: %v", chunk, err)
+		}
+		chunkSum.Reset()
+		chunkSum.Write(buf[:n])
+		if !bytes.Equal(header[4:], chunkSum.Sum(nil)) {
+			return "", fmt.Errorf("chunk %d at offset %d failed verification", chunk, received)
+		}
+		total.Write(buf[:n])
+		if _, err := w.Write(buf[:n]); err != nil {
+			return "", err
+		}
+		received += int64(n)
+		progress(received)
+	}
+}
+
+// validDigest checks that digest is a hex encoded checksum of algorithm, so
+// it can safely name a cache file.
+func validDigest(algorithm, digest string) error {
+	h, err := checksum.New(algorithm)
+	if err != nil {
+		return err
+	}
+	if len(digest) != 2*h.Size() || !hexPattern.MatchString(digest) {
+		return fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	return nil
+}
+
+// Load reads an archive encoded with algorithm from r and feeds it to the
+// shell command load, e.g. "docker load". The load is aborted if any chunk
+// fails verification. size is the archive size for the progress reports.
+// With a cacheSize the archive is kept in the cache under digest, which must
+// match the stream, and the cache is pruned to cacheSize bytes.
+func Load(r io.Reader, load, algorithm string, size int64, digest string, cacheSize int64, out io.Writer) error {
+	if digest != "" {
+		if err := validDigest(algorithm, digest); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command("/bin/sh", "-c", load)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", load, err)
+	}
+
+	w := io.Writer(stdin)
+	var cache *os.File
+	if cacheSize > 0 && digest != "" {
+		if cache, err = createCacheFile(); err != nil {
+			fmt.Fprintf(out, "[REMOTE] Not caching the archive: %v\n", err)
+		} else {
+			defer os.Remove(cache.Name())
+			defer cache.Close()
+			w = io.MultiWriter(stdin, cache)
+		}
+	}
+
+	reported := int64(0)
+	sum, err := decode(r, w, algorithm, func(received int64) {
+		if size <= 0 || received*10/size == reported {
+			return
+		}
+		reported = received * 10 / size
+		fmt.Fprintf(out, "[REMOTE] Received %.2f of %.2f MB (%d%%)\n",
+			float64(received)/1024/1024, float64(size)/1024/1024, min(received*100/size, 100))
+	})
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v", load, err)
+	}
+
+	if cache != nil {
+		if sum != digest {
+			return fmt.Errorf("archive digest %s does not match %s", sum, digest)
+		}
+		if err := cache.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(cache.Name(), cachePath(filepath.Dir(cache.Name()), algorithm, digest)); err != nil {
+			return err
+		}
+		return Prune(cacheSize)
+	}
+	return nil
+}
+
+// Cached loads the archive with the digest computed with algorithm from the
+// cache with the shell command load. It reports false when the archive is
+// not cached.
+func Cached(algorithm, digest, load string, out io.Writer) (bool, error) {
+	if err := validDigest(algorithm, digest); err != nil {
+		return false, err
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return false, err
+	}
+	path := cachePath(dir, algorithm, digest)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	cmd := exec.Command("/bin/sh", "-c", load)
+	cmd.Stdin = f
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("%s failed: %v", load, err)
+	}
+	// The modification time orders the cache for pruning
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true, nil
+}
+
+// Prune removes the least recently used archives from the cache until it
+// holds at most max bytes.
+func Prune(max int64) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	archives, err := filepath.Glob(filepath.Join(dir, "*.tar"))
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		path string
+		info os.FileInfo
+	}
+	var entries []entry
+	for _, path := range archives {
+		if info, err := os.Stat(path); err == nil {
+			entries = append(entries, entry{path, info})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().After(entries[j].info.ModTime())
+	})
+	var total int64
+	for _, e := range entries {
+		if total += e.info.Size(); total > max {
+			if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cacheDir returns the cache next to the agent binary.
+func cacheDir() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), "cache"), nil
+}
+
+func cachePath(dir, algorithm, digest string) string {
+	if algorithm == "" {
+		algorithm = checksum.SHA256
+	}
+	return filepath.Join(dir, algorithm+"-"+digest+".tar")
+}
+
+func createCacheFile() (*os.File, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "incoming-*")
+}
@@ -0,0 +1,41 @@
+// Package checksum selects the digest algorithm used to verify transferred
+// data and to identify cached archives.
+package checksum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	BLAKE3 = "blake3"
+)
+
+// Algorithms lists the supported algorithms.
+var Algorithms = []string{SHA256, SHA512, BLAKE3}
+
+// New returns a hash computing algorithm.
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "", SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q, expected one of %s", algorithm, strings.Join(Algorithms, ", "))
+}
+
+// Approved reports whether algorithm is approved for FIPS mode (FIPS 180-4).
+// BLAKE3 is not a NIST algorithm.
+func Approved(algorithm string) bool {
+	return algorithm == "" || algorithm == SHA256 || algorithm == SHA512
+}
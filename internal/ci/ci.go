@@ -0,0 +1,71 @@
+// Package ci adapts the output of a run to CI systems: log grouping, error
+// annotations and machine readable results.
+package ci
+
+import (
+	"fmt"
+	"strings"
+
+	"remote-pull/internal/transfer"
+)
+
+// Options configures the CI integration.
+type Options struct {
+	// System is the CI system to integrate with ("github" or "gitlab").
+	System string
+	// Dotenv is the file GitLab results are written to.
+	Dotenv string
+}
+
+// New returns the reporter for the configured CI system, or nil when none is
+// selected.
+func New(opts Options) (transfer.Reporter, error) {
+	switch opts.System {
+	case "":
+		return nil, nil
+	case "github":
+		return newGitHub(), nil
+	case "gitlab":
+		return newGitLab(opts.Dotenv), nil
+	default:
+		return nil, fmt.Errorf("unsupported CI system %q, expected github or gitlab", opts.System)
+	}
+}
+
+// firstImageID returns the remote image ID of the first host that has one.
+func firstImageID(results []transfer.Result) string {
+	for _, r := range results {
+		if r.ImageID != "" {
+			return r.ImageID
+		}
+	}
+	return ""
+}
+
+// overallStatus summarizes results as "failed" if any host failed,
+// "transferred" if any host received the image, and "skipped" otherwise.
+func overallStatus(results []transfer.Result) string {
+	status := transfer.StatusSkipped
+	for _, r := range results {
+		switch r.Status {
+		case transfer.StatusFailed:
+			return transfer.StatusFailed
+		case transfer.StatusTransferred:
+			status = transfer.StatusTransferred
+		}
+	}
+	return status
+}
+
+func totalBytes(results []transfer.Result) int64 {
+	var total int64
+	for _, r := range results {
+		total += r.Bytes
+	}
+	return total
+}
+
+// oneLine collapses a multi-line error into a single line.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
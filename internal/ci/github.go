@@ -0,0 +1,87 @@
+package ci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+	"remote-pull/internal/transfer"
+)
+
+// github emits GitHub Actions workflow commands, step outputs and a job
+// summary.
+type github struct{}
+
+func newGitHub() *github {
+	return &github{}
+}
+
+func (g *github) BeginHost(target string) {
+	console.Printf("::group::remote-pull %s\n", target)
+}
+
+func (g *github) EndHost(result transfer.Result) {
+	console.Println("::endgroup::")
+	if result.Err != nil {
+		console.Printf("::error title=remote-pull %s::%s\n", escapeProperty(result.Target), escapeData(result.Err.Error()))
+	}
+}
+
+func (g *github) Finish(results []transfer.Result) error {
+	if err := appendFile(os.Getenv("GITHUB_OUTPUT"), fmt.Sprintf(
+		"status=%s\nimage-id=%s\nbytes-transferred=%d\n",
+		overallStatus(results), firstImageID(results), totalBytes(results))); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("### remote-pull\n\n")
+	b.WriteString("| Host | Image | Status | Transferred | Image ID | Duration |\n")
+	b.WriteString("|------|-------|--------|-------------|----------|----------|\n")
+	for _, r := range results {
+		status := r.Status
+		if r.Err != nil {
+			status += ": " + oneLine(r.Err.Error())
+		}
+		imageID := "-"
+		if r.ImageID != "" {
+			imageID = "`" + r.ImageID + "`"
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %.2f MB | %s | %s |\n",
+			escapeCell(r.Target), r.Image, escapeCell(status), float64(r.Bytes)/1024/1024, imageID, r.Duration.Round(time.Second))
+	}
+	return appendFile(os.Getenv("GITHUB_STEP_SUMMARY"), b.String())
+}
+
+// appendFile appends s to one of the files GitHub provides for outputs. It
+// is a no-op outside of Actions, where the variable is not set.
+func appendFile(path, s string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
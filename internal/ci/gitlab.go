@@ -0,0 +1,66 @@
+package ci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+	"remote-pull/internal/transfer"
+)
+
+// gitlab emits collapsible GitLab CI log sections and writes the results to
+// a dotenv file, which the job exposes as variables to later jobs when it is
+// declared as artifacts:reports:dotenv.
+type gitlab struct {
+	dotenv string
+}
+
+func newGitLab(dotenv string) *gitlab {
+	return &gitlab{dotenv: dotenv}
+}
+
+func (g *gitlab) BeginHost(target string) {
+	console.Printf("\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0Kremote-pull %s\n", time.Now().Unix(), sectionName(target), target)
+}
+
+func (g *gitlab) EndHost(result transfer.Result) {
+	console.Printf("\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), sectionName(result.Target))
+	if result.Err != nil {
+		console.Printf("\x1b[31;1mremote-pull failed on %s: %s\x1b[0m\n", result.Target, oneLine(result.Err.Error()))
+	}
+}
+
+// Finish writes the dotenv report. Only result values go into the file; it
+// never contains credentials, so masked variables stay masked.
+func (g *gitlab) Finish(results []transfer.Result) error {
+	if g.dotenv == "" {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "REMOTE_PULL_STATUS=%s\n", overallStatus(results))
+	fmt.Fprintf(&b, "REMOTE_PULL_IMAGE_ID=%s\n", firstImageID(results))
+	fmt.Fprintf(&b, "REMOTE_PULL_BYTES_TRANSFERRED=%d\n", totalBytes(results))
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(&b, "REMOTE_PULL_HOSTS=%d\n", len(results))
+	fmt.Fprintf(&b, "REMOTE_PULL_HOSTS_FAILED=%d\n", failed)
+	return os.WriteFile(g.dotenv, []byte(b.String()), 0o644)
+}
+
+// sectionName derives a GitLab section name, which may only contain
+// letters, digits, '_', '.' and '-'.
+func sectionName(target string) string {
+	return "remote_pull_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, target)
+}
@@ -0,0 +1,166 @@
+// Package console serializes all terminal output of the tool so that log
+// lines and progress updates written from different goroutines never end up
+// interleaved on the same line.
+package console
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	mu  sync.Mutex
+	out io.Writer = os.Stdout
+	tty           = isTerminal(os.Stdout)
+
+	// secrets are replaced by a placeholder in everything written.
+	secrets []string
+
+	// progress holds the current progress text per operation. All entries
+	// share the last terminal line, which is redrawn after every log line.
+	progress = map[string]string{}
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Printf writes a formatted log line.
+func Printf(format string, args ...any) {
+	write(fmt.Sprintf(format, args...))
+}
+
+// Println writes its arguments as a log line.
+func Println(args ...any) {
+	write(fmt.Sprintln(args...))
+}
+
+func write(s string) {
+	mu.Lock()
+	defer mu.Unlock()
+	clearProgress()
+	io.WriteString(out, redact(s))
+	drawProgress()
+}
+
+// Redact registers secret so that it is masked in all further output, e.g.
+// when a remote command echoes a credential back in an error message.
+func Redact(secret string) {
+	if secret == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Redacted returns s with all registered secrets masked, for output that
+// does not go to the console such as log files.
+func Redacted(s string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return redact(s)
+}
+
+func redact(s string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "[MASKED]")
+	}
+	return s
+}
+
+// Progress sets the progress text of operation id and redraws the progress
+// line. On non-terminal outputs progress is not drawn at all, since carriage
+// returns only produce noise in logs.
+func Progress(id, format string, args ...any) {
+	mu.Lock()
+	defer mu.Unlock()
+	clearProgress()
+	progress[id] = fmt.Sprintf(format, args...)
+	drawProgress()
+}
+
+// EndProgress removes the progress text of operation id.
+func EndProgress(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	clearProgress()
+	delete(progress, id)
+	drawProgress()
+}
+
+func clearProgress() {
+	if tty && len(progress) > 0 {
+		io.WriteString(out, "\r\x1b[K")
+	}
+}
+
+func drawProgress() {
+	if !tty || len(progress) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(progress))
+	for id := range progress {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, progress[id])
+	}
+	io.WriteString(out, strings.Join(parts, " | "))
+}
+
+// lineWriter buffers writes until a full line is available and then emits it
+// as a single log line, optionally prefixed.
+type lineWriter struct {
+	mu     sync.Mutex
+	prefix string
+	buf    bytes.Buffer
+}
+
+// Writer returns an io.Writer suitable for the output of subprocesses and
+// remote commands. Every complete line is written through the console,
+// prefixed with prefix; a last line without newline is held back until
+// Flush.
+func Writer(prefix string) io.Writer {
+	return &lineWriter{prefix: prefix}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		write(w.prefix + line)
+	}
+	return len(p), nil
+}
+
+// Flush writes out the partial line held back by w, a writer returned by
+// Writer, once the command writing to it has finished. Other writers are
+// left alone.
+func Flush(w io.Writer) {
+	lw, ok := w.(*lineWriter)
+	if !ok {
+		return
+	}
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.buf.Len() > 0 {
+		write(lw.prefix + lw.buf.String() + "\n")
+		lw.buf.Reset()
+	}
+}
@@ -0,0 +1,88 @@
+// Package discovery finds transfer targets in cloud and cluster APIs.
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// EC2Options selects EC2 instances as transfer targets.
+type EC2Options struct {
+	Region  string
+	Profile string
+	// Tags are key=value pairs every instance must carry.
+	Tags []string
+	// Filters are raw DescribeInstances filters in the CLI shorthand
+	// syntax, e.g. "Name=instance-type,Values=t3.micro".
+	Filters []string
+	// PublicIP selects the public instead of the private address.
+	PublicIP bool
+}
+
+// EC2Addresses lists the addresses of the running instances matching opts.
+// Instances are queried through the AWS CLI, which takes care of the
+// credential chain (profiles, SSO, instance roles) exactly like other AWS
+// tooling on the operator machine.
+func EC2Addresses(opts EC2Options) ([]string, error) {
+	args := []string{"ec2", "describe-instances", "--output", "json"}
+	if opts.Region != "" {
+		args = append(args, "--region", opts.Region)
+	}
+	if opts.Profile != "" {
+		args = append(args, "--profile", opts.Profile)
+	}
+
+	filters := []string{"Name=instance-state-name,Values=running"}
+	for _, tag := range opts.Tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag filter %q, expected key=value", tag)
+		}
+		filters = append(filters, fmt.Sprintf("Name=tag:%s,Values=%s", key, value))
+	}
+	filters = append(filters, opts.Filters...)
+	args = append(args, "--filters")
+	args = append(args, filters...)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("aws ec2 describe-instances failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Reservations []struct {
+			Instances []struct {
+				InstanceID       string `json:"InstanceId"`
+				PrivateIPAddress string `json:"PrivateIpAddress"`
+				PublicIPAddress  string `json:"PublicIpAddress"`
+			} `json:"Instances"`
+		} `json:"Reservations"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("unexpected describe-instances output: %v", err)
+	}
+
+	var addresses []string
+	for _, r := range result.Reservations {
+		for _, inst := range r.Instances {
+			addr := inst.PrivateIPAddress
+			if opts.PublicIP {
+				addr = inst.PublicIPAddress
+			}
+			if addr == "" {
+				return nil, fmt.Errorf("instance %s has no %s address", inst.InstanceID, map[bool]string{true: "public", false: "private"}[opts.PublicIP])
+			}
+			addresses = append(addresses, addr)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no running EC2 instances match the given filters")
+	}
+	return addresses, nil
+}
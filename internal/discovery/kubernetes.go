@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// KubeOptions selects Kubernetes nodes as transfer targets.
+type KubeOptions struct {
+	// Context is the kubeconfig context; empty uses the current one.
+	Context string
+	// Selector is a label selector such as "role=worker".
+	Selector string
+	// AddressType is the node address type to connect to
+	// (InternalIP, ExternalIP or Hostname).
+	AddressType string
+}
+
+// KubeNodeAddresses lists the addresses of the ready nodes matching opts. The
+// Kubernetes API is queried through kubectl so that every authentication
+// plugin configured in the kubeconfig keeps working.
+func KubeNodeAddresses(opts KubeOptions) ([]string, error) {
+	args := []string{"get", "nodes", "--output", "json"}
+	if opts.Context != "" {
+		args = append(args, "--context", opts.Context)
+	}
+	if opts.Selector != "" {
+		args = append(args, "--selector", opts.Selector)
+	}
+	addressType := opts.AddressType
+	if addressType == "" {
+		addressType = "InternalIP"
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl get nodes failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var nodes struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Addresses []struct {
+					Type    string `json:"type"`
+					Address string `json:"address"`
+				} `json:"addresses"`
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(output, &nodes); err != nil {
+		return nil, fmt.Errorf("unexpected kubectl output: %v", err)
+	}
+
+	var addresses []string
+	for _, node := range nodes.Items {
+		ready := false
+		for _, c := range node.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready = true
+			}
+		}
+		if !ready {
+			continue
+		}
+		addr := ""
+		for _, a := range node.Status.Addresses {
+			if a.Type == addressType {
+				addr = a.Address
+				break
+			}
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("node %s has no %s address", node.Metadata.Name, addressType)
+		}
+		addresses = append(addresses, addr)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no ready nodes match the given selector")
+	}
+	return addresses, nil
+}
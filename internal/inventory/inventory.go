@@ -0,0 +1,462 @@
+// Package inventory resolves transfer targets from Ansible INI inventories.
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Host is a resolved inventory entry.
+type Host struct {
+	// Name is the inventory host name (or alias).
+	Name string
+	// Vars holds the merged host and group variables.
+	Vars map[string]string
+}
+
+// Address returns ansible_host, falling back to the inventory name.
+func (h Host) Address() string {
+	if v := h.Vars["ansible_host"]; v != "" {
+		return v
+	}
+	return h.Name
+}
+
+// User returns ansible_user (or the legacy ansible_ssh_user), if set.
+func (h Host) User() string {
+	if v := h.Vars["ansible_user"]; v != "" {
+		return v
+	}
+	return h.Vars["ansible_ssh_user"]
+}
+
+// Port returns ansible_port (or the legacy ansible_ssh_port), if set.
+func (h Host) Port() string {
+	if v := h.Vars["ansible_port"]; v != "" {
+		return v
+	}
+	return h.Vars["ansible_ssh_port"]
+}
+
+// HostKeys returns the host keys pinned with remote_pull_host_key, a comma
+// separated list of "[TYPE ]SHA256:FINGERPRINT" entries.
+func (h Host) HostKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(h.Vars["remote_pull_host_key"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// BandwidthWeight returns the share of a bandwidth limit given to the host
+// with remote_pull_bandwidth_weight, relative to the other hosts; 0 when not
+// set.
+func (h Host) BandwidthWeight() (float64, error) {
+	v := h.Vars["remote_pull_bandwidth_weight"]
+	if v == "" {
+		return 0, nil
+	}
+	weight, err := strconv.ParseFloat(v, 64)
+	if err != nil || weight <= 0 {
+		return 0, fmt.Errorf("invalid remote_pull_bandwidth_weight %q, expected a positive number", v)
+	}
+	return weight, nil
+}
+
+type group struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+// Inventory is a parsed Ansible INI inventory.
+type Inventory struct {
+	groups   map[string]*group
+	hostVars map[string]map[string]string
+	// order keeps hosts in the order they first appear in the file
+	order []string
+}
+
+// Load parses the INI inventory at file.
+func Load(file string) (*Inventory, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inv := &Inventory{
+		groups:   map[string]*group{},
+		hostVars: map[string]map[string]string{},
+	}
+	inv.group("all")
+	inv.group("ungrouped")
+
+	section, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: malformed section header %q", file, lineNo, line)
+			}
+			name := line[1 : len(line)-1]
+			section, kind = name, "hosts"
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				section, kind = name[:i], name[i+1:]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("%s:%d: unknown section type %q", file, lineNo, kind)
+			}
+			inv.group(section)
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, lineNo, err)
+		}
+		g := inv.group(section)
+		switch kind {
+		case "hosts":
+			names, err := expandRange(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, lineNo, err)
+			}
+			vars, err := parseVars(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, lineNo, err)
+			}
+			for _, name := range names {
+				inv.addHost(g, name, vars)
+			}
+		case "children":
+			child := inv.group(fields[0])
+			if child.name == g.name || inv.reaches(child.name, g.name, map[string]bool{}) {
+				return nil, fmt.Errorf("%s:%d: group %q cannot be a child of %q, the groups would contain each other", file, lineNo, child.name, g.name)
+			}
+			g.children = append(g.children, child.name)
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: expected key=value in [%s:vars]", file, lineNo, section)
+			}
+			g.vars[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) group(name string) *group {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &group{name: name, vars: map[string]string{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+func (inv *Inventory) addHost(g *group, name string, vars map[string]string) {
+	hv, ok := inv.hostVars[name]
+	if !ok {
+		hv = map[string]string{}
+		inv.hostVars[name] = hv
+		inv.order = append(inv.order, name)
+	}
+	for k, v := range vars {
+		hv[k] = v
+	}
+	g.hosts = append(g.hosts, name)
+}
+
+// groupHosts returns all hosts of a group including those of its children.
+func (inv *Inventory) groupHosts(name string, seen map[string]bool) map[string]bool {
+	hosts := map[string]bool{}
+	if name == "all" {
+		for _, h := range inv.order {
+			hosts[h] = true
+		}
+		return hosts
+	}
+	g, ok := inv.groups[name]
+	if !ok || seen[name] {
+		return hosts
+	}
+	seen[name] = true
+	for _, h := range g.hosts {
+		hosts[h] = true
+	}
+	for _, child := range g.children {
+		for h := range inv.groupHosts(child, seen) {
+			hosts[h] = true
+		}
+	}
+	return hosts
+}
+
+// reaches reports whether group to is a descendant of group from.
+func (inv *Inventory) reaches(from, to string, seen map[string]bool) bool {
+	if seen[from] {
+		return false
+	}
+	seen[from] = true
+	for _, child := range inv.groups[from].children {
+		if child == to || inv.reaches(child, to, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// depth returns the distance of a group from "all"; variables of deeper
+// (more specific) groups override those of shallower ones, as in Ansible.
+// Load refuses cyclic children, so the recursion ends.
+func (inv *Inventory) depth(name string) int {
+	best := 0
+	for _, g := range inv.groups {
+		for _, child := range g.children {
+			if child == name && g.name != name {
+				if d := inv.depth(g.name) + 1; d > best {
+					best = d
+				}
+			}
+		}
+	}
+	if name != "all" && best == 0 {
+		best = 1
+	}
+	return best
+}
+
+func (inv *Inventory) hostGroups(host string) []string {
+	var names []string
+	for name := range inv.groups {
+		if name != "all" && inv.groupHosts(name, map[string]bool{})[host] {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := inv.depth(names[i]), inv.depth(names[j])
+		if di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (inv *Inventory) resolveHost(name string) Host {
+	vars := map[string]string{}
+	for k, v := range inv.groups["all"].vars {
+		vars[k] = v
+	}
+	for _, g := range inv.hostGroups(name) {
+		for k, v := range inv.groups[g].vars {
+			vars[k] = v
+		}
+	}
+	for k, v := range inv.hostVars[name] {
+		vars[k] = v
+	}
+	return Host{Name: name, Vars: vars}
+}
+
+// Resolve returns the hosts selected by an Ansible-style limit pattern:
+// group or host names (with * and ? wildcards) separated by ',' or ':',
+// where a '!' prefix excludes and a '&' prefix intersects. An empty limit
+// selects all hosts.
+func (inv *Inventory) Resolve(limit string) ([]Host, error) {
+	if limit == "" {
+		limit = "all"
+	}
+	selected := map[string]bool{}
+	first := true
+	for _, term := range strings.FieldsFunc(limit, func(r rune) bool { return r == ',' || r == ':' }) {
+		op := byte(0)
+		if term[0] == '!' || term[0] == '&' {
+			op, term = term[0], term[1:]
+		}
+		matched := inv.match(term)
+		if len(matched) == 0 && op == 0 {
+			return nil, fmt.Errorf("pattern %q matches no hosts or groups in inventory", term)
+		}
+		switch op {
+		case '!':
+			for h := range matched {
+				delete(selected, h)
+			}
+		case '&':
+			for h := range selected {
+				if !matched[h] {
+					delete(selected, h)
+				}
+			}
+		default:
+			for h := range matched {
+				selected[h] = true
+			}
+		}
+		first = false
+	}
+	if first {
+		return nil, fmt.Errorf("empty limit pattern")
+	}
+
+	var hosts []Host
+	for _, name := range inv.order {
+		if selected[name] {
+			hosts = append(hosts, inv.resolveHost(name))
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("limit %q selects no hosts", limit)
+	}
+	return hosts, nil
+}
+
+func (inv *Inventory) match(pattern string) map[string]bool {
+	matched := map[string]bool{}
+	for name := range inv.groups {
+		if ok, _ := path.Match(pattern, name); ok {
+			for h := range inv.groupHosts(name, map[string]bool{}) {
+				matched[h] = true
+			}
+		}
+	}
+	for _, name := range inv.order {
+		if ok, _ := path.Match(pattern, name); ok {
+			matched[name] = true
+		}
+	}
+	return matched
+}
+
+// splitFields splits a host line on whitespace, keeping quoted values intact.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			cur.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			cur.WriteByte(c)
+		case c == '#' && cur.Len() == 0:
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+func parseVars(fields []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		vars[key] = unquote(value)
+	}
+	return vars, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// expandRange expands host ranges such as web[01:10].example.com or
+// db-[a:c]. Without a range the name is returned as is.
+func expandRange(name string) ([]string, error) {
+	open := strings.Index(name, "[")
+	if open < 0 {
+		return []string{name}, nil
+	}
+	end := strings.Index(name[open:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range in %q", name)
+	}
+	end += open
+	prefix, spec, suffix := name[:open], name[open+1:end], name[end+1:]
+
+	startStr, stopStr, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q in %q", spec, name)
+	}
+	step := 1
+	if s, rest, ok := strings.Cut(stopStr, ":"); ok {
+		stopStr = s
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid range step in %q", name)
+		}
+		step = n
+	}
+
+	var items []string
+	if start, err := strconv.Atoi(startStr); err == nil {
+		stop, err := strconv.Atoi(stopStr)
+		if err != nil || stop < start {
+			return nil, fmt.Errorf("invalid range %q in %q", spec, name)
+		}
+		for i := start; i <= stop; i += step {
+			items = append(items, fmt.Sprintf("%0*d", len(startStr), i))
+		}
+	} else if len(startStr) == 1 && len(stopStr) == 1 && startStr[0] <= stopStr[0] {
+		for c := startStr[0]; c <= stopStr[0]; c += byte(step) {
+			items = append(items, string(c))
+		}
+	} else {
+		return nil, fmt.Errorf("invalid range %q in %q", spec, name)
+	}
+
+	var names []string
+	for _, item := range items {
+		rest, err := expandRange(suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rest {
+			names = append(names, prefix+item+r)
+		}
+	}
+	return names, nil
+}
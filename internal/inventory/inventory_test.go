@@ -0,0 +1,169 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testInventory = `# comment
+bastion ansible_host=10.0.0.1
+
+[web]
+web[01:03].example.com ansible_user=deploy
+web-[a:c:2] ansible_port=2222
+
+[db]
+db1 ansible_host="10.0.1.1" ansible_ssh_user='postgres'
+web01.example.com
+
+[prod:children]
+web
+db
+
+[all:vars]
+remote_pull_bandwidth_weight=1
+
+[prod:vars]
+ansible_user = ops
+role="prod server"
+
+[web:vars]
+ansible_user=web
+`
+
+func loadString(t *testing.T, content string) (*Inventory, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "hosts.ini")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(file)
+}
+
+func hostNames(hosts []Host) []string {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.Name
+	}
+	return names
+}
+
+func TestResolve(t *testing.T) {
+	inv, err := loadString(t, testInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		limit string
+		want  []string
+	}{
+		{"", []string{"bastion", "web01.example.com", "web02.example.com", "web03.example.com", "web-a", "web-c", "db1"}},
+		{"web", []string{"web01.example.com", "web02.example.com", "web03.example.com", "web-a", "web-c"}},
+		{"prod:!db", []string{"web02.example.com", "web03.example.com", "web-a", "web-c"}},
+		{"web:&db", []string{"web01.example.com"}},
+		{"web0*", []string{"web01.example.com", "web02.example.com", "web03.example.com"}},
+		{"db1,bastion", []string{"bastion", "db1"}},
+		{"ungrouped", []string{"bastion"}},
+	}
+	for _, tt := range tests {
+		hosts, err := inv.Resolve(tt.limit)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.limit, err)
+			continue
+		}
+		if got := hostNames(hosts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve(%q) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+
+	for _, limit := range []string{"missing", "web:&bastion", "!web"} {
+		if _, err := inv.Resolve(limit); err == nil {
+			t.Errorf("Resolve(%q) succeeded, want an error", limit)
+		}
+	}
+}
+
+func TestResolveVars(t *testing.T) {
+	inv, err := loadString(t, testInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inv.Resolve("web01.example.com,db1,web-a,bastion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host            Host
+		address, user   string
+		port, role      string
+		bandwidthWeight float64
+	}{
+		// Host variables override those of the deeper web group, which
+		// override those of prod
+		{hosts[1], "web01.example.com", "deploy", "", "prod server", 1},
+		{hosts[2], "web-a", "web", "2222", "prod server", 1},
+		{hosts[3], "10.0.1.1", "ops", "", "prod server", 1},
+		{hosts[0], "10.0.0.1", "", "", "", 1},
+	}
+	for _, tt := range tests {
+		h := tt.host
+		weight, err := h.BandwidthWeight()
+		if err != nil {
+			t.Errorf("%s: %v", h.Name, err)
+		}
+		if h.Address() != tt.address || h.User() != tt.user || h.Port() != tt.port || h.Vars["role"] != tt.role || weight != tt.bandwidthWeight {
+			t.Errorf("%s: got address %q, user %q, port %q, role %q, weight %v; want %q, %q, %q, %q, %v",
+				h.Name, h.Address(), h.User(), h.Port(), h.Vars["role"], weight, tt.address, tt.user, tt.port, tt.role, tt.bandwidthWeight)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{"[web\nhost1\n", ":1: malformed section header"},
+		{"[web:foo]\n", ":1: unknown section type"},
+		{"host1 ansible_user='deploy\n", ":1: unterminated quote"},
+		{"host1 ansible_user\n", ":1: expected key=value"},
+		{"web[01:x]\n", ":1: invalid range"},
+		{"web[03:01]\n", ":1: invalid range"},
+		{"web[01:03:0]\n", ":1: invalid range step"},
+		{"[web:vars]\nansible_user\n", ":2: expected key=value in [web:vars]"},
+		{"[a:children]\na\n", ":2: group \"a\" cannot be a child of \"a\""},
+		{"[a:children]\nb\n[b:children]\na\n", ":4: group \"a\" cannot be a child of \"b\""},
+		{"[a:children]\nb\n[b:children]\nc\n[c:children]\na\n", ":6: group \"a\" cannot be a child of \"c\""},
+	}
+	for _, tt := range tests {
+		_, err := loadString(t, tt.content)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%q) = %v, want an error containing %q", tt.content, err, tt.want)
+		}
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"web", []string{"web"}},
+		{"web[1:3]", []string{"web1", "web2", "web3"}},
+		{"web[08:10].example.com", []string{"web08.example.com", "web09.example.com", "web10.example.com"}},
+		{"db-[a:e:2]", []string{"db-a", "db-c", "db-e"}},
+		{"r[1:2]n[a:b]", []string{"r1na", "r1nb", "r2na", "r2nb"}},
+	}
+	for _, tt := range tests {
+		got, err := expandRange(tt.name)
+		if err != nil {
+			t.Errorf("expandRange(%q): %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandRange(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
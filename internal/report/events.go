@@ -0,0 +1,125 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"remote-pull/internal/console"
+	"remote-pull/internal/transfer"
+)
+
+// progressInterval is the minimum time between progress events of a host.
+const progressInterval = 500 * time.Millisecond
+
+// event is one line of the event stream. Which fields are set depends on
+// Event: host-start, phase, progress, host-end or finish.
+type event struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Target string    `json:"target,omitempty"`
+	Phase  string    `json:"phase,omitempty"`
+	// Sent and Total are the bytes of a progress event.
+	Sent  int64 `json:"sent,omitempty"`
+	Total int64 `json:"total,omitempty"`
+	// Result is the outcome of a host-end event.
+	Result *jsonResult `json:"result,omitempty"`
+	// Succeeded and Failed count the hosts of the finish event.
+	Succeeded *int `json:"succeeded,omitempty"`
+	Failed    *int `json:"failed,omitempty"`
+}
+
+// events streams newline-delimited JSON events while the run is going on,
+// for other programs to follow it live. The stream stays open until the
+// process exits, since a compose deployment finishes a run per image.
+type events struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	last map[string]time.Time
+}
+
+// NewEvents returns the reporter streaming events to dest, which is
+// "fd:N" for an open file descriptor or "unix:PATH" for a unix socket
+// another program listens on.
+func NewEvents(dest string) (transfer.Reporter, error) {
+	var w io.Writer
+	switch kind, arg, _ := strings.Cut(dest, ":"); kind {
+	case "fd":
+		fd, err := strconv.Atoi(arg)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid events file descriptor %q", arg)
+		}
+		f := os.NewFile(uintptr(fd), "events")
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("events file descriptor %d is not open: %v", fd, err)
+		}
+		w = f
+	case "unix":
+		conn, err := net.Dial("unix", arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to events socket: %v", err)
+		}
+		w = conn
+	default:
+		return nil, fmt.Errorf("invalid events destination %q, expected fd:N or unix:PATH", dest)
+	}
+	return &events{enc: json.NewEncoder(w), last: map[string]time.Time{}}, nil
+}
+
+// emit writes e. Once the consumer went away the stream is given up, the
+// run itself continues.
+func (r *events) emit(e event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if err := r.enc.Encode(e); err != nil {
+		console.Printf("[WARNING] Stopped writing events: %v\n", err)
+		r.enc = nil
+	}
+}
+
+func (r *events) BeginHost(target string) {
+	r.emit(event{Event: "host-start", Target: target})
+}
+
+func (r *events) Phase(target, phase string) {
+	r.emit(event{Event: "phase", Target: target, Phase: phase})
+}
+
+func (r *events) Progress(target string, sent, total int64) {
+	r.mu.Lock()
+	now := time.Now()
+	due := now.Sub(r.last[target]) >= progressInterval || sent >= total
+	if due {
+		r.last[target] = now
+	}
+	r.mu.Unlock()
+	if due {
+		r.emit(event{Event: "progress", Target: target, Sent: sent, Total: total})
+	}
+}
+
+func (r *events) EndHost(result transfer.Result) {
+	r.emit(event{Event: "host-end", Target: result.Target, Result: newJSONResult(result)})
+}
+
+func (r *events) Finish(results []transfer.Result) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	succeeded := len(results) - failed
+	r.emit(event{Event: "finish", Succeeded: &succeeded, Failed: &failed})
+	return nil
+}
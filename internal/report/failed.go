@@ -0,0 +1,110 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"remote-pull/internal/transfer"
+)
+
+// Failed records the hosts a run failed on, including those skipped as
+// unreachable, so that retry-failed can repeat the run for them.
+type Failed struct {
+	// Images lists the hosts each image failed on.
+	Images []FailedImage `json:"images,omitempty"`
+	// Hosts are the hosts a compose deployment failed on; the images come
+	// from the compose file given in Args.
+	Hosts []string `json:"hosts,omitempty"`
+	// Args are the options of the run, without the ones selecting targets.
+	Args []string `json:"args,omitempty"`
+}
+
+// FailedImage is an image and the hosts it failed on.
+type FailedImage struct {
+	Image string   `json:"image"`
+	Hosts []string `json:"hosts"`
+}
+
+// Add records that image failed on host.
+func (f *Failed) Add(image, host string) {
+	for i := range f.Images {
+		if f.Images[i].Image == image {
+			if !slices.Contains(f.Images[i].Hosts, host) {
+				f.Images[i].Hosts = append(f.Images[i].Hosts, host)
+			}
+			return
+		}
+	}
+	f.Images = append(f.Images, FailedImage{Image: image, Hosts: []string{host}})
+}
+
+// ReadFailed reads the record written by a run with Options.Failed.
+func ReadFailed(path string) (*Failed, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record struct {
+		Failed
+		// Image is the single image of records written by earlier
+		// versions, with the hosts it failed on in Hosts.
+		Image string `json:"image"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid failed hosts file %s: %v", path, err)
+	}
+	failed := &record.Failed
+	if record.Image != "" {
+		failed.Images = append(failed.Images, FailedImage{Image: record.Image, Hosts: failed.Hosts})
+		failed.Hosts = nil
+	}
+	if len(failed.Images) == 0 && len(failed.Hosts) == 0 {
+		return nil, fmt.Errorf("failed hosts file %s lists no images or hosts", path)
+	}
+	for _, image := range failed.Images {
+		if image.Image == "" || len(image.Hosts) == 0 {
+			return nil, fmt.Errorf("failed hosts file %s lists an image without hosts", path)
+		}
+	}
+	return failed, nil
+}
+
+// WriteFailed writes failed to path, or removes path when nothing failed.
+func WriteFailed(path string, failed Failed) error {
+	if len(failed.Images) == 0 && len(failed.Hosts) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove failed hosts file: %v", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(failed, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write failed hosts file: %v", err)
+	}
+	return nil
+}
+
+// failedHosts writes the Failed record of a run, or removes an earlier one
+// when all hosts succeeded.
+type failedHosts struct {
+	path string
+	args []string
+}
+
+func (r *failedHosts) BeginHost(target string)        {}
+func (r *failedHosts) EndHost(result transfer.Result) {}
+
+func (r *failedHosts) Finish(results []transfer.Result) error {
+	failed := Failed{Args: r.args}
+	for _, result := range results {
+		if result.Err != nil {
+			failed.Add(result.Image, result.Target)
+		}
+	}
+	return WriteFailed(r.path, failed)
+}
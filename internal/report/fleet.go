@@ -0,0 +1,197 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/internal/transfer"
+)
+
+// FleetFormats lists the formats of the fleet report.
+var FleetFormats = []string{"text", "csv", "json", "html"}
+
+// WriteFleet writes the fleet inventory in format to path, or prints it when
+// path is empty. With driftOnly only repositories whose hosts run different
+// versions are included.
+func WriteFleet(fleet *transfer.Fleet, format, path string, driftOnly bool) error {
+	var repos []transfer.FleetRepository
+	drifted := 0
+	for _, repo := range fleet.Repositories {
+		if repo.Drift() {
+			drifted++
+		}
+		if repo.Drift() || !driftOnly {
+			repos = append(repos, repo)
+		}
+	}
+
+	var b bytes.Buffer
+	var err error
+	switch format {
+	case "text":
+		fleetText(&b, fleet, repos, drifted)
+	case "csv":
+		err = fleetCSV(&b, repos)
+	case "json":
+		err = fleetJSON(&b, fleet, repos)
+	case "html":
+		err = fleetHTML(&b, fleet, repos, drifted)
+	default:
+		return fmt.Errorf("unknown report format %q, expected one of %s", format, strings.Join(FleetFormats, ", "))
+	}
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		console.Printf("%s", b.String())
+		return nil
+	}
+	if err := writeFile(path, b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write fleet report: %v", err)
+	}
+	console.Printf("[REPORT] Fleet report written to %s\n", path)
+	return nil
+}
+
+func fleetText(b *bytes.Buffer, fleet *transfer.Fleet, repos []transfer.FleetRepository, drifted int) {
+	fmt.Fprintf(b, "[REPORT] %d hosts (%d failed), %d repositories, %d with version drift\n",
+		len(fleet.Hosts), fleet.Failed(), len(fleet.Repositories), drifted)
+	for _, repo := range repos {
+		status := "[OK]   "
+		if repo.Drift() {
+			status = "[DRIFT]"
+		}
+		fmt.Fprintf(b, "%s %s\n", status, repo.Name)
+		for _, version := range repo.Versions {
+			fmt.Fprintf(b, "  %-12s %-24s %s\n", shortImageID(version.ID), tagList(version.Tags), strings.Join(version.Hosts, ", "))
+		}
+		if len(repo.Missing) > 0 {
+			fmt.Fprintf(b, "  %-12s %-24s %s\n", "missing", "", strings.Join(repo.Missing, ", "))
+		}
+	}
+}
+
+// fleetCSV writes one row per repository, version and host.
+func fleetCSV(b *bytes.Buffer, repos []transfer.FleetRepository) error {
+	w := csv.NewWriter(b)
+	w.Write([]string{"repository", "image_id", "tags", "digests", "host", "drift"})
+	for _, repo := range repos {
+		drift := fmt.Sprint(repo.Drift())
+		for _, version := range repo.Versions {
+			for _, host := range version.Hosts {
+				w.Write([]string{repo.Name, version.ID, strings.Join(version.Tags, " "), strings.Join(version.Digests, " "), host, drift})
+			}
+		}
+		for _, host := range repo.Missing {
+			w.Write([]string{repo.Name, "", "", "", host, drift})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+type jsonFleet struct {
+	Hosts        []jsonFleetHost       `json:"hosts"`
+	Repositories []jsonFleetRepository `json:"repositories"`
+}
+
+type jsonFleetHost struct {
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+type jsonFleetRepository struct {
+	Name     string             `json:"name"`
+	Drift    bool               `json:"drift"`
+	Versions []jsonFleetVersion `json:"versions"`
+	Missing  []string           `json:"missing,omitempty"`
+}
+
+type jsonFleetVersion struct {
+	ImageID string   `json:"image_id"`
+	Tags    []string `json:"tags,omitempty"`
+	Digests []string `json:"digests,omitempty"`
+	Hosts   []string `json:"hosts"`
+}
+
+func fleetJSON(b *bytes.Buffer, fleet *transfer.Fleet, repos []transfer.FleetRepository) error {
+	out := jsonFleet{Hosts: []jsonFleetHost{}, Repositories: []jsonFleetRepository{}}
+	for _, host := range fleet.Hosts {
+		jh := jsonFleetHost{Target: host.Target}
+		if host.Err != nil {
+			jh.Error = host.Err.Error()
+		}
+		out.Hosts = append(out.Hosts, jh)
+	}
+	for _, repo := range repos {
+		jr := jsonFleetRepository{Name: repo.Name, Drift: repo.Drift(), Missing: repo.Missing}
+		for _, version := range repo.Versions {
+			jr.Versions = append(jr.Versions, jsonFleetVersion{ImageID: version.ID, Tags: version.Tags, Digests: version.Digests, Hosts: version.Hosts})
+		}
+		out.Repositories = append(out.Repositories, jr)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	b.Write(append(data, '\n'))
+	return nil
+}
+
+var fleetTemplate = template.Must(template.New("fleet").Funcs(template.FuncMap{
+	"short": shortImageID,
+	"tags":  tagList,
+	"join":  strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>remote-pull fleet report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.drift th { background: #f8d7da; }
+.ok th { background: #d4edda; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>Fleet report</h1>
+<p>{{len .Fleet.Hosts}} hosts ({{.Fleet.Failed}} failed), {{len .Fleet.Repositories}} repositories, {{.Drifted}} with version drift</p>
+{{range .Repositories}}<table class="{{if .Drift}}drift{{else}}ok{{end}}">
+<tr><th colspan="3">{{.Name}}{{if .Drift}} &mdash; drift{{end}}</th></tr>
+<tr><td>Image ID</td><td>Tags</td><td>Hosts</td></tr>
+{{range .Versions}}<tr><td><code title="{{.ID}}">{{short .ID}}</code></td><td>{{tags .Tags}}</td><td>{{join .Hosts ", "}}</td></tr>
+{{end}}{{if .Missing}}<tr><td>missing</td><td></td><td>{{join .Missing ", "}}</td></tr>
+{{end}}</table>
+{{end}}{{range .Fleet.Hosts}}{{if .Err}}<p>Failed: {{.Target}}: {{.Err}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+func fleetHTML(b *bytes.Buffer, fleet *transfer.Fleet, repos []transfer.FleetRepository, drifted int) error {
+	return fleetTemplate.Execute(b, map[string]any{"Fleet": fleet, "Repositories": repos, "Drifted": drifted})
+}
+
+// shortImageID abbreviates an image ID the way docker prints it.
+func shortImageID(id string) string {
+	hex := strings.TrimPrefix(id, "sha256:")
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
+
+func tagList(tags []string) string {
+	if len(tags) == 0 {
+		return "<none>"
+	}
+	return strings.Join(tags, ", ")
+}
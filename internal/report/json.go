@@ -0,0 +1,73 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"remote-pull/internal/transfer"
+)
+
+// jsonReport writes all results, including the per-layer breakdown, as a
+// JSON document.
+type jsonReport struct {
+	path string
+}
+
+type jsonResult struct {
+	Target          string      `json:"target"`
+	Image           string      `json:"image"`
+	Status          string      `json:"status"`
+	ImageID         string      `json:"image_id,omitempty"`
+	Bytes           int64       `json:"bytes"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	Error           string      `json:"error,omitempty"`
+	Layers          []jsonLayer `json:"layers,omitempty"`
+}
+
+type jsonLayer struct {
+	ID              string  `json:"id"`
+	Size            int64   `json:"size"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Skipped         bool    `json:"skipped"`
+}
+
+func newJSONResult(result transfer.Result) *jsonResult {
+	jr := &jsonResult{
+		Target:          result.Target,
+		Image:           result.Image,
+		Status:          result.Status,
+		ImageID:         result.ImageID,
+		Bytes:           result.Bytes,
+		DurationSeconds: result.Duration.Seconds(),
+	}
+	if result.Err != nil {
+		jr.Error = result.Err.Error()
+	}
+	for _, layer := range result.Layers {
+		jr.Layers = append(jr.Layers, jsonLayer{
+			ID:              layer.ID,
+			Size:            layer.Size,
+			DurationSeconds: layer.Duration.Seconds(),
+			Skipped:         layer.Skipped,
+		})
+	}
+	return jr
+}
+
+func (r *jsonReport) BeginHost(target string)        {}
+func (r *jsonReport) EndHost(result transfer.Result) {}
+
+func (r *jsonReport) Finish(results []transfer.Result) error {
+	out := make([]*jsonResult, 0, len(results))
+	for _, result := range results {
+		out = append(out, newJSONResult(result))
+	}
+	data, err := json.MarshalIndent(map[string]any{"results": out}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(r.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write JSON report: %v", err)
+	}
+	return nil
+}
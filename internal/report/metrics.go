@@ -0,0 +1,77 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/transfer"
+)
+
+// metrics writes Prometheus metrics in the text exposition format, to be
+// picked up by node_exporter's textfile collector.
+type metrics struct {
+	path string
+}
+
+func (m *metrics) BeginHost(target string)        {}
+func (m *metrics) EndHost(result transfer.Result) {}
+
+func (m *metrics) Finish(results []transfer.Result) error {
+	var b strings.Builder
+	help := func(name, typ, text string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, text, name, typ)
+	}
+
+	help("remote_pull_transfer_success", "gauge", "Whether the image is present on the host after the run.")
+	for _, r := range results {
+		fmt.Fprintf(&b, "remote_pull_transfer_success{%s,status=%q} %d\n", hostLabels(r), r.Status, boolValue(r.Err == nil))
+	}
+	help("remote_pull_transfer_bytes", "gauge", "Size of the archive sent to the host.")
+	for _, r := range results {
+		fmt.Fprintf(&b, "remote_pull_transfer_bytes{%s} %d\n", hostLabels(r), r.Bytes)
+	}
+	help("remote_pull_transfer_seconds", "gauge", "Duration of the run for the host.")
+	for _, r := range results {
+		fmt.Fprintf(&b, "remote_pull_transfer_seconds{%s} %s\n", hostLabels(r), formatFloat(r.Duration.Seconds()))
+	}
+
+	help("remote_pull_layer_bytes", "gauge", "Size of an image layer.")
+	for _, r := range results {
+		for _, l := range r.Layers {
+			fmt.Fprintf(&b, "remote_pull_layer_bytes{%s,layer=%q} %d\n", hostLabels(r), l.ID, l.Size)
+		}
+	}
+	help("remote_pull_layer_transfer_seconds", "gauge", "Time spent sending an image layer.")
+	for _, r := range results {
+		for _, l := range r.Layers {
+			fmt.Fprintf(&b, "remote_pull_layer_transfer_seconds{%s,layer=%q} %s\n", hostLabels(r), l.ID, formatFloat(l.Duration.Seconds()))
+		}
+	}
+	help("remote_pull_layer_skipped", "gauge", "Whether an image layer was skipped because the host already had it.")
+	for _, r := range results {
+		for _, l := range r.Layers {
+			fmt.Fprintf(&b, "remote_pull_layer_skipped{%s,layer=%q} %d\n", hostLabels(r), l.ID, boolValue(l.Skipped))
+		}
+	}
+
+	if err := writeFile(m.path, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	return nil
+}
+
+func hostLabels(r transfer.Result) string {
+	return fmt.Sprintf("target=%q,image=%q", r.Target, r.Image)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,60 @@
+// Package report writes the results of a run to files for other tools: a
+// JSON document, Prometheus metrics in the node_exporter textfile format, the
+// hosts to retry, and the image inventory of a fleet of hosts.
+package report
+
+import (
+	"os"
+	"path/filepath"
+
+	"remote-pull/internal/transfer"
+)
+
+// Options selects the reports to write.
+type Options struct {
+	// JSON is the file the JSON report is written to.
+	JSON string
+	// Metrics is the file Prometheus metrics are written to.
+	Metrics string
+	// Failed is the file the hosts a run failed on are recorded in, with
+	// FailedArgs as the options to repeat the run with (see Failed).
+	Failed     string
+	FailedArgs []string
+}
+
+// New returns a reporter for every report selected in opts.
+func New(opts Options) []transfer.Reporter {
+	var reporters []transfer.Reporter
+	if opts.JSON != "" {
+		reporters = append(reporters, &jsonReport{path: opts.JSON})
+	}
+	if opts.Metrics != "" {
+		reporters = append(reporters, &metrics{path: opts.Metrics})
+	}
+	if opts.Failed != "" {
+		reporters = append(reporters, &failedHosts{path: opts.Failed, args: opts.FailedArgs})
+	}
+	return reporters
+}
+
+// writeFile replaces path atomically, so that collectors never read a
+// partially written report.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,161 @@
+package transfer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"remote-pull/internal/agent"
+	"remote-pull/internal/checksum"
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// agentDir is where the agent is installed on the remote, relative to the
+// home directory.
+const agentDir = ".cache/remote-pull"
+
+// agentPath returns the path of the agent on the remote, installing it on
+// first use. It returns "" when the agent is not enabled or cannot run on
+// the remote, in which case the archive is sent without it.
+func (r *remoteHost) agentPath(opts Options) string {
+	if !opts.Agent {
+		return ""
+	}
+	r.agentOnce.Do(func() {
+		var err error
+		if r.agent, err = r.installAgent(); err != nil {
+			console.Printf("[WARNING] Agent not available on %s, continuing without it: %v\n", r.host, err)
+		}
+	})
+	return r.agent
+}
+
+// installAgent copies this binary to the remote unless the same version is
+// installed already, replacing any other version. The agent is identified
+// by the hash of the binary, so updating remote-pull updates the agent.
+func (r *remoteHost) installAgent() (string, error) {
+	if r.windows() {
+		return "", fmt.Errorf("not supported on Windows remotes")
+	}
+	id, err := agent.Identity()
+	if err != nil {
+		return "", err
+	}
+	path := agentDir + "/remote-pull-agent-" + id
+	version := func() bool {
+		output, err := r.run(r.quote(path) + " agent version")
+		return err == nil && strings.TrimSpace(output) == id
+	}
+	if version() {
+		return path, nil
+	}
+
+	output, err := r.run("uname -sm")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect remote platform: %v", err)
+	}
+	goos, goarch := unamePlatform(output)
+	if goos != runtime.GOOS || goarch != runtime.GOARCH {
+		return "", fmt.Errorf("remote is %s/%s, this binary is built for %s/%s", goos, goarch, runtime.GOOS, runtime.GOARCH)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	console.Printf("[AGENT] Installing agent %s on %s\n", id, r.host)
+	if _, err := r.run("mkdir -p " + agentDir + " && rm -f " + agentDir + "/remote-pull-agent-*"); err != nil {
+		return "", fmt.Errorf("failed to prepare %s: %v", agentDir, err)
+	}
+	partial := r.quote(path + ".partial")
+	install := "chmod 755 " + partial + " && mv " + partial + " " + r.quote(path)
+	if err := ssh.ShellCopyAndRun(r.ctx, exe, partial, install, r.user, r.host, 0, r.sshOpts); err != nil {
+		r.run("rm -f " + partial)
+		return "", fmt.Errorf("failed to install agent: %v", err)
+	}
+	if !version() {
+		return "", fmt.Errorf("installed agent does not run on the remote")
+	}
+	return path, nil
+}
+
+// unamePlatform converts the output of "uname -sm" to GOOS and GOARCH.
+func unamePlatform(output string) (string, string) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return "", ""
+	}
+	goos := strings.ToLower(fields[0])
+	switch arch := fields[1]; arch {
+	case "x86_64", "amd64":
+		return goos, "amd64"
+	case "aarch64", "arm64":
+		return goos, "arm64"
+	case "i386", "i686":
+		return goos, "386"
+	default:
+		if strings.HasPrefix(arch, "armv") {
+			return goos, "arm"
+		}
+		return goos, arch
+	}
+}
+
+// agentLoad sends archive through the agent at path, which verifies every
+// chunk with the Options.Checksum algorithm before passing it to docker load
+// and reports its progress. With
+// Options.AgentCache the agent keeps loaded archives and an archive it has
+// already seen is loaded from its cache without sending anything.
+func agentLoad(archive, path string, remote *remoteHost, opts Options, sshOpts ssh.Options) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	load := remote.quote(remote.loadCommand(""))
+	algorithm := valueOr(opts.Checksum, checksum.SHA256)
+	cmd := fmt.Sprintf("%s agent load --checksum %s --size %d --load %s", remote.quote(path), algorithm, info.Size(), load)
+	if opts.AgentCache > 0 {
+		h, err := checksum.New(algorithm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		digest := hex.EncodeToString(h.Sum(nil))
+
+		output, err := remote.run(fmt.Sprintf("%s agent cached --checksum %s --load %s %s", remote.quote(path), algorithm, load, digest))
+		if err != nil {
+			console.Printf("[WARNING] Agent cache lookup failed on %s: %v\n", remote.host, err)
+		} else if lines := strings.Fields(output); len(lines) > 0 && lines[len(lines)-1] == "hit" {
+			console.Printf("[AGENT] Archive loaded from the agent cache on %s, nothing transferred\n", remote.host)
+			return nil
+		}
+		cmd += fmt.Sprintf(" --digest %s --cache %d", digest, opts.AgentCache*1024*1024)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(agent.Encode(w, f, algorithm))
+	}()
+	err = ssh.PipeRun(remote.ctx, r, agent.EncodedSize(info.Size(), algorithm), archive, cmd, remote.user, remote.host, opts.LoadTimeout, sshOpts)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	console.Printf("[STRATEGY] Transferred to %s with the agent\n", remote.host)
+	return nil
+}
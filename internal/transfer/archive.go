@@ -0,0 +1,125 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"remote-pull/internal/console"
+)
+
+// archiveCache shares the archives saved during a run to several hosts, so
+// each image (and platform) is exported only once.
+type archiveCache struct {
+	// ctx bounds the shared exports, which outlive the timeout of the
+	// host that started them
+	ctx     context.Context
+	mu      sync.Mutex
+	entries map[string]*sharedArchive
+}
+
+type sharedArchive struct {
+	once   sync.Once
+	path   string
+	remove func()
+	err    error
+}
+
+func newArchiveCache(ctx context.Context) *archiveCache {
+	return &archiveCache{ctx: ctx, entries: map[string]*sharedArchive{}}
+}
+
+// removeAll removes the archives of the run.
+func (c *archiveCache) removeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.remove != nil {
+			entry.remove()
+		}
+	}
+	c.entries = map[string]*sharedArchive{}
+}
+
+// saveArchive exports imageName from src to the local temp directory and
+// returns its path and a function removing it again. In a run to several
+// hosts (opts.archives is set) the archive is saved by the first host that
+// needs it and shared with the others, which wait for it; it is removed at
+// the end of the run. platform is the os/arch the archive is saved for.
+func saveArchive(ctx context.Context, imageName, platform string, src imageSource, opts Options) (string, func(), error) {
+	if opts.archives == nil {
+		return exportArchive(ctx, imageName, src, opts)
+	}
+	key := imageName
+	if _, ok := src.(platformSource); ok {
+		key += " " + platform
+	}
+	opts.archives.mu.Lock()
+	entry := opts.archives.entries[key]
+	if entry == nil {
+		entry = &sharedArchive{}
+		opts.archives.entries[key] = entry
+	}
+	opts.archives.mu.Unlock()
+
+	saved := false
+	entry.once.Do(func() {
+		entry.path, entry.remove, entry.err = exportArchive(opts.archives.ctx, imageName, src, opts)
+		saved = true
+	})
+	if entry.err == nil && !saved {
+		console.Printf("[SHARED] Using archive %s of %s\n", entry.path, imageName)
+	}
+	return entry.path, func() {}, entry.err
+}
+
+// exportArchive saves imageName from src under a unique name in the local
+// temp directory, removing it again if the process is interrupted.
+func exportArchive(ctx context.Context, imageName string, src imageSource, opts Options) (string, func(), error) {
+	archiveName, err := uniqueArchiveName(imageName)
+	if err != nil {
+		return "", nil, fmt.Errorf("[ERROR] %v", err)
+	}
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	if err := checkLocalSpace(ctx, imageName, tmpDir, src); err != nil {
+		return "", nil, fmt.Errorf("[ERROR] %v", err)
+	}
+	tmpFile := filepath.Join(tmpDir, archiveName)
+	console.Printf("[PREPARING] Creating temporary archive at %s\n", tmpFile)
+
+	removeLocal := func() {
+		console.Printf("[CLEANUP] Removing temporary archive %s\n", tmpFile)
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			console.Printf("[WARNING] Failed to remove temporary archive %s: %v\n", tmpFile, err)
+		}
+	}
+	unregister := onInterrupt(removeLocal)
+	remove := func() {
+		unregister()
+		removeLocal()
+	}
+
+	console.Printf("[SAVING] Exporting Docker image %q to archive via %s\n", imageName, src.describe())
+	if err := src.save(ctx, imageName, tmpFile); err != nil {
+		remove()
+		return "", nil, fmt.Errorf("[ERROR] Failed to save image: %v", err)
+	}
+	return tmpFile, remove, nil
+}
+
+// siblingFile creates an empty file with a unique name next to archive, for
+// copies derived from an archive that may be shared by concurrent
+// transfers. The name ends in suffix.
+func siblingFile(archive, suffix string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(archive), strings.TrimSuffix(filepath.Base(archive), ".tar")+"-*"+suffix)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,233 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// PolicyOptions selects an external policy that authorizes every transfer.
+type PolicyOptions struct {
+	// Rego is a Rego policy file evaluated with the local opa CLI. The
+	// decision is the document of package remote_pull.
+	Rego string
+	// URL is a policy webhook, typically OPA's data API such as
+	// https://opa.corp/v1/data/remote_pull. The input is POSTed as
+	// {"input": ...} and the decision read from "result".
+	URL string
+}
+
+func (p PolicyOptions) enabled() bool {
+	return p.Rego != "" || p.URL != ""
+}
+
+// policyQuery is the Rego document holding the decision.
+const policyQuery = "data.remote_pull"
+
+// policyTimeout bounds a single policy evaluation.
+const policyTimeout = 30 * time.Second
+
+// policyInput is the document policies decide on.
+type policyInput struct {
+	Image       policyImage       `json:"image"`
+	Destination policyDestination `json:"destination"`
+	Requester   policyRequester   `json:"requester"`
+}
+
+type policyImage struct {
+	Name         string            `json:"name"`
+	Reference    string            `json:"reference"`
+	Registry     string            `json:"registry"`
+	Repository   string            `json:"repository"`
+	Tag          string            `json:"tag,omitempty"`
+	Digest       string            `json:"digest,omitempty"`
+	ID           string            `json:"id"`
+	RepoDigests  []string          `json:"repo_digests"`
+	Labels       map[string]string `json:"labels"`
+	Created      string            `json:"created"`
+	OS           string            `json:"os"`
+	Architecture string            `json:"architecture"`
+	Size         int64             `json:"size"`
+}
+
+type policyDestination struct {
+	Host string `json:"host"`
+	User string `json:"user"`
+	Port string `json:"port,omitempty"`
+	OS   string `json:"os"`
+}
+
+type policyRequester struct {
+	User     string `json:"user"`
+	Hostname string `json:"hostname"`
+}
+
+// imageInspector provides the metadata of the image a policy decides on.
+type imageInspector interface {
+	inspect(ctx context.Context, imageName string) (*imageInfo, error)
+}
+
+// authorizeTransfer asks the configured policy whether imageName may be
+// sent to remote and returns an error with the policy's reasons if not.
+func authorizeTransfer(imageName string, remote *remoteHost, src imageInspector, policy PolicyOptions) error {
+	if !policy.enabled() {
+		return nil
+	}
+	info, err := src.inspect(remote.ctx, imageName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s for the policy check: %v", imageName, err)
+	}
+	ref := parseReference(imageName)
+	input := policyInput{
+		Image: policyImage{
+			Name:         imageName,
+			Reference:    ref.String(),
+			Registry:     ref.Registry,
+			Repository:   ref.Repository,
+			Tag:          ref.Tag,
+			Digest:       ref.Digest,
+			ID:           info.ID,
+			RepoDigests:  info.RepoDigests,
+			Labels:       info.Config.Labels,
+			Created:      info.Created,
+			OS:           info.OS,
+			Architecture: info.Architecture,
+			Size:         info.Size,
+		},
+		Destination: policyDestination{
+			Host: remote.host,
+			User: remote.user,
+			Port: remote.sshOpts.Port,
+			OS:   remote.os,
+		},
+		Requester: requester(),
+	}
+
+	console.Printf("[POLICY] Checking transfer of %s to %s\n", imageName, remote.host)
+	var decision json.RawMessage
+	if policy.Rego != "" {
+		decision, err = evalRego(policy.Rego, input)
+	} else {
+		decision, err = evalWebhook(policy.URL, input)
+	}
+	if err != nil {
+		return fmt.Errorf("policy evaluation failed: %v", err)
+	}
+	allowed, reasons, err := parseDecision(decision)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		if len(reasons) == 0 {
+			reasons = []string{"not allowed"}
+		}
+		return fmt.Errorf("transfer of %s to %s denied by policy: %s", imageName, remote.host, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+func requester() policyRequester {
+	r := policyRequester{User: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		r.User = u.Username
+	}
+	r.Hostname, _ = os.Hostname()
+	return r
+}
+
+// evalRego evaluates the policy file with `opa eval`.
+func evalRego(file string, input policyInput) (json.RawMessage, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("opa", "eval", "--format", "json", "--stdin-input", "--data", file, policyQuery)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = console.Writer("[opa] ")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("opa eval: %v", err)
+	}
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value json.RawMessage `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("unexpected opa output: %v", err)
+	}
+	// An undefined document yields no result, which denies
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return result.Result[0].Expressions[0].Value, nil
+}
+
+// evalWebhook posts the input to the policy service.
+func evalWebhook(url string, input policyInput) (json.RawMessage, error) {
+	data, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("REMOTE_PULL_POLICY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: policyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("unexpected response from %s: %v", url, err)
+	}
+	return result.Result, nil
+}
+
+// parseDecision accepts either a plain boolean or a document with an
+// "allow" boolean and optional "deny" reasons. Any deny reason refuses the
+// transfer even if allow is set; a missing decision refuses it as well.
+func parseDecision(decision json.RawMessage) (bool, []string, error) {
+	if len(decision) == 0 || string(decision) == "null" {
+		return false, []string{"policy returned no decision"}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(decision, &allow); err == nil {
+		return allow, nil, nil
+	}
+	var doc struct {
+		Allow bool     `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err := json.Unmarshal(decision, &doc); err != nil {
+		return false, nil, fmt.Errorf("unexpected policy decision %s: %v", decision, err)
+	}
+	return doc.Allow && len(doc.Deny) == 0, doc.Deny, nil
+}
@@ -0,0 +1,190 @@
+package transfer
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// remoteDockerfile is the name a Dockerfile from outside the build context
+// is given inside the streamed context.
+const remoteDockerfile = ".remote-pull.Dockerfile"
+
+// BuildOptions controls a build on the remote host.
+type BuildOptions struct {
+	// Dockerfile defaults to "Dockerfile" in the context directory.
+	Dockerfile string
+	Tags       []string
+	BuildArgs  []string
+	Target     string
+	Platform   string
+}
+
+// BuildRemote streams the build context in contextDir to the remote host and
+// builds it with the remote daemon. Files excluded by .dockerignore are not
+// sent. The context is never written to disk on either side.
+func BuildRemote(ctx context.Context, contextDir, remoteServer string, bopts BuildOptions, opts Options) error {
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+
+	dockerfile := bopts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+	if _, err := os.Stat(dockerfile); err != nil {
+		return fmt.Errorf("dockerfile not found: %v", err)
+	}
+
+	// The Dockerfile is referenced by its path inside the context, or added
+	// under a fixed name when it lives outside of it
+	dockerfileName := remoteDockerfile
+	if rel, err := filepath.Rel(contextDir, dockerfile); err == nil && !strings.HasPrefix(rel, "..") {
+		dockerfileName = filepath.ToSlash(rel)
+	}
+
+	matcher, err := dockerignore(contextDir, dockerfile)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"build", "-f", remote.quote(dockerfileName)}
+	for _, tag := range bopts.Tags {
+		args = append(args, "-t", remote.quote(tag))
+	}
+	for _, arg := range bopts.BuildArgs {
+		args = append(args, "--build-arg", remote.quote(arg))
+	}
+	if bopts.Target != "" {
+		args = append(args, "--target", remote.quote(bopts.Target))
+	}
+	if bopts.Platform != "" {
+		args = append(args, "--platform", remote.quote(bopts.Platform))
+	}
+	args = append(args, "-")
+
+	client, err := ssh.NewClient(remote.ctx, remote.user, remote.host, remote.sshOpts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, contextDir, matcher, dockerfile, dockerfileName))
+	}()
+
+	console.Printf("[BUILD] Streaming build context %s to %s\n", contextDir, remote)
+	if err := client.Stream(remote.command(remote.docker(strings.Join(args, " "))), pr); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("remote build failed: %v", err)
+	}
+	console.Printf("[SUCCESS] Built %s on %s\n", strings.Join(bopts.Tags, ", "), remote.host)
+	return nil
+}
+
+// dockerignore loads the ignore patterns of the build, preferring a
+// Dockerfile specific <Dockerfile>.dockerignore as docker does.
+func dockerignore(contextDir, dockerfile string) (*patternmatcher.PatternMatcher, error) {
+	for _, file := range []string{dockerfile + ".dockerignore", filepath.Join(contextDir, ".dockerignore")} {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		patterns, err := ignorefile.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		return patternmatcher.New(patterns)
+	}
+	return patternmatcher.New(nil)
+}
+
+// writeContext writes the files of contextDir not excluded by matcher as tar
+// stream to w. The Dockerfile is always included.
+func writeContext(w io.Writer, contextDir string, matcher *patternmatcher.PatternMatcher, dockerfile, dockerfileName string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(contextDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != dockerfileName && rel != ".dockerignore" {
+			excluded, err := matcher.MatchesOrParentMatches(rel)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				// A directory can only be skipped as a whole when no
+				// exclusion pattern could re-include something below it
+				if info.IsDir() && !matcher.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		return addToTar(tw, path, rel, info)
+	})
+	if err != nil {
+		return err
+	}
+	if dockerfileName == remoteDockerfile {
+		info, err := os.Stat(dockerfile)
+		if err != nil {
+			return err
+		}
+		if err := addToTar(tw, dockerfile, remoteDockerfile, info); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addToTar(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	// Ownership of the operator's files means nothing on the remote
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
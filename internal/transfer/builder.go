@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// DefaultBuildkitImage is the BuildKit image run by the docker-container
+// buildx driver.
+const DefaultBuildkitImage = "moby/buildkit:buildx-stable-1"
+
+// minVersionBuildkit is the first docker release shipping BuildKit.
+const minVersionBuildkit = "18.09"
+
+// BuilderOptions controls the setup of a remote buildx builder.
+type BuilderOptions struct {
+	// Name of the buildx builder, defaults to "remote-<host>".
+	Name string
+	// BuildkitImage is run on the remote by the builder. It is transferred
+	// first, so hosts without registry access can build as well.
+	BuildkitImage string
+	// SkipTransfer leaves fetching the BuildKit image to the remote daemon.
+	SkipTransfer bool
+}
+
+// SetupBuilder configures the remote host as buildx builder node: it checks
+// the remote docker, provides the BuildKit image, creates the builder and
+// bootstraps it to verify that BuildKit runs.
+func SetupBuilder(ctx context.Context, remoteServer string, bopts BuilderOptions, opts Options) error {
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	if remote.windows() {
+		return fmt.Errorf("buildx builders are only supported on linux hosts")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("builder setup requires the docker CLI: %v", err)
+	}
+	if err := exec.Command("docker", "buildx", "version").Run(); err != nil {
+		return fmt.Errorf("docker buildx is not available locally: %v", err)
+	}
+
+	console.Printf("[CHECKING] Verifying docker on %s\n", remote)
+	rt, err := inspectRemoteRuntime(remote)
+	if err != nil {
+		return err
+	}
+	if rt.Podman || rt.Containerd {
+		return fmt.Errorf("buildx builders need docker on %s, found %s", remote.host, rt)
+	}
+	if versionLess(rt.Version, minVersionBuildkit) {
+		return fmt.Errorf("docker %s on %s is too old for BuildKit (need %s or later)", rt.Version, remote.host, minVersionBuildkit)
+	}
+
+	if !bopts.SkipTransfer {
+		if err := TransferImage(ctx, bopts.BuildkitImage, remoteServer, opts); err != nil {
+			return fmt.Errorf("failed to provide %s: %v", bopts.BuildkitImage, err)
+		}
+	}
+
+	name := bopts.Name
+	if name == "" {
+		name = "remote-" + remote.host
+	}
+	endpoint := "ssh://" + ssh.Target{User: remote.user, Host: remote.host, Port: remote.sshOpts.Port}.String()
+	console.Printf("[BUILDER] Creating buildx builder %s on %s\n", name, endpoint)
+	create := exec.Command("docker", "buildx", "create", "--name", name,
+		"--driver", "docker-container", "--driver-opt", "image="+bopts.BuildkitImage, endpoint)
+	create.Stdout = console.Writer("")
+	create.Stderr = console.Writer("")
+	if err := create.Run(); err != nil {
+		return fmt.Errorf("failed to create builder %s: %v", name, err)
+	}
+
+	console.Printf("[BUILDER] Bootstrapping %s\n", name)
+	inspect := exec.Command("docker", "buildx", "inspect", "--bootstrap", name)
+	inspect.Stdout = console.Writer("")
+	inspect.Stderr = console.Writer("")
+	if err := inspect.Run(); err != nil {
+		return fmt.Errorf("builder %s was created but BuildKit did not start: %v", name, err)
+	}
+
+	console.Printf("[SUCCESS] Builder %s is ready, use it with: docker buildx build --builder %s .\n", name, name)
+	return nil
+}
@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// cleanups holds the functions that must run if the process is interrupted
+// before the regular (deferred) cleanup paths get a chance to execute.
+var cleanups = struct {
+	sync.Mutex
+	next  int
+	funcs map[int]func()
+}{funcs: map[int]func(){}}
+
+// onInterrupt registers f to run when the process receives SIGINT or SIGTERM.
+// The returned function unregisters it again.
+func onInterrupt(f func()) func() {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	id := cleanups.next
+	cleanups.next++
+	cleanups.funcs[id] = f
+	return func() {
+		cleanups.Lock()
+		defer cleanups.Unlock()
+		delete(cleanups.funcs, id)
+	}
+}
+
+// cleanupTimeout bounds removing the files of a transfer from the remote,
+// which also happens after the transfer was cancelled.
+const cleanupTimeout = 30 * time.Second
+
+// errInterrupted is the cause of the contexts cancelled by a termination
+// signal.
+var errInterrupted = errors.New("interrupted")
+
+// watching counts the callers of watchInterrupts; a single watcher serves
+// all of them, so hosts transferred to concurrently do not race to clean up
+// and exit. cancels holds the contexts to cancel once a signal arrives.
+var watching struct {
+	sync.Mutex
+	count   int
+	next    int
+	cancels map[int]context.CancelCauseFunc
+	stop    func()
+}
+
+// watchInterrupts runs all registered cleanups and exits once a termination
+// signal is received. The returned context is derived from ctx and cancelled
+// first, which aborts the remote commands run with it. The returned function
+// stops watching.
+func watchInterrupts(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	watching.Lock()
+	defer watching.Unlock()
+	if watching.count == 0 {
+		watching.cancels = map[int]context.CancelCauseFunc{}
+		watching.stop = startWatching()
+	}
+	watching.count++
+	id := watching.next
+	watching.next++
+	watching.cancels[id] = cancel
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel(nil)
+			watching.Lock()
+			defer watching.Unlock()
+			delete(watching.cancels, id)
+			if watching.count--; watching.count == 0 {
+				watching.stop()
+			}
+		})
+	}
+}
+
+// watchInterrupts watches for termination signals while operating on r,
+// whose commands are aborted once one arrives.
+func (r *remoteHost) watchInterrupts() func() {
+	parent := r.ctx
+	ctx, stop := watchInterrupts(parent)
+	r.ctx = ctx
+	return func() {
+		r.ctx = parent
+		stop()
+	}
+}
+
+func startWatching() func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			console.Printf("[INTERRUPTED] Received %v, cleaning up...\n", sig)
+			watching.Lock()
+			for _, cancel := range watching.cancels {
+				cancel(errInterrupted)
+			}
+			watching.Unlock()
+			// Stop remote scp/docker load first so they don't keep writing
+			// to the files that are about to be removed.
+			ssh.TerminateSessions()
+			cleanups.Lock()
+			funcs := cleanups.funcs
+			cleanups.funcs = map[int]func(){}
+			cleanups.Unlock()
+			for _, f := range funcs {
+				f()
+			}
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
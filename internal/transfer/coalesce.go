@@ -0,0 +1,65 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// lockTransfer serializes transfers of the same image to the same host
+// across all remote-pull processes of the local user, using a lock file per
+// image and host. It reports whether another transfer was in flight and had
+// to be waited for, in which case the caller should check the remote again
+// rather than repeating the work. The returned function releases the lock.
+func lockTransfer(imageName string, remote *remoteHost) (func(), bool, error) {
+	dir := filepath.Join(os.TempDir(), "remote-pull-locks")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, false, err
+	}
+	sum := sha256.Sum256([]byte(parseReference(imageName).String() + "\x00" + remote.String()))
+	f, err := os.OpenFile(filepath.Join(dir, hex.EncodeToString(sum[:12])+".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+
+	waited, err := lockFile(remote.ctx, f, func() {
+		console.Printf("[WAITING] Another transfer of %s to %s is in progress, waiting for it\n", imageName, remote)
+	})
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, waited, nil
+}
+
+// lockPollInterval is how often a lock held by another transfer is tried
+// again.
+const lockPollInterval = 250 * time.Millisecond
+
+// lockFile takes an exclusive lock on f, calling wait once when the lock is
+// held by someone else and trying again until it is released or ctx is done.
+// It reports whether it had to wait.
+func lockFile(ctx context.Context, f *os.File, wait func()) (bool, error) {
+	waited := false
+	for {
+		locked, err := tryLockFile(f)
+		if locked || err != nil {
+			return waited, err
+		}
+		if !waited {
+			wait()
+			waited = true
+		}
+		select {
+		case <-ctx.Done():
+			return true, context.Cause(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// remoteRuntime describes the container runtime found on the remote host.
+type remoteRuntime struct {
+	Version string
+	Driver  string
+	// OS and Arch are the platform of the daemon in GOOS/GOARCH notation.
+	OS   string `json:"Os"`
+	Arch string
+	// Podman is set for podman, which reports its platform as OSArch.
+	Podman bool
+	OSArch string `json:"OsArch"`
+	// Containerd is set for containerd, without docker or podman.
+	Containerd bool
+}
+
+func (rt *remoteRuntime) String() string {
+	switch {
+	case rt.Podman:
+		return RuntimePodman + " " + rt.Version
+	case rt.Containerd:
+		return RuntimeContainerd + " " + rt.Version
+	}
+	return RuntimeDocker + " " + rt.Version
+}
+
+// archiveFeatures lists the properties of a saved archive that constrain
+// which runtime versions can load it.
+type archiveFeatures struct {
+	// DockerManifest is set when the archive has a manifest.json, which every
+	// docker version understands. Archives with only an OCI index.json need
+	// a newer daemon.
+	DockerManifest bool
+	OCILayout      bool
+	ZstdLayers     bool
+
+	// Layers lists the layer files of the image in manifest order, with
+	// their position in the archive.
+	Layers []archiveLayer
+	// DiffIDs lists the layers of the image config in order, including any
+	// that share a layer file.
+	DiffIDs []string
+}
+
+// archiveLayer is a layer file inside a saved archive.
+type archiveLayer struct {
+	// ID is the layer digest (OCI layout) or legacy layer ID.
+	ID string
+	// DiffID is the digest of the uncompressed layer as listed in the image
+	// config, which is how docker identifies layers it already has.
+	DiffID string
+	Path   string
+	Size   int64
+	// Offset is the position of the layer data in the archive.
+	Offset int64
+	// CreatedBy is the build instruction that created the layer, if the
+	// image history has it.
+	CreatedBy string
+}
+
+const (
+	// Minimum remote docker versions for the archive features above
+	minVersionOCIArchive = "25.0"
+	minVersionZstd       = "23.0"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func inspectRemoteRuntime(remote *remoteHost) (*remoteRuntime, error) {
+	if remote.runtime == RuntimeContainerd {
+		return inspectContainerd(remote)
+	}
+	output, err := remote.run(remote.docker("version --format " + remote.quote("{{json .Server}}")))
+	if err != nil {
+		return nil, fmt.Errorf("docker is not usable on %s: %v", remote.host, err)
+	}
+	if strings.TrimSpace(output) == "null" {
+		// podman without a service has no server, the client is the runtime
+		output, err = remote.run(remote.docker("version --format " + remote.quote("{{json .Client}}")))
+		if err != nil {
+			return nil, fmt.Errorf("podman is not usable on %s: %v", remote.host, err)
+		}
+	}
+	rt := &remoteRuntime{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), rt); err != nil {
+		return nil, fmt.Errorf("unexpected remote docker version %q: %v", strings.TrimSpace(output), err)
+	}
+	if rt.Version == "" {
+		return nil, fmt.Errorf("remote docker on %s did not report its version", remote.host)
+	}
+	driver := "{{json .Driver}}"
+	if rt.OSArch != "" {
+		rt.Podman = true
+		rt.OS, rt.Arch, _ = strings.Cut(rt.OSArch, "/")
+		driver = "{{json .Store.GraphDriverName}}"
+	}
+
+	output, err = remote.run(remote.docker("info --format " + remote.quote(driver)))
+	if err == nil {
+		json.Unmarshal([]byte(strings.TrimSpace(output)), &rt.Driver)
+	}
+	return rt, nil
+}
+
+// inspectContainerd inspects containerd through nerdctl, which reports the
+// version of the containerd server among the server components, or ctr.
+func inspectContainerd(remote *remoteHost) (*remoteRuntime, error) {
+	rt := &remoteRuntime{Containerd: true}
+	if remote.ctr() {
+		version, err := ctrVersion(remote)
+		if err != nil {
+			return nil, err
+		}
+		rt.Version = version
+	} else {
+		var version struct {
+			Server struct {
+				Components []struct {
+					Name    string
+					Version string
+				}
+			}
+		}
+		if err := inspectRemote(remote, "version --format "+remote.quote("{{json .}}"), &version); err != nil {
+			return nil, fmt.Errorf("containerd is not usable on %s: %v", remote.host, err)
+		}
+		for _, component := range version.Server.Components {
+			if component.Name == "containerd" {
+				rt.Version = component.Version
+			}
+		}
+	}
+	if rt.Version == "" {
+		return nil, fmt.Errorf("containerd on %s did not report its version", remote.host)
+	}
+	output, err := remote.run("uname -sm")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the platform of %s: %v", remote.host, err)
+	}
+	rt.OS, rt.Arch = unamePlatform(output)
+	return rt, nil
+}
+
+// ctrVersion returns the version of the containerd server from ctr, which
+// has no structured output: it is the first "Version:" line after "Server:".
+func ctrVersion(remote *remoteHost) (string, error) {
+	output, err := remote.run(remote.docker("version"))
+	if err != nil {
+		return "", fmt.Errorf("containerd is not usable on %s: %v", remote.host, err)
+	}
+	_, server, _ := strings.Cut(output, "Server:")
+	for _, line := range strings.Split(server, "\n") {
+		if version, ok := strings.CutPrefix(strings.TrimSpace(line), "Version:"); ok {
+			return strings.TrimSpace(version), nil
+		}
+	}
+	return "", nil
+}
+
+// inspectArchive scans the tar archive at path for its manifest format and
+// the compression of its layers.
+func inspectArchive(path string) (*archiveFeatures, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	features := &archiveFeatures{}
+	entries := map[string]archiveLayer{}
+	var manifest []struct {
+		Config string   `json:"Config"`
+		Layers []string `json:"Layers"`
+	}
+	tr := tar.NewReader(f)
+	magic := make([]byte, len(zstdMagic))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %v", path, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			// The reader seeks over file data, so the file position is the
+			// start of this entry's data
+			offset, _ := f.Seek(0, io.SeekCurrent)
+			entries[hdr.Name] = archiveLayer{Path: hdr.Name, Size: hdr.Size, Offset: offset}
+		}
+		switch {
+		case hdr.Name == "manifest.json":
+			features.DockerManifest = true
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest.json in %s: %v", path, err)
+			}
+		case hdr.Name == "oci-layout" || hdr.Name == "index.json":
+			features.OCILayout = true
+		case hdr.Typeflag == tar.TypeReg && hdr.Size >= int64(len(magic)):
+			if _, err := io.ReadFull(tr, magic); err == nil && bytes.Equal(magic, zstdMagic) {
+				features.ZstdLayers = true
+			}
+		}
+	}
+
+	if len(manifest) > 0 {
+		var diffIDs, createdBy []string
+		if config, ok := entries[manifest[0].Config]; ok {
+			var image struct {
+				RootFS struct {
+					DiffIDs []string `json:"diff_ids"`
+				} `json:"rootfs"`
+				History []struct {
+					CreatedBy  string `json:"created_by"`
+					EmptyLayer bool   `json:"empty_layer"`
+				} `json:"history"`
+			}
+			if err := json.NewDecoder(io.NewSectionReader(f, config.Offset, config.Size)).Decode(&image); err == nil &&
+				len(image.RootFS.DiffIDs) == len(manifest[0].Layers) {
+				diffIDs = image.RootFS.DiffIDs
+				features.DiffIDs = diffIDs
+			}
+			for _, h := range image.History {
+				if !h.EmptyLayer {
+					createdBy = append(createdBy, h.CreatedBy)
+				}
+			}
+			if len(createdBy) != len(manifest[0].Layers) {
+				createdBy = nil
+			}
+		}
+		for i, p := range manifest[0].Layers {
+			layer, ok := entries[p]
+			if !ok {
+				continue
+			}
+			layer.ID = layerID(p)
+			if diffIDs != nil {
+				layer.DiffID = diffIDs[i]
+			}
+			if createdBy != nil {
+				layer.CreatedBy = createdBy[i]
+			}
+			features.Layers = append(features.Layers, layer)
+		}
+	}
+	return features, nil
+}
+
+// layerID derives the layer identifier from its path in the archive:
+// "blobs/sha256/<hex>" in OCI layouts, "<id>/layer.tar" in legacy archives.
+func layerID(p string) string {
+	if hex, ok := strings.CutPrefix(p, "blobs/sha256/"); ok {
+		return "sha256:" + hex
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(p, "/layer.tar"), ".tar")
+}
+
+// checkCompatibility returns an error when the remote runtime cannot load an
+// archive with the given features, and prints warnings for setups that work
+// but are known to be problematic.
+func checkCompatibility(rt *remoteRuntime, features *archiveFeatures) error {
+	console.Printf("[CHECKING] Remote %s (storage driver %s)\n", rt, valueOr(rt.Driver, "unknown"))
+
+	switch rt.Driver {
+	case "vfs":
+		console.Printf("[WARNING] Remote storage driver vfs copies every layer in full; loading may need much more disk than the archive size\n")
+	case "aufs", "devicemapper":
+		console.Printf("[WARNING] Remote storage driver %s is deprecated and may fail to load newer images\n", rt.Driver)
+	}
+
+	if rt.Podman || rt.Containerd {
+		// The minimum versions are docker's; podman and containerd load
+		// both natively
+		return nil
+	}
+	if !features.DockerManifest && versionLess(rt.Version, minVersionOCIArchive) {
+		return fmt.Errorf("archive is an OCI layout without manifest.json, which docker %s cannot load (needs >= %s); export it in docker-archive format instead",
+			rt.Version, minVersionOCIArchive)
+	}
+	if features.ZstdLayers && versionLess(rt.Version, minVersionZstd) {
+		return fmt.Errorf("archive contains zstd-compressed layers, which docker %s cannot load (needs >= %s); export it as an uncompressed docker-archive or upgrade docker on the remote",
+			rt.Version, minVersionZstd)
+	}
+	return nil
+}
+
+// versionLess compares dotted version strings numerically, ignoring any
+// suffix such as "-ce" or "+dfsg". Unparseable versions never compare less.
+func versionLess(version, min string) bool {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(v, "-")
+		v, _, _ = strings.Cut(v, "+")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil
+			}
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	a, b := parse(version), parse(min)
+	if a == nil || b == nil {
+		return false
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
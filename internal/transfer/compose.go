@@ -0,0 +1,128 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// composeImages lists the images used by the services of a compose file, as
+// resolved by the local docker compose (variables, profiles and extends
+// included).
+func composeImages(file string) ([]string, error) {
+	output, err := exec.Command("docker", "compose", "-f", file, "config", "--images").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("docker compose config failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("docker compose config failed: %v", err)
+	}
+	seen := map[string]bool{}
+	var images []string
+	for _, line := range strings.Split(string(output), "\n") {
+		image := strings.TrimSpace(line)
+		if image != "" && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%s does not reference any images", file)
+	}
+	return images, nil
+}
+
+// composeProject derives the compose project name from the directory of the
+// compose file, as docker compose does.
+func composeProject(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+		return -1
+	}, filepath.Base(filepath.Dir(abs)))
+}
+
+// DeployCompose transfers all images of the compose file to every target,
+// then uploads the file and starts the stack with docker compose up -d on
+// the hosts that received all images. The file is kept on the remote in
+// ~/remote-pull/<project> so the stack can be managed there later. A
+// *HostsError lists the hosts the stack was not started on.
+func DeployCompose(ctx context.Context, file string, targets []string, opts Options) error {
+	images, err := composeImages(file)
+	if err != nil {
+		return err
+	}
+	// Refuse the whole stack up front rather than deploying part of it
+	for _, image := range images {
+		if err := checkPolicy(image, opts.AllowedRegistries); err != nil {
+			return err
+		}
+	}
+	project := composeProject(file)
+	console.Printf("[COMPOSE] Deploying project %s with %d images to %d hosts\n", project, len(images), len(targets))
+
+	results, err := transferImages(ctx, images, targets, opts)
+	finishReports(results, opts)
+	if err != nil && len(results) == 0 {
+		return &HostsError{Hosts: targets, Err: err}
+	}
+
+	failed := map[string]bool{}
+	for _, result := range results {
+		if result.Err != nil {
+			failed[result.Target] = true
+		}
+	}
+	for _, target := range targets {
+		if failed[target] {
+			console.Printf("[COMPOSE] Not starting project %s on %s, its images were not transferred\n", project, target)
+			continue
+		}
+		if err := deployCompose(ctx, file, project, target, opts); err != nil {
+			console.Printf("[FAILED] %s: deployment failed: %v\n", target, err)
+			failed[target] = true
+		}
+	}
+	if len(failed) > 0 {
+		hosts := slices.DeleteFunc(slices.Clone(targets), func(target string) bool { return !failed[target] })
+		return &HostsError{Hosts: hosts, Err: fmt.Errorf("compose deployment failed on %d of %d hosts", len(hosts), len(targets))}
+	}
+	return nil
+}
+
+func deployCompose(ctx context.Context, file, project, target string, opts Options) error {
+	remote, err := resolveRemote(ctx, target, opts)
+	if err != nil {
+		return err
+	}
+	if remote.windows() {
+		return fmt.Errorf("compose deployment is only supported for linux hosts")
+	}
+
+	dir := "remote-pull/" + project
+	if _, err := remote.run("mkdir -p " + remote.quote(dir)); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	// Images were just transferred, so compose must not try to pull them
+	up := remote.docker(fmt.Sprintf("compose -p %s -f %s up -d --pull never", remote.quote(project), remote.quote(dir+"/"+filepath.Base(file))))
+	console.Printf("[COMPOSE] Uploading %s to %s:%s and starting the stack\n", file, remote, dir)
+	if err := ssh.CopyAndRun(remote.ctx, file, remote.quote(dir), up, remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return err
+	}
+	console.Printf("[SUCCESS] Project %s is up on %s\n", project, remote.host)
+	return nil
+}
@@ -0,0 +1,113 @@
+package transfer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms for Options.Compress.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// Compressions lists the valid values of Options.Compress.
+var Compressions = []string{CompressNone, CompressGzip, CompressZstd}
+
+// compressing reports whether opts select a compression.
+func compressing(opts Options) bool {
+	return opts.Compress != "" && opts.Compress != CompressNone
+}
+
+// compressor returns a function wrapping a writer in the compression
+// selected by opts, or nil when nothing is compressed. A zero level selects
+// the default of the algorithm.
+func compressor(opts Options) func(io.Writer) (io.WriteCloser, error) {
+	level := opts.CompressLevel
+	switch opts.Compress {
+	case CompressGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		}
+	case CompressZstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+		}
+	}
+	return nil
+}
+
+// compressExt returns the file extension of archives compressed with
+// algorithm.
+func compressExt(algorithm string) string {
+	switch algorithm {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// compressFile writes src to dest, compressed as selected by opts.
+func compressFile(src, dest string, opts Options) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw, err := compressor(opts)(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// decompressor returns the remote command that must decompress the archive
+// before docker load, or "" when docker load reads the compressed archive
+// itself: it detects gzip in every version and zstd from 23.0, podman both.
+// containerd only imports uncompressed archives.
+func (r *remoteHost) decompressor(rt *remoteRuntime, opts Options) (string, error) {
+	if rt.Containerd && opts.Compress == CompressGzip {
+		return "gzip -dc", nil
+	}
+	if rt.Containerd && opts.Compress == CompressZstd {
+		if _, err := r.run("command -v zstd"); err != nil {
+			return "", fmt.Errorf("zstd is not installed on %s to decompress for containerd; use --compress gzip", r.host)
+		}
+		return "zstd -dc", nil
+	}
+	if opts.Compress != CompressZstd || rt.Podman || !versionLess(rt.Version, minVersionZstd) {
+		return "", nil
+	}
+	if r.windows() {
+		return "", fmt.Errorf("docker %s cannot load zstd archives (needs >= %s); use --compress gzip", rt.Version, minVersionZstd)
+	}
+	if _, err := r.run("command -v zstd"); err != nil {
+		return "", fmt.Errorf("docker %s cannot load zstd archives (needs >= %s) and zstd is not installed on %s; use --compress gzip", rt.Version, minVersionZstd, r.host)
+	}
+	return "zstd -dc", nil
+}
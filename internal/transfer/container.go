@@ -0,0 +1,185 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// ContainerOptions controls how a container snapshot is imported remotely.
+type ContainerOptions struct {
+	// Tag names the image created on the remote. Defaults to
+	// "<container>:snapshot".
+	Tag string
+	// Entrypoint and Cmd override the container's settings; they are given
+	// in Dockerfile JSON (exec) or shell form.
+	Entrypoint string
+	Cmd        string
+	// Changes are additional Dockerfile instructions applied on import.
+	Changes []string
+}
+
+// containerConfig is the subset of `docker inspect` needed to restore the
+// metadata that docker export drops.
+type containerConfig struct {
+	Name   string `json:"Name"`
+	Config struct {
+		Entrypoint   []string            `json:"Entrypoint"`
+		Cmd          []string            `json:"Cmd"`
+		Env          []string            `json:"Env"`
+		WorkingDir   string              `json:"WorkingDir"`
+		User         string              `json:"User"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	} `json:"Config"`
+}
+
+// changes returns the Dockerfile instructions that recreate the container's
+// metadata, with entrypoint and command overridden from copts.
+func (c *containerConfig) changes(copts ContainerOptions) []string {
+	var changes []string
+	execForm := func(args []string) string {
+		data, _ := json.Marshal(args)
+		return string(data)
+	}
+	if copts.Entrypoint != "" {
+		changes = append(changes, "ENTRYPOINT "+copts.Entrypoint)
+	} else if len(c.Config.Entrypoint) > 0 {
+		changes = append(changes, "ENTRYPOINT "+execForm(c.Config.Entrypoint))
+	}
+	if copts.Cmd != "" {
+		changes = append(changes, "CMD "+copts.Cmd)
+	} else if len(c.Config.Cmd) > 0 {
+		changes = append(changes, "CMD "+execForm(c.Config.Cmd))
+	}
+	for _, env := range c.Config.Env {
+		if key, value, ok := strings.Cut(env, "="); ok {
+			changes = append(changes, fmt.Sprintf("ENV %s=%s", key, dockerfileQuote(value)))
+		}
+	}
+	if c.Config.WorkingDir != "" {
+		changes = append(changes, "WORKDIR "+c.Config.WorkingDir)
+	}
+	if c.Config.User != "" {
+		changes = append(changes, "USER "+c.Config.User)
+	}
+	for port := range c.Config.ExposedPorts {
+		changes = append(changes, "EXPOSE "+port)
+	}
+	return append(changes, copts.Changes...)
+}
+
+// exportContainer writes the filesystem of container to dest.
+func exportContainer(ctx context.Context, engine *engineSource, container, dest string) error {
+	body, err := engine.client.ContainerExport(ctx, container)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dockerfileQuote double-quotes an ENV value the way the Dockerfile parser
+// expects.
+func dockerfileQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`).Replace(s) + `"`
+}
+
+// PushContainer snapshots the filesystem of a local container with docker
+// export and imports it as an image on the remote. Export drops the image
+// metadata, so entrypoint, command, environment, working directory, user
+// and exposed ports are carried over from the container as import changes.
+func PushContainer(ctx context.Context, container, remoteServer string, copts ContainerOptions, opts Options) error {
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	engine, err := newEngineSource()
+	if err != nil {
+		return err
+	}
+	_, raw, err := engine.client.ContainerInspectWithRaw(ctx, container, false)
+	if err != nil {
+		return fmt.Errorf("local container %s not found: %v", container, err)
+	}
+	var config containerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return fmt.Errorf("unexpected container inspect response: %v", err)
+	}
+
+	tag := copts.Tag
+	if tag == "" {
+		tag = strings.TrimPrefix(config.Name, "/") + ":snapshot"
+	}
+
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	archiveName, err := uniqueArchiveName(tag)
+	if err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(tmpDir, archiveName)
+	removeLocal := func() {
+		console.Printf("[CLEANUP] Removing temporary archive %s\n", tmpFile)
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			console.Printf("[WARNING] Failed to remove temporary archive %s: %v\n", tmpFile, err)
+		}
+	}
+	defer onInterrupt(removeLocal)()
+	defer removeLocal()
+
+	console.Printf("[SAVING] Exporting filesystem of container %s to %s\n", container, tmpFile)
+	if err := exportContainer(ctx, engine, container, tmpFile); err != nil {
+		return fmt.Errorf("failed to export container %s: %v", container, err)
+	}
+	if info, err := os.Stat(tmpFile); err == nil {
+		console.Printf("[STATUS] Archive size: %.2f MB\n", float64(info.Size())/1024/1024)
+	}
+
+	remoteDir, err := remote.tempDir()
+	if err != nil {
+		return err
+	}
+	remoteFile := remote.join(remoteDir, archiveName)
+	remote.track(remoteFile)
+	finishRemote := remote.removeArtifacts
+	if opts.KeepRemoteArchive {
+		finishRemote = remote.keepArtifacts
+	}
+	defer finishRemote()
+	defer onInterrupt(finishRemote)()
+
+	args := []string{"import"}
+	for _, change := range config.changes(copts) {
+		args = append(args, "--change", remote.quote(change))
+	}
+	args = append(args, remote.quote(remoteFile), remote.quote(tag))
+	importCmd := remote.command(remote.docker(strings.Join(args, " ")))
+
+	console.Printf("[TRANSFER] Starting transfer of container %s to %s as %s\n", container, remote.host, tag)
+	if err := ssh.CopyAndRun(remote.ctx, tmpFile, remote.quotePath(remoteDir), importCmd, remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return fmt.Errorf("[ERROR] Container transfer failed: %v", err)
+	}
+
+	console.Printf("[SUCCESS] Container %s imported as %s on %s\n", container, tag, remote.host)
+	return nil
+}
@@ -0,0 +1,117 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// CopyImage copies imageName from sourceServer to destServer: docker save
+// runs on the source and its output is relayed through this machine
+// straight into docker load on the destination, so the archive is never
+// written to disk on either side or here.
+func CopyImage(ctx context.Context, sourceServer, destServer, imageName string, opts Options) error {
+	if err := checkPolicy(imageName, opts.AllowedRegistries); err != nil {
+		return err
+	}
+	source, err := resolveRemote(ctx, sourceServer, opts)
+	if err != nil {
+		return err
+	}
+	dest, err := resolveRemote(ctx, destServer, opts)
+	if err != nil {
+		return err
+	}
+	if source.windows() || dest.windows() {
+		return fmt.Errorf("copying images between Windows hosts is not supported")
+	}
+
+	console.Printf("[CHECKING] Verifying if %s exists on %s...\n", imageName, source.host)
+	imageID, err := checkRemoteImage(imageName, source)
+	if err != nil {
+		return fmt.Errorf("error checking image on %s: %v", source.host, err)
+	}
+	if imageID == "" {
+		return fmt.Errorf("image %s not found on %s", imageName, source.host)
+	}
+	if err := authorizeTransfer(imageName, dest, remoteImages{source}, opts.Policy); err != nil {
+		return err
+	}
+	console.Printf("[CHECKING] Verifying if %s exists on %s...\n", imageName, dest.host)
+	if destID, err := checkRemoteImage(imageName, dest); err != nil {
+		return fmt.Errorf("error checking image on %s: %v", dest.host, err)
+	} else if destID == imageID {
+		console.Printf("[SKIPPING] Image %s already exists on %s - no transfer needed\n", imageName, dest.host)
+		return nil
+	}
+	rt, err := inspectRemoteRuntime(dest)
+	if err != nil {
+		return fmt.Errorf("failed to inspect the container runtime on %s: %v", dest.host, err)
+	}
+	load := dest.loadCommand("")
+	decompress, err := dest.decompressor(rt, opts)
+	if err != nil {
+		return err
+	}
+	if decompress != "" {
+		load = decompress + " | " + load
+	}
+	destOpts := dest.sshOpts
+	destOpts.Compressor = compressor(opts)
+	size, err := remoteImageSize(imageName, source)
+	if err != nil {
+		// The size is only used for the progress display
+		console.Printf("[WARNING] Unable to read the size of %s on %s: %v\n", imageName, source.host, err)
+	}
+
+	defer source.watchInterrupts()()
+	defer dest.watchInterrupts()()
+
+	// A failed load aborts the save on the source, which would otherwise
+	// block on a full channel
+	ctx, cancel := context.WithCancelCause(source.ctx)
+	defer cancel(nil)
+	r, w := io.Pipe()
+	loaded := make(chan error, 1)
+	go func() {
+		err := ssh.PipeRun(dest.ctx, r, size, imageName, dest.command(load), dest.user, dest.host, opts.LoadTimeout, destOpts)
+		if err != nil {
+			cancel(err)
+		}
+		r.CloseWithError(err)
+		loaded <- err
+	}()
+
+	console.Printf("[COPY] Streaming %s from %s to %s\n", imageName, source.host, dest.host)
+	save := source.command(source.docker("save " + source.quote(imageName)))
+	err = ssh.FetchRun(ctx, w, size, imageName, save, source.user, source.host, source.sshOpts)
+	w.CloseWithError(err)
+	loadErr := <-loaded
+	switch {
+	case err != nil && !errors.Is(err, loadErr):
+		return fmt.Errorf("failed to read %s from %s: %v", imageName, source.host, err)
+	case loadErr != nil:
+		return fmt.Errorf("failed to load %s on %s: %v", imageName, dest.host, loadErr)
+	}
+	console.Printf("[SUCCESS] Image %s copied from %s to %s\n", imageName, source.host, dest.host)
+	return nil
+}
+
+// remoteImages inspects the images of a remote for the policy check of a
+// copy.
+type remoteImages struct {
+	remote *remoteHost
+}
+
+func (r remoteImages) inspect(ctx context.Context, imageName string) (*imageInfo, error) {
+	info := &imageInfo{}
+	if err := inspectRemote(r.remote, "image inspect --format "+r.remote.quote("{{json .}}")+" "+r.remote.quote(imageName), info); err != nil {
+		return nil, err
+	}
+	info.ID = normalizeImageID(info.ID)
+	return info, nil
+}
@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// deliverTarget places the archive of imageName on the remote as a file
+// instead of loading it, for provisioning systems that load it later. The
+// remote needs no docker for this.
+func deliverTarget(imageName string, remote *remoteHost, src imageSource, opts Options, result *Result) error {
+	if !opts.SkipPull {
+		if err := src.pull(remote.ctx, imageName); err != nil {
+			return fmt.Errorf("error pulling local image: %v", err)
+		}
+	}
+	if err := authorizeTransfer(imageName, remote, src, opts.Policy); err != nil {
+		return err
+	}
+
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	tmpFile, removeArchive, err := saveArchive(remote.ctx, imageName, "", src, opts)
+	if err != nil {
+		return err
+	}
+	defer removeArchive()
+	var localFiles []string
+	removeLocal := func() {
+		for _, f := range localFiles {
+			console.Printf("[CLEANUP] Removing temporary archive %s\n", f)
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				console.Printf("[WARNING] Failed to remove temporary archive %s: %v\n", f, err)
+			}
+		}
+	}
+	defer onInterrupt(removeLocal)()
+	defer removeLocal()
+	if compressing(opts) {
+		console.Printf("[COMPRESSING] Compressing archive with %s\n", opts.Compress)
+		compressed, err := siblingFile(tmpFile, ".tar"+compressExt(opts.Compress))
+		if err != nil {
+			return fmt.Errorf("[ERROR] Failed to compress archive: %v", err)
+		}
+		localFiles = append(localFiles, compressed)
+		if err := compressFile(tmpFile, compressed, opts); err != nil {
+			return fmt.Errorf("[ERROR] Failed to compress archive: %v", err)
+		}
+		tmpFile = compressed
+	}
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get archive size: %v", err)
+	}
+	result.Bytes = info.Size()
+
+	dest := opts.RemotePath
+	if dest == "" {
+		ref := parseReference(imageName)
+		dest = path.Base(ref.Repository) + "_" + valueOr(ref.Tag, defaultTag) + ".tar"
+		dest += compressExt(opts.Compress)
+	}
+	if opts.Strategy == ssh.StrategySFTP {
+		// Nothing but SFTP is used, for accounts that may not run any
+		// command; the destination directory must exist
+		console.Printf("[TRANSFER] Delivering archive to %s:%s over SFTP (%.2f MB)\n", remote.host, dest, mb(info.Size()))
+		if err := ssh.SFTPUpload(remote.ctx, tmpFile, remote.sftpPath(dest), remote.user, remote.host, remote.sshOpts); err != nil {
+			return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+		}
+		console.Printf("[SUCCESS] Archive of %s delivered to %s:%s (not loaded)\n", imageName, remote.host, dest)
+		result.Status = StatusTransferred
+		return nil
+	}
+	destDir := remote.dir(dest)
+
+	// Upload under a temporary name next to the destination and rename it
+	// at the end, so the provisioning system never sees a partial archive
+	if _, err := remote.run(remote.mkdir(destDir)); err != nil {
+		return fmt.Errorf("[ERROR] Failed to create remote directory %s: %v", destDir, err)
+	}
+	partial := remote.join(destDir, filepath.Base(tmpFile))
+	remote.track(partial)
+	defer remote.removeArtifacts()
+	defer onInterrupt(remote.removeArtifacts)()
+
+	console.Printf("[TRANSFER] Delivering archive to %s:%s (%.2f MB)\n", remote.host, dest, mb(info.Size()))
+	move := fmt.Sprintf("mv -f %s %s", remote.quote(partial), remote.quote(dest))
+	if remote.windows() {
+		move = fmt.Sprintf("Move-Item -Force -LiteralPath %s -Destination %s", remote.quote(partial), remote.quote(dest))
+	}
+	if err := ssh.CopyAndRun(remote.ctx, tmpFile, remote.quotePath(destDir), remote.command(move), remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+	}
+	remote.untrack(partial)
+
+	console.Printf("[SUCCESS] Archive of %s delivered to %s:%s (not loaded)\n", imageName, remote.host, dest)
+	result.Status = StatusTransferred
+	return nil
+}
+
+// dir returns the directory part of a remote path; relative paths are
+// relative to the remote user's home directory.
+func (r *remoteHost) dir(p string) string {
+	if r.windows() {
+		if i := strings.LastIndexAny(p, `\/`); i >= 0 {
+			return p[:i]
+		}
+		return "."
+	}
+	return path.Dir(p)
+}
+
+// mkdir returns the command creating directory p and its parents.
+func (r *remoteHost) mkdir(p string) string {
+	if r.windows() {
+		return fmt.Sprintf("New-Item -ItemType Directory -Force -Path %s | Out-Null", r.quote(p))
+	}
+	return "mkdir -p " + r.quote(p)
+}
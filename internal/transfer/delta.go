@@ -0,0 +1,155 @@
+package transfer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"remote-pull/internal/console"
+)
+
+// deltaArchive is an archive of the image without the layers the remote
+// already has. docker load looks every layer up by its chain ID, the digest
+// of the layer and all layers below it, before it opens the layer file. The
+// files of a leading run of layers that an image on the remote shares are
+// therefore never read and can be left out of the archive.
+type deltaArchive struct {
+	path string
+	// omitted holds the archive paths of the layer files left out.
+	omitted map[string]bool
+	// layers lists the layers that were kept, in manifest order, with their
+	// position in the delta archive.
+	layers []archiveLayer
+	size   int64
+}
+
+// prepareDelta writes the delta of archive for remote to path. It returns nil
+// when the full archive has to be sent, because the remote has none of the
+// layers or the delta cannot be built; neither is fatal.
+func prepareDelta(archive, path string, features *archiveFeatures, remote *remoteHost) *deltaArchive {
+	if !features.DockerManifest || len(features.DiffIDs) == 0 {
+		console.Printf("[DELTA] Archive has no usable manifest.json, sending all layers\n")
+		return nil
+	}
+	images, err := remoteLayerLists(remote)
+	if err != nil {
+		console.Printf("[WARNING] %v, sending all layers\n", err)
+		return nil
+	}
+	shared := sharedLayers(features.DiffIDs, images)
+	omitted := omittedLayers(features, shared)
+	if len(omitted) == 0 {
+		console.Printf("[DELTA] %s has none of the layers of the image, sending all layers\n", remote.host)
+		return nil
+	}
+
+	delta, err := writeDelta(archive, path, features.Layers, omitted)
+	if err != nil {
+		console.Printf("[WARNING] Failed to build delta archive, sending all layers: %v\n", err)
+		return nil
+	}
+	console.Printf("[DELTA] %d of %d layers already on %s, sending %.2f MB\n", shared, len(features.DiffIDs), remote.host, mb(delta.size))
+	return delta
+}
+
+// sharedLayers returns the length of the longest run of leading layers that
+// diffIDs has in common with any of images.
+func sharedLayers(diffIDs []string, images [][]string) int {
+	shared := 0
+	for _, layers := range images {
+		n := 0
+		for n < len(diffIDs) && n < len(layers) && diffIDs[n] == layers[n] {
+			n++
+		}
+		shared = max(shared, n)
+	}
+	return shared
+}
+
+// omittedLayers returns the paths of the layer files that need not be sent
+// when the first shared layers are present on the remote. A file that is
+// also used above the shared layers is kept.
+func omittedLayers(features *archiveFeatures, shared int) map[string]bool {
+	omitted := map[string]bool{}
+	for _, layer := range features.Layers {
+		if layer.DiffID != "" && slices.Contains(features.DiffIDs[:shared], layer.DiffID) &&
+			!slices.Contains(features.DiffIDs[shared:], layer.DiffID) {
+			omitted[layer.Path] = true
+		}
+	}
+	return omitted
+}
+
+// writeDelta copies the archive at src to dst, leaving out the files in
+// omitted, and records where the remaining layers ended up.
+func writeDelta(src, dst string, layers []archiveLayer, omitted map[string]bool) (*deltaArchive, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	counter := &countingWriter{}
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(io.MultiWriter(out, counter))
+	offsets := map[string]int64{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %v", src, err)
+		}
+		if omitted[hdr.Name] {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		// The tar writer does not buffer, so the data starts right after
+		// the header
+		offsets[hdr.Name] = counter.n
+		if _, err := io.Copy(tw, tr); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", dst, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	delta := &deltaArchive{path: dst, omitted: omitted, size: counter.n}
+	for _, layer := range layers {
+		if !omitted[layer.Path] {
+			layer.Offset = offsets[layer.Path]
+			delta.layers = append(delta.layers, layer)
+		}
+	}
+	return delta, nil
+}
+
+// layerStats combines the timings of the layers sent in the delta with the
+// layers that were left out.
+func (d *deltaArchive) layerStats(layers []archiveLayer, sent []LayerStat) []LayerStat {
+	var stats []LayerStat
+	for _, layer := range layers {
+		if d.omitted[layer.Path] {
+			stats = append(stats, LayerStat{ID: layer.ID, Size: layer.Size, Skipped: true})
+			continue
+		}
+		if len(sent) > 0 {
+			stats = append(stats, sent[0])
+			sent = sent[1:]
+		}
+	}
+	return stats
+}
@@ -0,0 +1,98 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"remote-pull/pkg/ssh"
+)
+
+// Doctor checks the local side of transfers to remoteServer, which may be
+// empty: the SSH agent and identities, ssh_config and known_hosts, the local
+// container runtime and the temp directory. It prints a checklist with fixes
+// and returns an error if any check failed. Unlike Preflight it does not
+// connect to the remote.
+func Doctor(remoteServer string, opts Options) error {
+	var results []checkResult
+	report := func(name, status, fix, format string, args ...any) {
+		results = append(results, checkResult{name: name, status: status, detail: fmt.Sprintf(format, args...), fix: fix})
+	}
+	defer func() { printChecklist(results) }()
+
+	var target ssh.Target
+	if remoteServer != "" {
+		var err error
+		if target, err = ssh.ParseTarget(remoteServer); err != nil {
+			report("Target", checkFail, "", "%v", err)
+		}
+	}
+	for _, c := range ssh.CheckLocal(target.User, target.Host) {
+		results = append(results, checkResult{name: c.Name, status: c.Status, detail: c.Detail, fix: c.Fix})
+	}
+
+	checkLocalRuntime(report, opts.Runtime)
+
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	if f, err := os.CreateTemp(tmpDir, "remote-pull-doctor-"); err != nil {
+		report("Temp directory", checkFail, "pick another directory with --local-tmp or TMPDIR", "%s is not writable: %v", tmpDir, err)
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+		if free, err := freeSpace(tmpDir); err != nil {
+			report("Temp space", checkWarn, "", "unable to determine free space in %s: %v", tmpDir, err)
+		} else if free < minFreeSpace {
+			report("Temp space", checkWarn, "free up space or pick another directory with --local-tmp", "only %.2f GB free in %s", float64(free)/(1<<30), tmpDir)
+		} else {
+			report("Temp space", checkPass, "", "%.2f GB free in %s", float64(free)/(1<<30), tmpDir)
+		}
+	}
+
+	for _, r := range results {
+		if r.status == checkFail {
+			return fmt.Errorf("doctor found problems")
+		}
+	}
+	return nil
+}
+
+// checkLocalRuntime reports how images would be read: through the Engine
+// API of the docker daemon, or the podman CLI when no daemon is reachable
+// (see selectSource).
+func checkLocalRuntime(report func(name, status, fix, format string, args ...any), runtime string) {
+	var engineErr error
+	if runtime != RuntimePodman {
+		engine, err := newEngineSource()
+		if err != nil {
+			report("Local docker", checkFail, "fix DOCKER_HOST or the docker context", "%v", err)
+			return
+		}
+		version, err := engine.ping(context.Background())
+		if err == nil {
+			report("Local docker", checkPass, "", "Engine API at %s, daemon %s", engine.host, version)
+			return
+		}
+		engineErr = fmt.Errorf("daemon at %s is not reachable: %v", engine.host, err)
+	}
+
+	_, err := exec.LookPath("podman")
+	if runtime == RuntimePodman || err == nil && runtime == "" {
+		if err != nil {
+			report("Local podman", checkFail, "install podman", "podman CLI not found: %v", err)
+			return
+		}
+		output, err := exec.Command("podman", "version", "--format", "{{.Client.Version}}").CombinedOutput()
+		if err != nil {
+			report("Local podman", checkFail, "check podman info for configuration problems", "podman CLI found but not usable: %s", strings.TrimSpace(string(output)))
+			return
+		}
+		report("Local podman", checkPass, "", "podman CLI %s", strings.TrimSpace(string(output)))
+		return
+	}
+	report("Local docker", checkFail, "start the docker daemon, add your user to the docker group, or pass --docker-host to reach a daemon", "%v", engineErr)
+}
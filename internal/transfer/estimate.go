@@ -0,0 +1,233 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// inspectBatch bounds the number of image IDs passed to a single remote
+// "docker image inspect" to stay clear of command line length limits.
+const inspectBatch = 100
+
+// EstimateTargets reports, for every target, how much of imageName would
+// have to be sent: the layers the remote does not have yet, uncompressed and
+// as estimated gzip size. Nothing is transferred. A *HostsError lists the
+// hosts the estimate failed on.
+func EstimateTargets(ctx context.Context, imageName string, targets []string, opts Options) error {
+	est, imageName, remove, err := newEstimator(ctx, imageName, opts)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	var failed []string
+	for _, target := range targets {
+		if err := est.target(ctx, imageName, target, opts); err != nil {
+			console.Printf("[FAILED] %s: %v\n", target, err)
+			failed = append(failed, target)
+		}
+	}
+	if len(failed) > 0 {
+		return &HostsError{Hosts: failed, Err: fmt.Errorf("estimate failed on %d of %d hosts", len(failed), len(targets))}
+	}
+	return nil
+}
+
+type estimator struct {
+	path        string
+	archiveSize int64
+	layers      []archiveLayer
+	// compressed caches the compressed size of layers by algorithm and
+	// archive path
+	compressed map[string]int64
+}
+
+// newEstimator exports imageName to a local archive, which is only read to
+// learn the layers and their sizes, and returns the estimator for it, the
+// name the image has on the remote and a function removing the archive.
+func newEstimator(ctx context.Context, imageName string, opts Options) (*estimator, string, func(), error) {
+	src, imageName, err := openSource(ctx, imageName, opts.Runtime)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("local preflight failed: %v", err)
+	}
+	if err := checkPolicy(imageName, opts.AllowedRegistries); err != nil {
+		return nil, "", nil, err
+	}
+	if !opts.SkipPull {
+		if err := src.pull(ctx, imageName); err != nil {
+			return nil, "", nil, fmt.Errorf("error pulling local image: %v", err)
+		}
+	}
+
+	archiveName, err := uniqueArchiveName(imageName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	if err := checkLocalSpace(ctx, imageName, tmpDir, src); err != nil {
+		return nil, "", nil, err
+	}
+	tmpFile := filepath.Join(tmpDir, archiveName)
+	removeLocal := func() {
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			console.Printf("[WARNING] Failed to remove temporary archive %s: %v\n", tmpFile, err)
+		}
+	}
+	unregister := onInterrupt(removeLocal)
+	remove := func() {
+		unregister()
+		removeLocal()
+	}
+	console.Printf("[SAVING] Exporting Docker image %q to archive via %s\n", imageName, src.describe())
+	if err := src.save(ctx, imageName, tmpFile); err != nil {
+		remove()
+		return nil, "", nil, fmt.Errorf("failed to save image: %v", err)
+	}
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		remove()
+		return nil, "", nil, fmt.Errorf("failed to get archive size: %v", err)
+	}
+	features, err := inspectArchive(tmpFile)
+	if err != nil {
+		remove()
+		return nil, "", nil, err
+	}
+	return &estimator{path: tmpFile, archiveSize: info.Size(), layers: features.Layers, compressed: map[string]int64{}}, imageName, remove, nil
+}
+
+func (e *estimator) target(ctx context.Context, imageName, target string, opts Options) error {
+	remote, err := resolveRemote(ctx, target, opts)
+	if err != nil {
+		return err
+	}
+	imageID, err := checkRemoteImage(imageName, remote)
+	if err != nil {
+		return fmt.Errorf("error checking remote image: %v", err)
+	}
+	if imageID != "" {
+		console.Printf("[ESTIMATE] %s: %s already present, nothing to transfer\n", target, imageName)
+		return nil
+	}
+	present, err := remoteLayers(remote)
+	if err != nil {
+		return err
+	}
+
+	// Everything that is not a layer (configs, manifests, tar headers) is
+	// always sent
+	needed := e.archiveSize
+	compressed := e.archiveSize
+	reused := 0
+	for _, layer := range e.layers {
+		compressed -= layer.Size
+		if layer.DiffID != "" && present[layer.DiffID] {
+			needed -= layer.Size
+			reused++
+			continue
+		}
+		size, err := e.compressedSize(layer, CompressGzip)
+		if err != nil {
+			return err
+		}
+		compressed += size
+	}
+	compressed = min(compressed, needed)
+
+	console.Printf("[ESTIMATE] %s: archive %.2f MB, %d of %d layers already present\n",
+		target, mb(e.archiveSize), reused, len(e.layers))
+	console.Printf("[ESTIMATE] %s: would transfer %.2f MB, about %.2f MB compressed\n",
+		target, mb(needed), mb(compressed))
+	return nil
+}
+
+// compressedSize returns the size of layer after compression with
+// algorithm, gzip or zstd at their default levels.
+func (e *estimator) compressedSize(layer archiveLayer, algorithm string) (int64, error) {
+	key := algorithm + " " + layer.Path
+	if size, ok := e.compressed[key]; ok {
+		return size, nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	counter := &countingWriter{}
+	zw, err := compressor(Options{Compress: algorithm})(counter)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(zw, io.NewSectionReader(f, layer.Offset, layer.Size)); err != nil {
+		return 0, fmt.Errorf("failed to compress layer %s: %v", layer.ID, err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	e.compressed[key] = counter.n
+	return counter.n, nil
+}
+
+// remoteLayers returns the diff IDs of all layers of the images on the
+// remote.
+func remoteLayers(remote *remoteHost) (map[string]bool, error) {
+	images, err := remoteLayerLists(remote)
+	if err != nil {
+		return nil, err
+	}
+	layers := map[string]bool{}
+	for _, list := range images {
+		for _, id := range list {
+			layers[id] = true
+		}
+	}
+	return layers, nil
+}
+
+// remoteLayerLists returns the layer diff IDs of every image on the remote,
+// in order.
+func remoteLayerLists(remote *remoteHost) ([][]string, error) {
+	output, err := remote.run(remote.docker("image ls -q --no-trunc"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote images: %v", err)
+	}
+	ids := strings.Fields(output)
+	var images [][]string
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), inspectBatch)]
+		ids = ids[len(batch):]
+		cmd := remote.docker("image inspect --format " + remote.quote("{{json .RootFS.Layers}}") + " " + strings.Join(batch, " "))
+		output, err := remote.run(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect remote images: %v", err)
+		}
+		lists, err := decodeJSONLines[[]string](output)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output from remote docker image inspect: %v", err)
+		}
+		images = append(images, lists...)
+	}
+	return images, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func mb(n int64) float64 {
+	return float64(n) / 1024 / 1024
+}
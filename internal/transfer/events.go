@@ -0,0 +1,74 @@
+package transfer
+
+import "sync"
+
+// Phases of the transfer to a host, reported to PhaseReporters.
+const (
+	PhaseCheck       = "check"
+	PhasePull        = "pull"
+	PhaseSave        = "save"
+	PhaseTransfer    = "transfer"
+	PhasePostLoad    = "post-load"
+	PhaseHealthCheck = "healthcheck"
+)
+
+// PhaseReporter is implemented by Reporters that follow the transfer to
+// each host live, beyond its start and result.
+type PhaseReporter interface {
+	// Phase is called when the transfer to target enters phase.
+	Phase(target, phase string)
+	// Progress is called as the archive is sent to target, with the bytes
+	// sent so far and the (estimated) size of the archive.
+	Progress(target string, sent, total int64)
+}
+
+// hostEvents passes the phases and progress of the transfer to one host to
+// the PhaseReporters of the run. A nil hostEvents reports nothing.
+type hostEvents struct {
+	target    string
+	reporters []PhaseReporter
+	// mu serializes the calls with those for the other hosts.
+	mu *sync.Mutex
+}
+
+func newHostEvents(target string, reporters []Reporter, mu *sync.Mutex) *hostEvents {
+	e := &hostEvents{target: target, mu: mu}
+	for _, r := range reporters {
+		if pr, ok := r.(PhaseReporter); ok {
+			e.reporters = append(e.reporters, pr)
+		}
+	}
+	if len(e.reporters) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *hostEvents) phase(phase string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.reporters {
+		r.Phase(e.target, phase)
+	}
+}
+
+// copied returns a function for ssh.Options.Copied reporting the progress
+// of sending total bytes, which also calls next if set.
+func (e *hostEvents) copied(total int64, next func(int64)) func(int64) {
+	if e == nil {
+		return next
+	}
+	return func(sent int64) {
+		if next != nil {
+			next(sent)
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for _, r := range e.reporters {
+			r.Progress(e.target, sent, total)
+		}
+	}
+}
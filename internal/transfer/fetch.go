@@ -0,0 +1,97 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// FetchImage copies imageName from remoteServer into the local runtime, the
+// reverse of a transfer: docker save runs on the remote and its output is
+// streamed over SSH straight into the local docker load, without an archive
+// on either side.
+func FetchImage(ctx context.Context, remoteServer, imageName string, opts Options) error {
+	src, err := selectSource(ctx, opts.Runtime)
+	if err != nil {
+		return fmt.Errorf("local preflight failed: %v", err)
+	}
+	loader, ok := src.(imageLoader)
+	if !ok {
+		return fmt.Errorf("%s cannot load images", src.describe())
+	}
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	if remote.windows() {
+		return fmt.Errorf("fetching images from Windows hosts is not supported")
+	}
+
+	console.Printf("[CHECKING] Verifying if %s exists on %s...\n", imageName, remote.host)
+	imageID, err := checkRemoteImage(imageName, remote)
+	if err != nil {
+		return fmt.Errorf("error checking remote image: %v", err)
+	}
+	if imageID == "" {
+		return fmt.Errorf("image %s not found on %s", imageName, remote.host)
+	}
+	if info, err := src.inspect(remote.ctx, imageName); err == nil && info.ID == imageID {
+		console.Printf("[SKIPPING] Image %s already exists locally - no transfer needed\n", imageName)
+		return nil
+	}
+	size, err := remoteImageSize(imageName, remote)
+	if err != nil {
+		// The size is only used for the progress display
+		console.Printf("[WARNING] Unable to read the size of %s on %s: %v\n", imageName, remote.host, err)
+	}
+
+	defer remote.watchInterrupts()()
+
+	// A failed local load aborts the remote save, which would otherwise
+	// block on a full channel
+	ctx, cancel := context.WithCancelCause(remote.ctx)
+	defer cancel(nil)
+	r, w := io.Pipe()
+	loaded := make(chan error, 1)
+	go func() {
+		err := loader.load(ctx, r)
+		if err != nil {
+			cancel(err)
+		}
+		r.CloseWithError(err)
+		loaded <- err
+	}()
+
+	console.Printf("[FETCH] Streaming %s from %s into %s\n", imageName, remote.host, src.describe())
+	save := remote.command(remote.docker("save " + remote.quote(imageName)))
+	err = ssh.FetchRun(ctx, w, size, imageName, save, remote.user, remote.host, remote.sshOpts)
+	w.CloseWithError(err)
+	loadErr := <-loaded
+	switch {
+	case err != nil && !errors.Is(err, loadErr):
+		return fmt.Errorf("failed to fetch %s from %s: %v", imageName, remote.host, err)
+	case loadErr != nil:
+		return fmt.Errorf("failed to load %s locally: %v", imageName, loadErr)
+	}
+	console.Printf("[SUCCESS] Image %s fetched from %s\n", imageName, remote.host)
+	return nil
+}
+
+// remoteImageSize returns the uncompressed size of imageName on the remote.
+func remoteImageSize(imageName string, remote *remoteHost) (int64, error) {
+	output, err := remote.run(remote.docker("image inspect --format " + remote.quote("{{json .Size}}") + " " + remote.quote(imageName)))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &size); err != nil {
+		return 0, fmt.Errorf("unexpected image size %q: %v", strings.TrimSpace(output), err)
+	}
+	return size, nil
+}
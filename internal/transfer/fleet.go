@@ -0,0 +1,176 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"remote-pull/internal/console"
+)
+
+// Fleet is the consolidated image inventory of a set of hosts.
+type Fleet struct {
+	Hosts        []FleetHost
+	Repositories []FleetRepository
+}
+
+// FleetHost is a host of the inventory; Err is set when its images could
+// not be listed.
+type FleetHost struct {
+	Target string
+	Err    error
+}
+
+// FleetRepository lists the versions of one image repository found on the
+// fleet, the most widespread first.
+type FleetRepository struct {
+	Name     string
+	Versions []FleetVersion
+	// Missing lists the inventoried hosts without any version.
+	Missing []string
+}
+
+// Drift reports whether the hosts run different versions of the repository.
+func (r FleetRepository) Drift() bool {
+	return len(r.Versions) > 1
+}
+
+// FleetVersion is one image ID of a repository and the hosts that have it.
+type FleetVersion struct {
+	ID      string
+	Tags    []string
+	Digests []string
+	Hosts   []string
+}
+
+// InventoryFleet lists the images of all targets concurrently and groups them
+// by repository and image ID. images restricts the repositories to those
+// matching one of the patterns, as in --allow-registry; dangling images are
+// left out.
+func InventoryFleet(ctx context.Context, targets, images []string, opts Options) *Fleet {
+	console.Printf("[REPORT] Collecting the images of %d hosts\n", len(targets))
+	inventories := make([][]imageSummary, len(targets))
+	fleet := &Fleet{Hosts: make([]FleetHost, len(targets))}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, planConcurrency)
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			fleet.Hosts[i].Target = target
+			inventories[i], fleet.Hosts[i].Err = listImages(ctx, target, opts)
+		}()
+	}
+	wg.Wait()
+
+	repos := map[string]map[string]*FleetVersion{}
+	for i, host := range fleet.Hosts {
+		if host.Err != nil {
+			console.Printf("[FAILED] %s: %v\n", host.Target, host.Err)
+			continue
+		}
+		for _, image := range inventories[i] {
+			if image.Repository == "" || image.Repository == "<none>" || !selectRepository(image.Repository, images) {
+				continue
+			}
+			if repos[image.Repository] == nil {
+				repos[image.Repository] = map[string]*FleetVersion{}
+			}
+			version := repos[image.Repository][image.ID]
+			if version == nil {
+				version = &FleetVersion{ID: image.ID}
+				repos[image.Repository][image.ID] = version
+			}
+			addUnique(&version.Hosts, host.Target)
+			if image.Tag != "" && image.Tag != "<none>" {
+				addUnique(&version.Tags, image.Tag)
+			}
+			if image.Digest != "" && image.Digest != "<none>" {
+				addUnique(&version.Digests, image.Digest)
+			}
+		}
+	}
+
+	for name, versions := range repos {
+		repo := FleetRepository{Name: name}
+		present := map[string]bool{}
+		for _, version := range versions {
+			sort.Strings(version.Tags)
+			repo.Versions = append(repo.Versions, *version)
+			for _, host := range version.Hosts {
+				present[host] = true
+			}
+		}
+		sort.Slice(repo.Versions, func(i, j int) bool {
+			a, b := repo.Versions[i], repo.Versions[j]
+			if len(a.Hosts) != len(b.Hosts) {
+				return len(a.Hosts) > len(b.Hosts)
+			}
+			return a.ID < b.ID
+		})
+		for _, host := range fleet.Hosts {
+			if host.Err == nil && !present[host.Target] {
+				repo.Missing = append(repo.Missing, host.Target)
+			}
+		}
+		fleet.Repositories = append(fleet.Repositories, repo)
+	}
+	sort.Slice(fleet.Repositories, func(i, j int) bool {
+		return fleet.Repositories[i].Name < fleet.Repositories[j].Name
+	})
+	return fleet
+}
+
+// Failed returns the number of hosts whose images could not be listed.
+func (f *Fleet) Failed() int {
+	n := 0
+	for _, host := range f.Hosts {
+		if host.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// listImages returns all images on target, one entry per tag and digest.
+func listImages(ctx context.Context, target string, opts Options) ([]imageSummary, error) {
+	remote, err := resolveRemote(ctx, target, opts)
+	if err != nil {
+		return nil, err
+	}
+	output, err := remote.run(remote.docker("images --no-trunc --digests --format " + remote.quote("{{json .}}")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	images, err := decodeJSONLines[imageSummary](output)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected output from remote docker images: %v", err)
+	}
+	return images, nil
+}
+
+// selectRepository reports whether repo matches one of patterns, using the
+// matching of the registry policy.
+func selectRepository(repo string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	ref := parseReference(repo)
+	for _, pattern := range patterns {
+		if matchRepository(pattern, ref.Registry+"/"+ref.Repository) {
+			return true
+		}
+	}
+	return false
+}
+
+func addUnique(list *[]string, s string) {
+	if !slices.Contains(*list, s) {
+		*list = append(*list, s)
+	}
+}
@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// helperImageName matches the image names passed to the remote helper,
+// which are sent unquoted since ForceCommand wrappers split the command on
+// whitespace.
+var helperImageName = regexp.MustCompile(`^[A-Za-z0-9._/:@+-]+$`)
+
+// helperTarget transfers imageName to a remote whose account may only run
+// a pre-installed helper, e.g. through ForceCommand or an rbash PATH. Two
+// commands are used and nothing else is run: "<helper> check <image>"
+// prints the ID of the image or nothing when it is missing, and "<helper>
+// load" loads an archive from stdin.
+func helperTarget(imageName string, remote *remoteHost, src imageSource, opts Options, result *Result) error {
+	if !helperImageName.MatchString(imageName) {
+		return fmt.Errorf("image name %q cannot be passed to the remote helper", imageName)
+	}
+	check := func() (string, error) {
+		output, err := remote.run(opts.RemoteHelper + " check " + imageName)
+		if err != nil {
+			return "", fmt.Errorf("remote helper check failed: %v", err)
+		}
+		return strings.TrimSpace(output), nil
+	}
+
+	console.Printf("[CHECKING] Verifying if %s exists on %s via %s...\n", imageName, remote.host, opts.RemoteHelper)
+	imageID, err := check()
+	if err != nil {
+		return err
+	}
+	if imageID != "" {
+		console.Printf("[SKIPPING] Image %s already exists on %s - no transfer needed\n", imageName, remote.host)
+		result.Status = StatusSkipped
+		result.ImageID = imageID
+		return nil
+	}
+
+	if !opts.SkipPull {
+		if err := src.pull(remote.ctx, imageName); err != nil {
+			return fmt.Errorf("error pulling local image: %v", err)
+		}
+	}
+	if err := authorizeTransfer(imageName, remote, src, opts.Policy); err != nil {
+		return err
+	}
+
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	tmpFile, removeLocal, err := saveArchive(remote.ctx, imageName, "", src, opts)
+	if err != nil {
+		return err
+	}
+	defer removeLocal()
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get archive size: %v", err)
+	}
+	result.Bytes = info.Size()
+
+	console.Printf("[TRANSFER] Streaming %.2f MB to %s via %s load\n", mb(info.Size()), remote.host, opts.RemoteHelper)
+	if err := ssh.StreamRun(remote.ctx, tmpFile, opts.RemoteHelper+" load", remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+	}
+	result.Status = StatusTransferred
+
+	if result.ImageID, err = check(); err != nil {
+		console.Printf("[WARNING] Unable to read image ID of %s on %s: %v\n", imageName, remote.host, err)
+	}
+	console.Printf("[SUCCESS] Image %s successfully transferred and loaded on %s\n", imageName, remote.host)
+	return nil
+}
@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// PostLoadOptions configures the remote command run after an image was
+// loaded, e.g. to restart the service using it.
+type PostLoadOptions struct {
+	// Command is a text/template rendered with the image and host variables
+	// and the user defined Vars, e.g. "systemctl restart {{.Service}}".
+	Command string
+	// Vars are additional template variables given as KEY=VALUE.
+	Vars []string
+}
+
+// HealthCheckOptions configures the check gating a deployment: Command is run
+// on the remote every Interval until it succeeds or Timeout has passed.
+type HealthCheckOptions struct {
+	Command  string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// parsePostLoad parses the command template, so that mistakes surface
+// before anything is transferred.
+func parsePostLoad(opts PostLoadOptions) (*template.Template, error) {
+	if opts.Command == "" {
+		return nil, nil
+	}
+	for _, v := range opts.Vars {
+		if !strings.Contains(v, "=") {
+			return nil, fmt.Errorf("invalid --post-var %q, expected KEY=VALUE", v)
+		}
+	}
+	tmpl, err := template.New("post-cmd").Option("missingkey=error").Parse(opts.Command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --post-cmd: %v", err)
+	}
+	// Render once with empty values to catch unknown variables
+	if err := tmpl.Execute(io.Discard, postLoadVars("", "", nil, opts.Vars)); err != nil {
+		return nil, fmt.Errorf("invalid --post-cmd: %v", err)
+	}
+	return tmpl, nil
+}
+
+// runPostLoad renders and runs the post-load command on the remote. The
+// built-in variables are Image, Repository, Tag, Digest, ImageID, Host and
+// User. src, when set, is asked for the digest of untagged references.
+func runPostLoad(tmpl *template.Template, opts PostLoadOptions, imageName string, remote *remoteHost, src imageSource, imageID string) error {
+	vars := postLoadVars(imageName, imageID, remote, opts.Vars)
+	if vars["Digest"] == "" && src != nil {
+		if info, err := src.inspect(remote.ctx, imageName); err == nil {
+			vars["Digest"] = repoDigest(info.RepoDigests)
+		}
+	}
+
+	var cmd bytes.Buffer
+	if err := tmpl.Execute(&cmd, vars); err != nil {
+		return fmt.Errorf("failed to render post-load command: %v", err)
+	}
+	console.Printf("[POST-LOAD] Running %s on %s\n", cmd.String(), remote.host)
+	output, err := remote.run(remote.withLogin(cmd.String()))
+	if output != "" {
+		console.Writer("[POST-LOAD] ").Write([]byte(strings.TrimRight(output, "\n") + "\n"))
+	}
+	if err != nil {
+		return fmt.Errorf("post-load command failed on %s: %v", remote.host, err)
+	}
+	return nil
+}
+
+func postLoadVars(imageName, imageID string, remote *remoteHost, extra []string) map[string]string {
+	ref := parseReference(imageName)
+	vars := map[string]string{
+		"Image":      imageName,
+		"Repository": ref.Registry + "/" + ref.Repository,
+		"Tag":        ref.Tag,
+		"Digest":     ref.Digest,
+		"ImageID":    imageID,
+		"Host":       "",
+		"User":       "",
+	}
+	if remote != nil {
+		vars["Host"], vars["User"] = remote.host, remote.user
+	}
+	for _, v := range extra {
+		key, value, _ := strings.Cut(v, "=")
+		vars[key] = value
+	}
+	return vars
+}
+
+// waitHealthy runs the health check until it passes and fails once the
+// timeout has passed without success.
+func waitHealthy(remote *remoteHost, opts HealthCheckOptions) error {
+	console.Printf("[HEALTHCHECK] Waiting for %s to pass on %s (timeout %s)\n", opts.Command, remote.host, opts.Timeout)
+	deadline := time.Now().Add(opts.Timeout)
+	for attempt := 1; ; attempt++ {
+		output, err := remote.run(remote.withLogin(opts.Command))
+		if err == nil {
+			console.Printf("[HEALTHCHECK] Passed on %s after %d attempt(s)\n", remote.host, attempt)
+			return nil
+		}
+		if time.Now().Add(opts.Interval).After(deadline) {
+			if output = strings.TrimSpace(output); output != "" {
+				console.Writer("[HEALTHCHECK] ").Write([]byte(output + "\n"))
+			}
+			return fmt.Errorf("health check failed on %s after %d attempts: %v", remote.host, attempt, err)
+		}
+		console.Printf("[HEALTHCHECK] Attempt %d failed on %s, retrying in %s\n", attempt, remote.host, opts.Interval)
+		time.Sleep(opts.Interval)
+	}
+}
+
+// repoDigest returns the digest of the first repository digest reference.
+func repoDigest(repoDigests []string) string {
+	for _, d := range repoDigests {
+		if _, digest, ok := strings.Cut(d, "@"); ok {
+			return digest
+		}
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// imageSummary is one entry of `docker images --format '{{json .}}'`.
+type imageSummary struct {
+	ID         string `json:"ID"`
+	Repository string `json:"Repository"`
+	Tag        string `json:"Tag"`
+	Digest     string `json:"Digest"`
+	Size       string `json:"Size"`
+}
+
+// decodeJSONLines decodes output consisting of one JSON document per line,
+// as produced by the runtime CLIs with --format '{{json .}}'. Blank lines are
+// ignored.
+func decodeJSONLines[T any](output string) ([]T, error) {
+	var items []T
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var item T
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			return nil, fmt.Errorf("invalid JSON line %q: %v", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
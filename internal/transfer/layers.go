@@ -0,0 +1,94 @@
+package transfer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// LayerStat describes how one image layer was transferred.
+type LayerStat struct {
+	// ID is the layer digest, or the legacy layer ID for older archives.
+	ID   string
+	Size int64
+	// Duration is the time between sending the first and the last byte of
+	// the layer.
+	Duration time.Duration
+	// Skipped is set when the layer was not sent because the remote
+	// already had it.
+	Skipped bool
+}
+
+// layerTimer derives per-layer transfer times from the progress of the
+// archive upload, using the position of every layer in the archive.
+type layerTimer struct {
+	mu      sync.Mutex
+	layers  []archiveLayer
+	stats   []LayerStat
+	started []time.Time
+	done    int
+}
+
+func newLayerTimer(layers []archiveLayer) *layerTimer {
+	t := &layerTimer{
+		layers:  layers,
+		stats:   make([]LayerStat, len(layers)),
+		started: make([]time.Time, len(layers)),
+	}
+	for i, layer := range layers {
+		t.stats[i] = LayerStat{ID: layer.ID, Size: layer.Size}
+	}
+	return t
+}
+
+// copied is called with the number of archive bytes sent so far.
+func (t *layerTimer) copied(written int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done == len(t.layers) {
+		return
+	}
+	now := time.Now()
+	// Layers are listed in manifest order, which need not be archive order
+	for i, layer := range t.layers {
+		if t.stats[i].Duration != 0 || written <= layer.Offset {
+			continue
+		}
+		if t.started[i].IsZero() {
+			t.started[i] = now
+		}
+		if written >= layer.Offset+layer.Size {
+			t.stats[i].Duration = max(now.Sub(t.started[i]), time.Nanosecond)
+			t.done++
+		}
+	}
+}
+
+func (t *layerTimer) result() []LayerStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]LayerStat(nil), t.stats...)
+}
+
+// printLayers prints the per-layer breakdown of a transfer.
+func printLayers(layers []LayerStat) {
+	for _, layer := range layers {
+		sizeMB := float64(layer.Size) / 1024 / 1024
+		if layer.Skipped {
+			console.Printf("[LAYERS] %s %8.2f MB  skipped (present on remote)\n", shortID(layer.ID), sizeMB)
+			continue
+		}
+		console.Printf("[LAYERS] %s %8.2f MB  %s\n", shortID(layer.ID), sizeMB, layer.Duration.Round(time.Millisecond))
+	}
+}
+
+// shortID abbreviates a layer digest the way docker prints image IDs.
+func shortID(id string) string {
+	hex := strings.TrimPrefix(id, "sha256:")
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
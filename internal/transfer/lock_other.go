@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package transfer
+
+import "os"
+
+// tryLockFile is a no-op where file locking is not supported; identical
+// transfers are then not coalesced.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
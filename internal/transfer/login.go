@@ -0,0 +1,201 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// dockerHubServer is the key docker uses for Docker Hub credentials.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// RegistryLogin configures "docker login" on the remote host.
+type RegistryLogin struct {
+	// Registries to log in to on the remote.
+	Registries []string
+	// Username and Password override the credentials from the local docker
+	// configuration for all registries.
+	Username string
+	Password string
+}
+
+// registryCredentials returns the credentials for registry, taken from the
+// flags or the local docker configuration (including credential helpers).
+func (l RegistryLogin) registryCredentials(registry string) (string, string, error) {
+	if l.Username != "" || l.Password != "" {
+		if l.Username == "" || l.Password == "" {
+			return "", "", fmt.Errorf("both a registry username and password are required")
+		}
+		return l.Username, l.Password, nil
+	}
+
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return "", "", fmt.Errorf("no credentials for %s: %v", registry, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", "", fmt.Errorf("no credentials for %s: %v", registry, err)
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("failed to parse docker config: %v", err)
+	}
+
+	server := registry
+	if registry == "docker.io" || registry == "index.docker.io" {
+		server = dockerHubServer
+	}
+	if helper := valueOr(config.CredHelpers[registry], config.CredsStore); helper != "" {
+		return credentialHelper(helper, server)
+	}
+	for key, entry := range config.Auths {
+		if strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://"), "/") != strings.TrimSuffix(strings.TrimPrefix(server, "https://"), "/") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth entry for %s in docker config: %v", key, err)
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return "", "", fmt.Errorf("invalid auth entry for %s in docker config", key)
+		}
+		return user, password, nil
+	}
+	return "", "", fmt.Errorf("no credentials for %s in docker config (run docker login locally first)", registry)
+}
+
+// dockerConfigDir returns the configuration directory of the docker CLI,
+// DOCKER_CONFIG or ~/.docker.
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("set DOCKER_CONFIG: %v", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// credentialHelper queries a docker credential helper for server.
+func credentialHelper(helper, server string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = console.Writer("")
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("credential helper %s failed for %s: %v", helper, server, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("unexpected output from credential helper %s: %v", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// loginDirScript creates a private directory for the docker configuration
+// holding the credentials of --remote-login, preferring memory-backed
+// filesystems so they never reach the disk. It prints the directory and, on
+// the second line, "disk" if it had to fall back to the temp directory.
+const loginDirScript = `umask 077
+for d in "$XDG_RUNTIME_DIR" /dev/shm; do
+  if [ -n "$d" ] && [ -d "$d" ] && [ -w "$d" ]; then mktemp -d "$d/remote-pull-login.XXXXXX" && exit 0; fi
+done
+mktemp -d "${TMPDIR:-/tmp}/remote-pull-login.XXXXXX" && echo disk`
+
+// loginRemote runs docker login on the remote for each configured registry.
+// The password is sent over the SSH session's stdin so it never appears on a
+// command line, and docker stores it in a temporary configuration directory
+// instead of the remote user's ~/.docker/config.json. All docker commands
+// and hooks of the transfer use that directory (see docker and withLogin).
+// The returned function removes it again; it also runs if the process is
+// interrupted.
+func loginRemote(remote *remoteHost, login RegistryLogin) (func(), error) {
+	if len(login.Registries) == 0 {
+		return func() {}, nil
+	}
+	script := loginDirScript
+	if remote.windows() {
+		script = `$d = Join-Path $env:TEMP ("remote-pull-login-" + [guid]::NewGuid()); New-Item -ItemType Directory -Path $d | Out-Null; $d; "disk"`
+	}
+	output, err := remote.run(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary docker config on %s: %v", remote, err)
+	}
+	dir, onDisk, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	if dir = strings.TrimSpace(dir); dir == "" {
+		return nil, fmt.Errorf("failed to create temporary docker config on %s", remote)
+	}
+	if strings.TrimSpace(onDisk) != "" {
+		console.Printf("[WARNING] No memory-backed directory on %s, registry credentials are kept in %s until the transfer ends\n", remote, dir)
+	}
+
+	stopWatching := remote.watchInterrupts()
+	remove := func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(remote.ctx), cleanupTimeout)
+		defer cancel()
+		cmd := "rm -rf " + remote.quote(dir)
+		if remote.windows() {
+			cmd = "Remove-Item -Recurse -Force -ErrorAction SilentlyContinue -LiteralPath " + remote.quote(dir)
+		}
+		if _, err := remote.runContext(ctx, cmd); err != nil {
+			console.Printf("[WARNING] Failed to remove registry credentials %s from %s: %v\n", dir, remote, err)
+		}
+	}
+	unregister := onInterrupt(remove)
+	remote.dockerConfig = dir
+	logout := func() {
+		remote.dockerConfig = ""
+		unregister()
+		remove()
+		stopWatching()
+	}
+
+	for _, registry := range login.Registries {
+		user, password, err := login.registryCredentials(registry)
+		if err != nil {
+			logout()
+			return nil, err
+		}
+		console.Redact(password)
+		console.Printf("[LOGIN] Logging in to %s on %s as %s\n", registry, remote, user)
+		cmd := remote.docker(fmt.Sprintf("login --username %s --password-stdin %s", remote.quote(user), remote.quote(registry)))
+		if _, err := remote.runInput(cmd, strings.NewReader(password+"\n")); err != nil {
+			logout()
+			return nil, fmt.Errorf("remote docker login to %s failed: %v", registry, err)
+		}
+	}
+	return logout, nil
+}
+
+// withLogin makes the docker configuration with the credentials of
+// --remote-login available to cmd, a user command such as a hook.
+func (r *remoteHost) withLogin(cmd string) string {
+	if r.dockerConfig == "" {
+		return cmd
+	}
+	if r.windows() {
+		return fmt.Sprintf("$env:DOCKER_CONFIG = %s; %s", r.quote(r.dockerConfig), cmd)
+	}
+	return fmt.Sprintf("export DOCKER_CONFIG=%s REGISTRY_AUTH_FILE=%s; %s", r.quote(r.dockerConfig), r.quote(r.join(r.dockerConfig, "config.json")), cmd)
+}
@@ -0,0 +1,94 @@
+package transfer
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// Labels added by MetadataOptions.DeployLabels.
+const (
+	labelDeployedAt = "com.remote-pull.deployed-at"
+	labelRevision   = "com.remote-pull.revision"
+)
+
+// MetadataOptions controls the image metadata applied on the remote beyond
+// what the archive carries, which is the image (including its labels) and
+// the transferred tag.
+type MetadataOptions struct {
+	// Tags also tags the image on the remote with the other local tags of
+	// the same image.
+	Tags bool
+	// Labels are KEY=VALUE labels added to the image on the remote after it
+	// was loaded.
+	Labels []string
+	// DeployLabels adds labels recording the deployment: the time and the
+	// git revision of the working directory.
+	DeployLabels bool
+}
+
+// checkLabels validates the KEY=VALUE syntax of labels.
+func checkLabels(labels []string) error {
+	for _, label := range labels {
+		if key, _, ok := strings.Cut(label, "="); !ok || key == "" {
+			return fmt.Errorf("invalid --label %q, expected KEY=VALUE", label)
+		}
+	}
+	return nil
+}
+
+// deployLabels returns the labels added with MetadataOptions.DeployLabels.
+// They are determined once per run, so all hosts get the same values.
+func deployLabels() []string {
+	labels := []string{labelDeployedAt + "=" + time.Now().UTC().Format(time.RFC3339)}
+	if output, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		labels = append(labels, labelRevision+"="+strings.TrimSpace(string(output)))
+	}
+	return labels
+}
+
+// labelImage adds labels to imageName on the remote and returns the ID of
+// the labeled image. Labels are part of the image configuration, so the
+// image is rebuilt from itself with a build that adds no layers, and the tag
+// moves to the result.
+func labelImage(imageName string, labels []string, remote *remoteHost) (string, error) {
+	if parseReference(imageName).Tag == "" {
+		console.Printf("[WARNING] Not labeling %s on %s: labels need a tag to move to the labeled image\n", imageName, remote.host)
+		return "", nil
+	}
+	args := []string{"build", "--quiet"}
+	for _, label := range labels {
+		args = append(args, "--label", remote.quote(label))
+	}
+	args = append(args, "--tag", remote.quote(imageName), "-")
+	console.Printf("[LABEL] Adding %s to %s on %s\n", strings.Join(labels, ", "), imageName, remote.host)
+	output, err := remote.runInput(remote.docker(strings.Join(args, " ")), strings.NewReader("FROM "+imageName+"\n"))
+	if err != nil {
+		return "", fmt.Errorf("failed to label %s on %s: %v", imageName, remote.host, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// propagateTags tags imageID on the remote with all other local tags of
+// imageName.
+func propagateTags(imageName, imageID string, remote *remoteHost, src imageSource) error {
+	info, err := src.inspect(remote.ctx, imageName)
+	if err != nil {
+		console.Printf("[WARNING] Unable to list the local tags of %s: %v\n", imageName, err)
+		return nil
+	}
+	name := parseReference(imageName).String()
+	for _, tag := range info.RepoTags {
+		if parseReference(tag).String() == name {
+			continue
+		}
+		console.Printf("[TAG] Tagging %s as %s on %s\n", imageName, tag, remote.host)
+		if _, err := remote.run(remote.docker("tag " + imageID + " " + remote.quote(tag))); err != nil {
+			return fmt.Errorf("failed to tag %s as %s on %s: %v", imageName, tag, remote.host, err)
+		}
+	}
+	return nil
+}
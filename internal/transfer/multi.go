@@ -0,0 +1,308 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+const (
+	StatusTransferred = "transferred"
+	StatusSkipped     = "skipped"
+	StatusFailed      = "failed"
+)
+
+// Result describes the outcome of transferring an image to one host.
+type Result struct {
+	Target string
+	Image  string
+	// Status is StatusTransferred, StatusSkipped or StatusFailed.
+	Status string
+	// ImageID is the ID of the image on the remote after the run, if known.
+	ImageID string
+	// Bytes is the size of the archive sent to the host.
+	Bytes int64
+	// Layers breaks the transfer down per image layer, when the image was
+	// sent.
+	Layers   []LayerStat
+	Duration time.Duration
+	Err      error
+}
+
+// HostsError is returned by runs that failed on some of their hosts, and
+// lists them.
+type HostsError struct {
+	Hosts []string
+	Err   error
+}
+
+func (e *HostsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HostsError) Unwrap() error {
+	return e.Err
+}
+
+// Reporter receives the progress and results of a run, e.g. to integrate
+// with CI systems.
+type Reporter interface {
+	BeginHost(target string)
+	EndHost(result Result)
+	Finish(results []Result) error
+}
+
+// TransferToTargets transfers imageName to every target and prints a
+// per-host summary. Up to opts.Parallel hosts are transferred to at the same
+// time, sharing one local archive of the image. A failure on one host does
+// not stop the others; an error is returned if any host failed. With
+// opts.Canary the first hosts go first, and the others only follow if all
+// of them succeeded. With several targets all hosts are checked for the
+// image up front.
+func TransferToTargets(ctx context.Context, imageName string, targets []string, opts Options) error {
+	return TransferImages(ctx, []string{imageName}, targets, opts)
+}
+
+// TransferImages transfers every image to every target like
+// TransferToTargets, and prints a per-image summary. Up to
+// opts.ParallelImages images are transferred at the same time; each host is
+// connected to once, and the connection is shared by the images. A failure
+// of one image does not stop the others. The reporters receive the results
+// of all images together. With opts.BandwidthLimit the uploads to all hosts
+// and of all images share the limit.
+func TransferImages(ctx context.Context, images, targets []string, opts Options) error {
+	results, err := transferImages(ctx, images, targets, opts)
+	finishReports(results, opts)
+	return err
+}
+
+// transferImages transfers every image to every target (see
+// TransferImages) and returns the result of each image and host.
+func transferImages(ctx context.Context, images, targets []string, opts Options) ([]Result, error) {
+	if err := checkLabels(opts.Metadata.Labels); err != nil {
+		return nil, err
+	}
+	if opts.Metadata.DeployLabels {
+		opts.Metadata.Labels = append(slices.Clip(opts.Metadata.Labels), deployLabels()...)
+		opts.Metadata.DeployLabels = false
+	}
+	if opts.BandwidthLimit > 0 {
+		opts.bandwidth = ssh.NewBandwidth(opts.BandwidthLimit * 1024 * 1024)
+	}
+	if len(images) == 1 {
+		return transferToTargets(ctx, images[0], targets, opts)
+	}
+
+	// Refuse all images up front rather than transferring some of them
+	for _, image := range images {
+		if err := checkPolicy(image, opts.AllowedRegistries); err != nil {
+			return nil, err
+		}
+	}
+	opts.conns = ssh.NewPool()
+	defer opts.conns.Close()
+	opts.reportMu = &sync.Mutex{}
+	console.Printf("[IMAGES] Transferring %d images to %d hosts\n", len(images), len(targets))
+
+	results := make([][]Result, len(images))
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(opts.ParallelImages, 1))
+	for i, image := range images {
+		// Taken in order, so images start in the order given
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			console.Printf("[IMAGE %d/%d] %s\n", i+1, len(images), image)
+			results[i], errs[i] = transferToTargets(ctx, image, targets, opts)
+		}()
+	}
+	wg.Wait()
+
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			failures++
+		}
+	}
+	console.Printf("\n[SUMMARY] %d/%d images transferred\n", len(images)-failures, len(images))
+	for i, image := range images {
+		if errs[i] != nil {
+			console.Printf("  FAIL  %s: %v\n", image, errs[i])
+		} else {
+			console.Printf("  OK    %s\n", image)
+		}
+	}
+	if failures > 0 {
+		return slices.Concat(results...), fmt.Errorf("transfer failed for %d of %d images", failures, len(images))
+	}
+	return slices.Concat(results...), nil
+}
+
+// finishReports hands the results of a run to the reporters.
+func finishReports(results []Result, opts Options) {
+	for _, r := range opts.Reporters {
+		if err := r.Finish(results); err != nil {
+			console.Printf("[WARNING] Failed to write results: %v\n", err)
+		}
+	}
+}
+
+// transferToTargets transfers imageName to every target (see
+// TransferToTargets) and returns the result of each host.
+func transferToTargets(ctx context.Context, imageName string, targets []string, opts Options) ([]Result, error) {
+	// Hosts that do not answer are left out or tried last, so they do not
+	// hold up the others
+	var unreachable map[string]error
+	reachable := targets
+	if len(targets) > 1 && opts.Unreachable != "" && opts.Unreachable != UnreachableWait {
+		unreachable = probeTargets(ctx, targets, opts)
+		reachable = slices.DeleteFunc(slices.Clone(targets), func(target string) bool {
+			_, ok := unreachable[target]
+			return ok
+		})
+		// Skipped hosts go last as well, so they do not count as failed
+		// canaries
+		if len(unreachable) > 0 {
+			targets = append(slices.Clone(reachable), slices.DeleteFunc(slices.Clone(targets), func(target string) bool {
+				return slices.Contains(reachable, target)
+			})...)
+		}
+		if opts.Unreachable == UnreachableDefer && len(unreachable) > 0 {
+			console.Printf("[DEFERRED] Transferring to %d unreachable hosts after the others\n", len(unreachable))
+		}
+	}
+	var plan transferPlan
+	if len(reachable) > 1 && !opts.NoLoad && opts.RemoteHelper == "" {
+		plan = planTargets(ctx, imageName, reachable, opts)
+	}
+	if len(targets) > 1 && !opts.Pipe {
+		// Shared archives stay until the last host is done
+		var stopWatching func()
+		ctx, stopWatching = watchInterrupts(ctx)
+		defer stopWatching()
+		opts.archives = newArchiveCache(ctx)
+		defer opts.archives.removeAll()
+	}
+
+	results := make([]Result, len(targets))
+	reportMu := opts.reportMu
+	if reportMu == nil {
+		reportMu = &sync.Mutex{}
+	}
+	transferHosts := func(from, to int) {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, max(opts.Parallel, 1))
+		for i := from; i < to; i++ {
+			if err, ok := unreachable[targets[i]]; ok && opts.Unreachable == UnreachableSkip {
+				results[i] = Result{Target: targets[i], Image: imageName, Status: StatusFailed, Err: fmt.Errorf("not attempted, unreachable: %v", err)}
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				target := targets[i]
+				if len(targets) > 1 {
+					console.Printf("[HOST %d/%d] %s\n", i+1, len(targets), target)
+				}
+				reportMu.Lock()
+				for _, r := range opts.Reporters {
+					r.BeginHost(target)
+				}
+				reportMu.Unlock()
+
+				start := time.Now()
+				result := Result{Target: target, Image: imageName}
+				hostOpts := opts
+				hostOpts.events = newHostEvents(target, opts.Reporters, reportMu)
+				if err := transferTarget(ctx, imageName, target, plan[target], hostOpts, &result); err != nil {
+					result.Status = StatusFailed
+					result.Err = err
+					if len(targets) > 1 {
+						console.Printf("[FAILED] %s: %v\n", target, err)
+					}
+				}
+				result.Duration = time.Since(start)
+
+				reportMu.Lock()
+				for _, r := range opts.Reporters {
+					r.EndHost(result)
+				}
+				reportMu.Unlock()
+				results[i] = result
+			}()
+		}
+		wg.Wait()
+	}
+
+	// notAttempted marks the hosts from next on as failed without trying
+	// them.
+	notAttempted := func(next int, err error) {
+		for i := next; i < len(targets); i++ {
+			results[i] = Result{Target: targets[i], Image: imageName, Status: StatusFailed, Err: err}
+		}
+	}
+	next := 0
+	if opts.Canary > 0 && len(targets) > opts.Canary {
+		transferHosts(0, opts.Canary)
+		next = opts.Canary
+		if failures := countFailures(results[:next]); failures > 0 {
+			console.Printf("[CANARY] %d of %d canary hosts failed, not proceeding to the remaining %d hosts\n", failures, opts.Canary, len(targets)-next)
+			notAttempted(next, fmt.Errorf("not attempted, canary failed"))
+			next = len(targets)
+		} else {
+			if opts.CanaryWait > 0 {
+				console.Printf("[CANARY] Canary hosts succeeded, waiting %s before the remaining %d hosts\n", opts.CanaryWait, len(targets)-next)
+				select {
+				case <-time.After(opts.CanaryWait):
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				console.Printf("[CANARY] Cancelled, not proceeding to the remaining %d hosts\n", len(targets)-next)
+				notAttempted(next, fmt.Errorf("not attempted, cancelled: %v", context.Cause(ctx)))
+				next = len(targets)
+			} else {
+				console.Printf("[CANARY] Proceeding to the remaining %d hosts\n", len(targets)-next)
+			}
+		}
+	}
+	transferHosts(next, len(targets))
+	failures := countFailures(results)
+	if len(targets) == 1 {
+		return results, results[0].Err
+	}
+
+	console.Printf("\n[SUMMARY] %d/%d hosts succeeded\n", len(targets)-failures, len(targets))
+	for _, result := range results {
+		if result.Err != nil {
+			console.Printf("  FAIL  %s: %v\n", result.Target, result.Err)
+		} else {
+			console.Printf("  OK    %s\n", result.Target)
+		}
+	}
+	if failures > 0 {
+		return results, fmt.Errorf("transfer failed on %d of %d hosts", failures, len(targets))
+	}
+	return results, nil
+}
+
+func countFailures(results []Result) int {
+	n := 0
+	for _, result := range results {
+		if result.Err != nil {
+			n++
+		}
+	}
+	return n
+}
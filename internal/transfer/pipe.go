@@ -0,0 +1,67 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// pipeImage feeds the output of the local export straight into docker load
+// on the remote. Nothing is written to disk on either side and the transfer
+// starts with the first byte of the archive, at the price of the archive
+// checks and strategy fallbacks of transferImage, which need the whole
+// archive up front.
+func pipeImage(imageName string, remote *remoteHost, rt *remoteRuntime, src imageSource, opts Options, result *Result) error {
+	if remote.windows() {
+		return fmt.Errorf("[ERROR] --pipe is not supported for Windows remotes")
+	}
+	console.Printf("[CONNECTING] Establishing connection to '%s' ...\n", remote)
+	load := remote.loadCommand("")
+	decompress, err := remote.decompressor(rt, opts)
+	if err != nil {
+		return fmt.Errorf("[ERROR] %v", err)
+	}
+	if decompress != "" {
+		load = decompress + " | " + load
+	}
+	// The size of the image is only an estimate of the archive size, used
+	// for the progress display
+	size, err := src.size(remote.ctx, imageName)
+	if err != nil {
+		console.Printf("[WARNING] Unable to determine size of %s, progress will not be accurate: %v\n", imageName, err)
+	}
+	sshOpts := remote.sshOpts
+	sshOpts.Compressor = compressor(opts)
+	sshOpts.Copied = opts.events.copied(size, nil)
+	opts.events.phase(PhaseTransfer)
+
+	r, w := io.Pipe()
+	exported := make(chan error, 1)
+	go func() {
+		err := src.export(remote.ctx, imageName, w)
+		w.CloseWithError(err)
+		exported <- err
+	}()
+
+	console.Printf("[TRANSFER] Piping %s from %s to %s (about %.2f MB)\n", imageName, src.describe(), remote.host, mb(size))
+	counter := &countingWriter{}
+	err = ssh.PipeRun(remote.ctx, io.TeeReader(r, counter), size, imageName, remote.command(load), remote.user, remote.host, opts.LoadTimeout, sshOpts)
+	// Unblock the export if the remote stopped reading early
+	r.Close()
+	exportErr := <-exported
+	result.Bytes = counter.n
+
+	switch {
+	case err != nil && exportErr != nil:
+		return fmt.Errorf("[ERROR] Transfer failed: %v (local export: %v)", err, exportErr)
+	case err != nil:
+		return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+	case exportErr != nil:
+		return fmt.Errorf("[ERROR] Failed to export image: %v", exportErr)
+	}
+	console.Printf("[STRATEGY] Transferred to %s with %s\n", remote.host, ssh.StrategyStream)
+	console.Printf("[SUCCESS] Image %s successfully piped and loaded on %s (%.2f MB)\n", imageName, remote.host, mb(counter.n))
+	return nil
+}
@@ -0,0 +1,92 @@
+package transfer
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"remote-pull/internal/console"
+)
+
+// planConcurrency bounds the number of hosts checked at the same time.
+const planConcurrency = 16
+
+// hostCheck is the cached outcome of checking one host for the image.
+type hostCheck struct {
+	remote *remoteHost
+	// imageID is the ID of the image on the host, "" if it is missing.
+	imageID string
+	err     error
+}
+
+// transferPlan holds the existence checks of all hosts of a run, keyed by
+// target.
+type transferPlan map[string]*hostCheck
+
+// planTargets checks all targets for imageName concurrently before anything
+// is transferred and prints which hosts need the image. The checks are
+// cached for the run, so transferTarget does not repeat them. It returns nil
+// when the image name cannot be resolved or is not allowed, leaving the
+// error to be reported per host.
+func planTargets(ctx context.Context, imageName string, targets []string, opts Options) transferPlan {
+	name, err := sourceName(ctx, imageName)
+	if err != nil || checkPolicy(name, opts.AllowedRegistries) != nil {
+		return nil
+	}
+
+	console.Printf("[PLAN] Checking %d hosts for %s\n", len(targets), name)
+	plan := make(transferPlan, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, planConcurrency)
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			check := &hostCheck{}
+			if check.remote, check.err = resolveRemote(ctx, target, opts); check.err == nil {
+				check.imageID, check.err = checkRemoteImage(name, check.remote)
+			}
+			mu.Lock()
+			plan[target] = check
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var present, missing, failed []string
+	for _, target := range targets {
+		switch check := plan[target]; {
+		case check.err != nil:
+			failed = append(failed, target)
+		case check.imageID != "":
+			present = append(present, target)
+		default:
+			missing = append(missing, target)
+		}
+	}
+	console.Printf("[PLAN] Transfer to %d hosts, %d already have the image, %d could not be checked\n", len(missing), len(present), len(failed))
+	for _, target := range missing {
+		console.Printf("  SEND  %s\n", target)
+	}
+	for _, target := range present {
+		console.Printf("  SKIP  %s\n", target)
+	}
+	for _, target := range failed {
+		console.Printf("  FAIL  %s: %v\n", target, plan[target].err)
+	}
+	return plan
+}
+
+// sourceName returns the name imageName gets on the remote, without
+// checking the local runtime.
+func sourceName(ctx context.Context, imageName string) (string, error) {
+	if !strings.HasPrefix(imageName, ociPrefix) && !strings.HasPrefix(imageName, tarPrefix) {
+		return imageName, nil
+	}
+	_, name, err := openSource(ctx, imageName, "")
+	return name, err
+}
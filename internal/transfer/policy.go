@@ -0,0 +1,42 @@
+package transfer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// checkPolicy refuses imageName unless it comes from one of the allowed
+// registries or namespaces. Patterns are matched against the fully
+// qualified repository ("docker.io/library/nginx"), where '*' matches any
+// sequence including slashes, so "registry.corp/*" allows everything from
+// that registry. An empty list allows every image.
+func checkPolicy(imageName string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	ref := parseReference(imageName)
+	repo := ref.Registry + "/" + ref.Repository
+	for _, pattern := range allowed {
+		if matchRepository(pattern, repo) {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s (%s) is not from an allowed registry (allowed: %s)", imageName, repo, strings.Join(allowed, ", "))
+}
+
+func matchRepository(pattern, repo string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return false
+	}
+	// A bare registry or namespace allows everything below it
+	if !strings.Contains(pattern, "*") && strings.HasPrefix(repo, strings.TrimSuffix(pattern, "/")+"/") {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(repo)
+}
@@ -0,0 +1,159 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+const (
+	checkPass = ssh.CheckPass
+	checkWarn = ssh.CheckWarn
+	checkFail = ssh.CheckFail
+
+	// minFreeSpace is the free space below which the disk check warns.
+	minFreeSpace = 1 << 30
+)
+
+type checkResult struct {
+	name   string
+	status string
+	detail string
+	// fix suggests how to resolve a failed check.
+	fix string
+}
+
+// Preflight validates that remoteServer can receive images: SSH auth, host
+// key, remote runtime, sudo requirements, temp dir writability and disk space.
+// It prints a checklist and returns an error if any check failed.
+func Preflight(ctx context.Context, remoteServer string, opts Options) error {
+	var results []checkResult
+	report := func(name, status, format string, args ...any) {
+		results = append(results, checkResult{name: name, status: status, detail: fmt.Sprintf(format, args...)})
+	}
+	defer func() { printChecklist(results) }()
+
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		report("Target", checkFail, "%v", err)
+		return fmt.Errorf("preflight failed")
+	}
+	report("Target", checkPass, "%s", remote)
+
+	client, err := ssh.NewClient(remote.ctx, remote.user, remote.host, remote.sshOpts)
+	if err != nil {
+		var mismatch *ssh.HostKeyMismatchError
+		var unknown *ssh.HostKeyUnknownError
+		var unpinned *ssh.HostKeyPinError
+		if errors.As(err, &mismatch) {
+			report("Host key", checkFail, "key changed (%s), rerun with --update-host-key if expected", mismatch.Fingerprint())
+		} else if errors.As(err, &unpinned) {
+			report("Host key", checkFail, "offered %s, which is not pinned", unpinned.Fingerprint())
+		} else if errors.As(err, &unknown) {
+			if unknown.NoPrompt {
+				report("Host key", checkFail, "not in known_hosts and cannot be confirmed without a terminal (%s)", unknown.Fingerprint())
+			} else {
+				report("Host key", checkFail, "not in known_hosts and not accepted (%s)", unknown.Fingerprint())
+			}
+		} else {
+			report("SSH connection", checkFail, "%v", err)
+		}
+		return fmt.Errorf("preflight failed")
+	}
+	defer client.Close()
+	remote.client = client
+	report("SSH connection", checkPass, "authenticated as %s", client.User())
+	if len(remote.sshOpts.HostKeys) > 0 {
+		report("Host key", checkPass, "matches the pinned key")
+	} else if client.HostKeyKnown {
+		report("Host key", checkPass, "matches known_hosts")
+	} else {
+		report("Host key", checkWarn, "was not in known_hosts, accepted on first use")
+	}
+
+	rt, err := inspectRemoteRuntime(remote)
+	if err != nil {
+		report("Remote docker", checkFail, "%v", err)
+		if !remote.windows() && strings.Contains(err.Error(), "permission denied") {
+			if _, sudoErr := remote.run("sudo -n " + remote.docker("version")); sudoErr == nil {
+				report("Docker permissions", checkFail, "docker only works with sudo; add %s to the docker group", client.User())
+			}
+		}
+	} else {
+		report("Remote docker", checkPass, "%s (storage driver %s)", rt, valueOr(rt.Driver, "unknown"))
+		report("Docker permissions", checkPass, "no sudo required")
+	}
+
+	tmpDir, err := remote.tempDir()
+	if err != nil {
+		report("Temp directory", checkFail, "%v", err)
+	} else if err := checkRemoteWritable(remote, tmpDir); err != nil {
+		report("Temp directory", checkFail, "%s is not writable: %v", tmpDir, err)
+	} else {
+		report("Temp directory", checkPass, "%s is writable", tmpDir)
+		if free, err := remoteFreeSpace(remote, tmpDir); err != nil {
+			report("Disk space", checkWarn, "unable to determine free space in %s: %v", tmpDir, err)
+		} else if free < minFreeSpace {
+			report("Disk space", checkWarn, "only %.2f GB free in %s", float64(free)/(1<<30), tmpDir)
+		} else {
+			report("Disk space", checkPass, "%.2f GB free in %s", float64(free)/(1<<30), tmpDir)
+		}
+	}
+
+	for _, r := range results {
+		if r.status == checkFail {
+			return fmt.Errorf("preflight failed")
+		}
+	}
+	return nil
+}
+
+func printChecklist(results []checkResult) {
+	console.Println()
+	for _, r := range results {
+		console.Printf("[%s] %-20s %s\n", r.status, r.name, r.detail)
+		if r.fix != "" {
+			console.Printf("       %-20s fix: %s\n", "", r.fix)
+		}
+	}
+}
+
+func checkRemoteWritable(remote *remoteHost, dir string) error {
+	if remote.windows() {
+		_, err := remote.run(fmt.Sprintf("$f = Join-Path %s ('remote-pull-' + [guid]::NewGuid()); Set-Content -LiteralPath $f -Value ''; Remove-Item -LiteralPath $f", remote.quote(dir)))
+		return err
+	}
+	_, err := remote.run(fmt.Sprintf(`f=$(mktemp %s) && rm -f "$f"`, remote.quote(remote.join(dir, "remote-pull.XXXXXX"))))
+	return err
+}
+
+// remoteFreeSpace returns the bytes available in dir on the remote.
+func remoteFreeSpace(remote *remoteHost, dir string) (int64, error) {
+	if remote.windows() {
+		output, err := remote.run(fmt.Sprintf("(Get-Item -LiteralPath %s).PSDrive.Free", remote.quote(dir)))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	}
+	// POSIX output format: Filesystem 1024-blocks Used Available Capacity Mounted
+	output, err := remote.run(fmt.Sprintf("df -Pk %s", remote.quote(dir)))
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	kb, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	return kb * 1024, nil
+}
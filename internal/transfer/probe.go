@@ -0,0 +1,72 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// How hosts that do not answer the probe of a run to several hosts are
+// handled (see Options.Unreachable).
+const (
+	// UnreachableWait transfers to all hosts alike, waiting for slow or
+	// powered-off hosts to time out.
+	UnreachableWait = "wait"
+	// UnreachableSkip leaves unreachable hosts out of the run and reports
+	// them as failed.
+	UnreachableSkip = "skip"
+	// UnreachableDefer transfers to unreachable hosts after all others.
+	UnreachableDefer = "defer"
+)
+
+// UnreachableModes lists the valid values of Options.Unreachable.
+var UnreachableModes = []string{UnreachableWait, UnreachableSkip, UnreachableDefer}
+
+// probeTargets tries a single SSH connection to every target concurrently,
+// bounded by opts.ProbeTimeout up to the key exchange, and returns the
+// targets that could not be reached with the reason. Hosts that answer but
+// fail otherwise, e.g. to authenticate, count as reachable and fail in the
+// transfer itself.
+func probeTargets(ctx context.Context, targets []string, opts Options) map[string]error {
+	console.Printf("[PROBE] Checking that %d hosts are reachable (timeout %v)\n", len(targets), opts.ProbeTimeout)
+	unreachable := map[string]error{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, planConcurrency)
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			remote, err := resolveRemote(ctx, target, opts)
+			if err != nil {
+				return
+			}
+			sshOpts := remote.sshOpts
+			sshOpts.ConnectTimeout = opts.ProbeTimeout
+			sshOpts.ConnectionAttempts = 1
+			client, err := ssh.NewClient(ctx, remote.user, remote.host, sshOpts)
+			if err == nil {
+				client.Close()
+				return
+			}
+			if ssh.Unreachable(err) {
+				mu.Lock()
+				unreachable[target] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, target := range targets {
+		if err, ok := unreachable[target]; ok {
+			console.Printf("[UNREACHABLE] %s: %v\n", target, err)
+		}
+	}
+	return unreachable
+}
@@ -0,0 +1,82 @@
+package transfer
+
+import (
+	"strings"
+)
+
+const (
+	defaultRegistry  = "docker.io"
+	defaultNamespace = "library"
+	defaultTag       = "latest"
+)
+
+// imageRef is a parsed image reference such as
+// "registry:5000/team/app:tag" or "app@sha256:abcd...".
+type imageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseReference splits name into its components, filling in the same
+// defaults the docker CLI applies (docker.io, library/, latest).
+func parseReference(name string) imageRef {
+	var ref imageRef
+
+	remainder := name
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		ref.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+
+	// A tag follows the last colon, unless that colon belongs to a
+	// registry host:port (i.e. it is followed by a slash).
+	if i := strings.LastIndex(remainder, ":"); i >= 0 && !strings.Contains(remainder[i:], "/") {
+		ref.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+
+	// The first component is a registry if it looks like a host name.
+	if i := strings.Index(remainder, "/"); i >= 0 {
+		first := remainder[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry = first
+			remainder = remainder[i+1:]
+		}
+	}
+	if ref.Registry == "" {
+		ref.Registry = defaultRegistry
+	}
+	if ref.Registry == defaultRegistry && !strings.Contains(remainder, "/") {
+		remainder = defaultNamespace + "/" + remainder
+	}
+	ref.Repository = strings.ToLower(remainder)
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref
+}
+
+// String returns the fully qualified form of the reference.
+func (r imageRef) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// withTag returns the repository of the reference with tag.
+func (r imageRef) withTag(tag string) string {
+	return r.Registry + "/" + r.Repository + ":" + tag
+}
+
+// shortName returns the last repository path component, e.g. "app".
+func (r imageRef) shortName() string {
+	return r.Repository[strings.LastIndex(r.Repository, "/")+1:]
+}
@@ -0,0 +1,323 @@
+package transfer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+const (
+	OSLinux   = "linux"
+	OSDarwin  = "darwin"
+	OSWindows = "windows"
+)
+
+// remoteHost captures the shell and path conventions of the target machine.
+type remoteHost struct {
+	user    string
+	host    string
+	os      string
+	sshOpts ssh.Options
+	// ctx bounds all operations on the remote; it is done once the
+	// transfer is cancelled, times out or is interrupted.
+	ctx context.Context
+	// client, when set, is reused for all commands instead of dialing a
+	// new connection per command.
+	client *ssh.Client
+
+	// dockerCmd is the command line invoking docker on the remote, resolved
+	// on first use (see docker).
+	dockerOnce sync.Once
+	dockerCmd  string
+	// runtime is the runtime selected for the remote, which limits the
+	// probe to docker or replaces it with containerd's (see
+	// containerdCommand). namespace is the containerd namespace.
+	runtime   string
+	namespace string
+
+	// darwin is set for macOS hosts, which are detected on first use (see
+	// macOS) when not configured explicitly.
+	platformOnce sync.Once
+	darwin       bool
+
+	// strategy is the transfer strategy that worked for this host, reused
+	// for the rest of the run.
+	strategy string
+
+	// dockerConfig is the temporary docker configuration directory holding
+	// the credentials of --remote-login, if any (see loginRemote).
+	dockerConfig string
+
+	// agent is the path of the agent on the remote, installed on first use
+	// (see agentPath).
+	agentOnce sync.Once
+	agent     string
+
+	// artifacts lists files created on the remote that must be removed once
+	// the transfer is finished or has failed.
+	mu        sync.Mutex
+	artifacts []string
+}
+
+func newRemoteHost(ctx context.Context, user, host, remoteOS, dockerCmd, runtime, namespace string, sshOpts ssh.Options) (*remoteHost, error) {
+	switch remoteOS {
+	case "", OSLinux:
+		remoteOS = OSLinux
+	case OSDarwin, "macos":
+		remoteOS = OSDarwin
+	case OSWindows:
+	default:
+		return nil, fmt.Errorf("unsupported remote OS %q, expected %s, %s or %s", remoteOS, OSLinux, OSDarwin, OSWindows)
+	}
+	if dockerCmd == "" && runtime == RuntimePodman {
+		dockerCmd = RuntimePodman
+	}
+	if runtime == RuntimeContainerd && remoteOS == OSWindows {
+		return nil, fmt.Errorf("containerd is only supported on linux hosts")
+	}
+	return &remoteHost{user: user, host: host, os: remoteOS, dockerCmd: dockerCmd, runtime: runtime, namespace: valueOr(namespace, DefaultNamespace), sshOpts: sshOpts, ctx: ctx}, nil
+}
+
+func (r *remoteHost) String() string {
+	return ssh.Target{User: r.user, Host: r.host, Port: r.sshOpts.Port}.String()
+}
+
+func (r *remoteHost) windows() bool {
+	return r.os == OSWindows
+}
+
+// macOS reports whether the remote is a Mac, where docker runs in a VM and
+// temp files belong in the per-user temp directory.
+func (r *remoteHost) macOS() bool {
+	r.platformOnce.Do(func() {
+		switch r.os {
+		case OSDarwin:
+			r.darwin = true
+		case OSLinux:
+			output, err := r.run("uname -s")
+			r.darwin = err == nil && strings.TrimSpace(output) == "Darwin"
+		}
+	})
+	return r.darwin
+}
+
+// command wraps cmd so it is interpreted by the remote host's shell. Windows
+// commands are passed to PowerShell as an encoded command, which works
+// regardless of whether sshd's default shell is cmd.exe or PowerShell.
+func (r *remoteHost) command(cmd string) string {
+	if !r.windows() {
+		return cmd
+	}
+	encoded := utf16.Encode([]rune(cmd))
+	buf := make([]byte, 0, len(encoded)*2)
+	for _, c := range encoded {
+		buf = append(buf, byte(c), byte(c>>8))
+	}
+	return "powershell -NoProfile -NonInteractive -EncodedCommand " + base64.StdEncoding.EncodeToString(buf)
+}
+
+// quote quotes s as a single literal argument for the remote shell.
+func (r *remoteHost) quote(s string) string {
+	if r.windows() {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quotePath quotes a path passed directly on the sshd command line (outside
+// of PowerShell), such as the scp sink directory.
+func (r *remoteHost) quotePath(p string) string {
+	if r.windows() {
+		return `"` + p + `"`
+	}
+	return r.quote(p)
+}
+
+func (r *remoteHost) join(dir, name string) string {
+	if r.windows() {
+		return strings.TrimRight(dir, `\/`) + `\` + name
+	}
+	return path.Join(dir, name)
+}
+
+// tempDir returns the directory used for staging archives on the remote.
+// On macOS this is the per-user temp directory, as TMPDIR is usually not set
+// in SSH sessions and /tmp is shared by all users.
+func (r *remoteHost) tempDir() (string, error) {
+	if r.macOS() {
+		output, err := r.run("getconf DARWIN_USER_TEMP_DIR")
+		if dir := strings.TrimRight(strings.TrimSpace(output), "/"); err == nil && dir != "" {
+			return dir, nil
+		}
+		return "/tmp", nil
+	}
+	if !r.windows() {
+		return "/tmp", nil
+	}
+	output, err := r.run("$env:TEMP")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve remote temp directory: %v", err)
+	}
+	dir := strings.TrimSpace(output)
+	if dir == "" {
+		return "", fmt.Errorf("remote TEMP environment variable is empty")
+	}
+	return dir, nil
+}
+
+func (r *remoteHost) run(cmd string) (string, error) {
+	return r.runContext(r.ctx, cmd)
+}
+
+// runContext is like run but bounded by ctx instead of the context of the
+// remote.
+func (r *remoteHost) runContext(ctx context.Context, cmd string) (string, error) {
+	if r.client != nil {
+		return r.client.Run(r.command(cmd))
+	}
+	return ssh.RunCommand(ctx, r.command(cmd), r.user, r.host, r.sshOpts)
+}
+
+// runInput is like run but passes stdin to the command.
+func (r *remoteHost) runInput(cmd string, stdin io.Reader) (string, error) {
+	client := r.client
+	if client == nil {
+		var err error
+		if client, err = ssh.NewClient(r.ctx, r.user, r.host, r.sshOpts); err != nil {
+			return "", err
+		}
+		defer client.Close()
+	}
+	return client.RunInput(r.command(cmd), stdin)
+}
+
+// dockerProbe finds a working docker on hosts where the runtime lives in a
+// VM (Docker Desktop, colima, lima, Rancher Desktop, podman machine): the CLI
+// is often missing from the PATH of non-interactive sessions and the daemon
+// is only reachable through the VM's forwarded socket or a docker context
+// other than the current one. Without a working docker, podman is used if
+// $P is set. It prints the operating system on the first line and the
+// command line to use on the second.
+const dockerProbe = `uname -s
+D=
+for d in docker /usr/local/bin/docker /opt/homebrew/bin/docker "$HOME/.docker/bin/docker" /Applications/Docker.app/Contents/Resources/bin/docker; do
+  if command -v "$d" >/dev/null 2>&1; then D=$(command -v "$d"); break; fi
+done
+[ -n "$D" ] || { [ -n "$P" ] && command -v podman >/dev/null 2>&1 && echo podman || echo docker; exit 0; }
+if "$D" version >/dev/null 2>&1; then echo "$D"; exit 0; fi
+for s in "$HOME/.colima/default/docker.sock" "$HOME/.colima/docker.sock" "$HOME"/.colima/*/docker.sock "$HOME"/.lima/*/sock/docker.sock "$HOME/.docker/run/docker.sock" "$HOME/.rd/docker.sock" $(podman machine inspect --format '{{.ConnectionInfo.PodmanSocket.Path}}' 2>/dev/null); do
+  if [ -S "$s" ] && DOCKER_HOST="unix://$s" "$D" version >/dev/null 2>&1; then echo "DOCKER_HOST=unix://$s $D"; exit 0; fi
+done
+for c in $("$D" context ls --format '{{.Name}}' 2>/dev/null); do
+  if "$D" --context "$c" version >/dev/null 2>&1; then echo "$D --context $c"; exit 0; fi
+done
+[ -n "$P" ] && command -v podman >/dev/null 2>&1 && { echo podman; exit 0; }
+echo "$D"`
+
+// docker returns the docker command line with args appended. Unless it was
+// configured explicitly, the invocation is probed once per host so that
+// runtimes inside a VM on the remote, or podman, are found.
+func (r *remoteHost) docker(args string) string {
+	r.dockerOnce.Do(func() {
+		if r.runtime == RuntimeContainerd {
+			r.dockerCmd = r.containerdCommand()
+			return
+		}
+		if r.dockerCmd != "" {
+			return
+		}
+		r.dockerCmd = "docker"
+		if r.windows() {
+			return
+		}
+		probe := dockerProbe
+		if r.runtime == "" {
+			probe = "P=1\n" + probe
+		}
+		output, err := r.run(probe)
+		if err != nil {
+			return
+		}
+		system, cmd, _ := strings.Cut(strings.TrimSpace(output), "\n")
+		r.platformOnce.Do(func() {
+			r.darwin = r.os == OSDarwin || strings.TrimSpace(system) == "Darwin"
+		})
+		if cmd = strings.TrimSpace(cmd); cmd != "" && cmd != "docker" {
+			console.Printf("[RUNTIME] Using %s on %s\n", cmd, r)
+			r.dockerCmd = cmd
+		}
+	})
+	switch {
+	case r.dockerConfig == "":
+	case r.windows():
+		return r.dockerCmd + " --config " + r.quote(r.dockerConfig) + " " + args
+	default:
+		// podman reads its credentials from REGISTRY_AUTH_FILE
+		return fmt.Sprintf("DOCKER_CONFIG=%s REGISTRY_AUTH_FILE=%s %s %s", r.quote(r.dockerConfig), r.quote(r.join(r.dockerConfig, "config.json")), r.dockerCmd, args)
+	}
+	return r.dockerCmd + " " + args
+}
+
+// track records a remote file for later removal.
+func (r *remoteHost) track(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.artifacts = append(r.artifacts, p)
+}
+
+// untrack forgets a remote file that no longer needs to be removed.
+func (r *remoteHost) untrack(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, a := range r.artifacts {
+		if a == p {
+			r.artifacts = append(r.artifacts[:i], r.artifacts[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeArtifacts deletes all tracked remote files, including partially
+// transferred ones. Failures are reported but not returned so they never mask
+// the original transfer error. The files are removed even if the transfer
+// was cancelled, within cleanupTimeout.
+func (r *remoteHost) removeArtifacts() {
+	r.mu.Lock()
+	artifacts := r.artifacts
+	r.artifacts = nil
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.ctx), cleanupTimeout)
+	defer cancel()
+
+	for _, p := range artifacts {
+		console.Printf("[CLEANUP] Removing remote archive %s\n", p)
+		cmd := fmt.Sprintf("rm -f %s", r.quote(p))
+		if r.windows() {
+			cmd = fmt.Sprintf("Remove-Item -Force -ErrorAction SilentlyContinue -LiteralPath %s", r.quote(p))
+		}
+		if _, err := r.runContext(ctx, cmd); err != nil {
+			console.Printf("[WARNING] Failed to remove remote archive %s: %v\n", p, err)
+		}
+	}
+}
+
+// keepArtifacts forgets the tracked remote files without removing them.
+func (r *remoteHost) keepArtifacts() {
+	r.mu.Lock()
+	artifacts := r.artifacts
+	r.artifacts = nil
+	r.mu.Unlock()
+
+	for _, p := range artifacts {
+		console.Printf("[KEEPING] Remote archive left at %s\n", p)
+	}
+}
@@ -0,0 +1,425 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// restartSuffix renames a container while its replacement is started, so
+// it can be brought back if the replacement fails.
+const restartSuffix = "-remote-pull-old"
+
+// runningContainer is the subset of `docker container inspect` needed to
+// recreate a container with another image.
+type runningContainer struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"`
+	Config struct {
+		Hostname   string            `json:"Hostname"`
+		User       string            `json:"User"`
+		WorkingDir string            `json:"WorkingDir"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		Labels     map[string]string `json:"Labels"`
+		Tty        bool              `json:"Tty"`
+		OpenStdin  bool              `json:"OpenStdin"`
+		StopSignal string            `json:"StopSignal"`
+	} `json:"Config"`
+	HostConfig struct {
+		Binds  []string `json:"Binds"`
+		Mounts []struct {
+			Type     string `json:"Type"`
+			Source   string `json:"Source"`
+			Target   string `json:"Target"`
+			ReadOnly bool   `json:"ReadOnly"`
+		} `json:"Mounts"`
+		PortBindings map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"PortBindings"`
+		PublishAllPorts bool `json:"PublishAllPorts"`
+		RestartPolicy   struct {
+			Name              string `json:"Name"`
+			MaximumRetryCount int    `json:"MaximumRetryCount"`
+		} `json:"RestartPolicy"`
+		NetworkMode    string   `json:"NetworkMode"`
+		ExtraHosts     []string `json:"ExtraHosts"`
+		DNS            []string `json:"Dns"`
+		Privileged     bool     `json:"Privileged"`
+		ReadonlyRootfs bool     `json:"ReadonlyRootfs"`
+		Init           *bool    `json:"Init"`
+		CapAdd         []string `json:"CapAdd"`
+		CapDrop        []string `json:"CapDrop"`
+		SecurityOpt    []string `json:"SecurityOpt"`
+		Devices        []struct {
+			PathOnHost        string `json:"PathOnHost"`
+			PathInContainer   string `json:"PathInContainer"`
+			CgroupPermissions string `json:"CgroupPermissions"`
+		} `json:"Devices"`
+		Tmpfs     map[string]string `json:"Tmpfs"`
+		LogConfig struct {
+			Type   string            `json:"Type"`
+			Config map[string]string `json:"Config"`
+		} `json:"LogConfig"`
+		Memory      int64    `json:"Memory"`
+		NanoCPUs    int64    `json:"NanoCpus"`
+		VolumesFrom []string `json:"VolumesFrom"`
+		AutoRemove  bool     `json:"AutoRemove"`
+	} `json:"HostConfig"`
+	Mounts []struct {
+		Type        string `json:"Type"`
+		Name        string `json:"Name"`
+		Destination string `json:"Destination"`
+	} `json:"Mounts"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			Aliases []string `json:"Aliases"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// imageDefaults is the subset of `docker image inspect` a container
+// inherits, to tell the settings given to docker run from the image's.
+type imageDefaults struct {
+	Config struct {
+		User       string            `json:"User"`
+		WorkingDir string            `json:"WorkingDir"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		Labels     map[string]string `json:"Labels"`
+		StopSignal string            `json:"StopSignal"`
+	} `json:"Config"`
+}
+
+func (c *runningContainer) name() string {
+	return strings.TrimPrefix(c.Name, "/")
+}
+
+// managedBy returns the orchestrator managing the container, which would
+// recreate it on its own terms, or "" for a container started with docker
+// run.
+func (c *runningContainer) managedBy() string {
+	switch {
+	case c.Config.Labels["com.docker.compose.project"] != "":
+		return "docker compose"
+	case c.Config.Labels["com.docker.swarm.task.id"] != "":
+		return "swarm"
+	}
+	return ""
+}
+
+// createArgs returns the docker create arguments recreating the container
+// from image. Settings the container inherited from its previous image, old,
+// are left to the new image; what was given to docker run is kept: the
+// environment, labels, command, volumes (including anonymous ones), ports,
+// networks, restart policy, logging, privileges, devices and limits.
+func (c *runningContainer) createArgs(image string, old imageDefaults, quote func(string) string) []string {
+	args := []string{"--name", quote(c.name())}
+	add := func(flag string, values ...string) {
+		for _, value := range values {
+			args = append(args, flag, quote(value))
+		}
+	}
+
+	networkMode := c.HostConfig.NetworkMode
+	ownNetwork := !strings.HasPrefix(networkMode, "container:") && networkMode != "host"
+	// The default hostname is the short container ID
+	if c.Config.Hostname != "" && ownNetwork && !strings.HasPrefix(c.ID, c.Config.Hostname) {
+		add("--hostname", c.Config.Hostname)
+	}
+	if c.Config.User != old.Config.User {
+		add("--user", c.Config.User)
+	}
+	if c.Config.WorkingDir != old.Config.WorkingDir {
+		add("--workdir", c.Config.WorkingDir)
+	}
+	for _, env := range c.Config.Env {
+		if !slices.Contains(old.Config.Env, env) {
+			add("--env", env)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Config.Labels)) {
+		if value, ok := old.Config.Labels[key]; !ok || value != c.Config.Labels[key] {
+			add("--label", key+"="+c.Config.Labels[key])
+		}
+	}
+	if c.Config.Tty {
+		args = append(args, "--tty")
+	}
+	if c.Config.OpenStdin {
+		args = append(args, "--interactive")
+	}
+	if c.Config.StopSignal != "" && c.Config.StopSignal != old.Config.StopSignal {
+		add("--stop-signal", c.Config.StopSignal)
+	}
+
+	add("--volume", c.HostConfig.Binds...)
+	named := map[string]bool{}
+	for _, bind := range c.HostConfig.Binds {
+		source, _, _ := strings.Cut(bind, ":")
+		named[source] = true
+	}
+	for _, m := range c.HostConfig.Mounts {
+		named[m.Source] = true
+		mount := "type=" + m.Type + ",target=" + m.Target
+		if m.Source != "" {
+			mount += ",source=" + m.Source
+		}
+		if m.ReadOnly {
+			mount += ",readonly"
+		}
+		add("--mount", mount)
+	}
+	// Anonymous volumes of the image are carried over, a new container
+	// would otherwise start with empty ones
+	for _, m := range c.Mounts {
+		if m.Type == "volume" && m.Name != "" && !named[m.Name] {
+			add("--volume", m.Name+":"+m.Destination)
+		}
+	}
+	add("--volumes-from", c.HostConfig.VolumesFrom...)
+	for _, tmpfs := range slices.Sorted(maps.Keys(c.HostConfig.Tmpfs)) {
+		if options := c.HostConfig.Tmpfs[tmpfs]; options != "" {
+			tmpfs += ":" + options
+		}
+		add("--tmpfs", tmpfs)
+	}
+
+	for _, port := range slices.Sorted(maps.Keys(c.HostConfig.PortBindings)) {
+		for _, binding := range c.HostConfig.PortBindings[port] {
+			publish := port
+			if binding.HostIP != "" || binding.HostPort != "" {
+				publish = binding.HostPort + ":" + port
+				if strings.Contains(binding.HostIP, ":") {
+					publish = "[" + binding.HostIP + "]:" + publish
+				} else if binding.HostIP != "" {
+					publish = binding.HostIP + ":" + publish
+				}
+			}
+			add("--publish", publish)
+		}
+	}
+	if c.HostConfig.PublishAllPorts {
+		args = append(args, "--publish-all")
+	}
+	if networkMode != "" && networkMode != "default" {
+		add("--network", networkMode)
+		if network, ok := c.NetworkSettings.Networks[networkMode]; ok {
+			add("--network-alias", c.aliases(network.Aliases)...)
+		}
+	}
+	add("--add-host", c.HostConfig.ExtraHosts...)
+	add("--dns", c.HostConfig.DNS...)
+
+	if policy := c.HostConfig.RestartPolicy; policy.Name != "" && policy.Name != "no" {
+		if policy.MaximumRetryCount > 0 {
+			policy.Name += ":" + strconv.Itoa(policy.MaximumRetryCount)
+		}
+		add("--restart", policy.Name)
+	}
+	if c.HostConfig.AutoRemove {
+		args = append(args, "--rm")
+	}
+	if c.HostConfig.LogConfig.Type != "" {
+		add("--log-driver", c.HostConfig.LogConfig.Type)
+		for _, key := range slices.Sorted(maps.Keys(c.HostConfig.LogConfig.Config)) {
+			add("--log-opt", key+"="+c.HostConfig.LogConfig.Config[key])
+		}
+	}
+	if c.HostConfig.Privileged {
+		args = append(args, "--privileged")
+	}
+	if c.HostConfig.ReadonlyRootfs {
+		args = append(args, "--read-only")
+	}
+	if c.HostConfig.Init != nil && *c.HostConfig.Init {
+		args = append(args, "--init")
+	}
+	add("--cap-add", c.HostConfig.CapAdd...)
+	add("--cap-drop", c.HostConfig.CapDrop...)
+	add("--security-opt", c.HostConfig.SecurityOpt...)
+	for _, device := range c.HostConfig.Devices {
+		add("--device", device.PathOnHost+":"+device.PathInContainer+":"+device.CgroupPermissions)
+	}
+	if c.HostConfig.Memory > 0 {
+		add("--memory", strconv.FormatInt(c.HostConfig.Memory, 10))
+	}
+	if c.HostConfig.NanoCPUs > 0 {
+		add("--cpus", strconv.FormatFloat(float64(c.HostConfig.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+
+	// docker run takes a single entrypoint word, the rest of an entrypoint
+	// given in exec form moves in front of the command
+	cmd := c.Config.Cmd
+	if !slices.Equal(c.Config.Entrypoint, old.Config.Entrypoint) {
+		entrypoint := ""
+		if len(c.Config.Entrypoint) > 0 {
+			entrypoint = c.Config.Entrypoint[0]
+			cmd = append(slices.Clone(c.Config.Entrypoint[1:]), cmd...)
+		}
+		add("--entrypoint", entrypoint)
+	} else if slices.Equal(cmd, old.Config.Cmd) {
+		cmd = nil
+	}
+	args = append(args, quote(image))
+	for _, arg := range cmd {
+		args = append(args, quote(arg))
+	}
+	return args
+}
+
+// aliases drops the short container ID docker adds to the aliases itself.
+func (c *runningContainer) aliases(aliases []string) []string {
+	var own []string
+	for _, alias := range aliases {
+		if !strings.HasPrefix(c.ID, alias) && alias != c.name() {
+			own = append(own, alias)
+		}
+	}
+	return own
+}
+
+// previousVersions returns the IDs of the images of imageName's repository
+// on the remote, whose containers are recreated after the transfer.
+func previousVersions(imageName string, remote *remoteHost) ([]string, error) {
+	tags, err := remoteTags(parseReference(imageName), remote)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, id := range tags {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// restartContainers recreates the running containers on the remote that
+// were started from one of the previous images (or an image built on one)
+// with imageName, which is imageID now. Containers are replaced one at a
+// time; the first that fails is restored and ends the update.
+func restartContainers(imageName, imageID string, previous []string, remote *remoteHost) error {
+	var ids []string
+	for _, old := range previous {
+		if old == imageID {
+			continue
+		}
+		output, err := remote.run(remote.docker("ps --no-trunc --quiet --filter " + remote.quote("ancestor="+old)))
+		if err != nil {
+			return fmt.Errorf("failed to list containers of %s on %s: %v", old, remote.host, err)
+		}
+		for _, id := range strings.Fields(output) {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		console.Printf("[RESTART] No containers of previous versions of %s are running on %s\n", imageName, remote.host)
+		return nil
+	}
+
+	for _, id := range ids {
+		var containers []runningContainer
+		if err := inspectRemote(remote, "container inspect "+id, &containers); err != nil {
+			return err
+		}
+		if len(containers) == 0 {
+			continue
+		}
+		c := containers[0]
+		if c.Image == imageID {
+			continue
+		}
+		if manager := c.managedBy(); manager != "" {
+			console.Printf("[WARNING] Not recreating container %s on %s, it is managed by %s\n", c.name(), remote.host, manager)
+			continue
+		}
+		var images []imageDefaults
+		if err := inspectRemote(remote, "image inspect "+c.Image, &images); err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return fmt.Errorf("image %s of container %s not found on %s", c.Image, c.name(), remote.host)
+		}
+		if err := recreateContainer(c, imageName, images[0], remote); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inspectRemote decodes the output of a docker inspect command on the
+// remote into v.
+func inspectRemote(remote *remoteHost, args string, v any) error {
+	output, err := remote.run(remote.docker(args))
+	if err != nil {
+		return fmt.Errorf("docker %s failed on %s: %v", args, remote.host, err)
+	}
+	if err := json.Unmarshal([]byte(output), v); err != nil {
+		return fmt.Errorf("unexpected output from remote docker %s: %v", args, err)
+	}
+	return nil
+}
+
+// recreateContainer replaces c with a container of image with the same
+// settings. The old container is stopped and kept under another name until
+// its replacement has started, and brought back if that fails (except when
+// it was started with --rm, which removes it once stopped).
+func recreateContainer(c runningContainer, image string, old imageDefaults, remote *remoteHost) error {
+	name := c.name()
+	backup := name + restartSuffix
+	console.Printf("[RESTART] Recreating container %s on %s with %s\n", name, remote.host, image)
+	if _, err := remote.run(remote.docker("rename " + remote.quote(name) + " " + remote.quote(backup))); err != nil {
+		return fmt.Errorf("failed to rename container %s on %s: %v", name, remote.host, err)
+	}
+	restore := func(cause error) error {
+		console.Printf("[RESTART] Restoring container %s on %s\n", name, remote.host)
+		remote.run(remote.docker("rm --force " + remote.quote(name)))
+		if _, err := remote.run(remote.docker("rename " + remote.quote(backup) + " " + remote.quote(name))); err != nil {
+			console.Printf("[WARNING] Failed to rename %s back to %s: %v\n", backup, name, err)
+		}
+		if _, err := remote.run(remote.docker("start " + remote.quote(name))); err != nil {
+			console.Printf("[WARNING] Failed to start container %s again: %v\n", name, err)
+		}
+		return fmt.Errorf("failed to recreate container %s on %s: %v", name, remote.host, cause)
+	}
+	if _, err := remote.run(remote.docker("stop " + remote.quote(backup))); err != nil {
+		return restore(err)
+	}
+	if _, err := remote.run(remote.docker("create " + strings.Join(c.createArgs(image, old, remote.quote), " "))); err != nil {
+		return restore(err)
+	}
+	// Networks beyond the first are connected before the start, as docker
+	// create joins only one
+	for _, network := range slices.Sorted(maps.Keys(c.NetworkSettings.Networks)) {
+		if network == c.HostConfig.NetworkMode || (c.HostConfig.NetworkMode == "default" && network == "bridge") {
+			continue
+		}
+		connect := "network connect"
+		for _, alias := range c.aliases(c.NetworkSettings.Networks[network].Aliases) {
+			connect += " --alias " + remote.quote(alias)
+		}
+		if _, err := remote.run(remote.docker(connect + " " + remote.quote(network) + " " + remote.quote(name))); err != nil {
+			return restore(err)
+		}
+	}
+	if _, err := remote.run(remote.docker("start " + remote.quote(name))); err != nil {
+		return restore(err)
+	}
+	if _, err := remote.run(remote.docker("rm " + remote.quote(backup))); err != nil {
+		console.Printf("[WARNING] Failed to remove the previous container %s on %s: %v\n", backup, remote.host, err)
+	}
+	console.Printf("[RESTART] Container %s is running %s on %s\n", name, image, remote.host)
+	return nil
+}
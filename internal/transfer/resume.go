@@ -0,0 +1,154 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// resumeChunk is the unit in which a partial archive on the remote is
+// verified; an interrupted upload continues after the last complete chunk
+// that matches the local archive.
+const resumeChunk = 64 << 20
+
+// resumeManifest lists the SHA-256 of every resumeChunk bytes of an
+// archive. It is kept next to the partial archive on the remote, as
+// "<archive>.manifest".
+type resumeManifest struct {
+	size   int64
+	chunks []string
+}
+
+// archiveManifest computes the manifest of the local file path.
+func archiveManifest(path string) (*resumeManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &resumeManifest{}
+	h := sha256.New()
+	for {
+		h.Reset()
+		n, err := io.CopyN(h, f, resumeChunk)
+		if n > 0 {
+			m.size += n
+			m.chunks = append(m.chunks, hex.EncodeToString(h.Sum(nil)))
+		}
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (m *resumeManifest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sha256 %d %d\n", m.size, resumeChunk)
+	for _, chunk := range m.chunks {
+		b.WriteString(chunk + "\n")
+	}
+	return b.String()
+}
+
+// parseManifest is the inverse of String. Manifests written with another
+// chunk size are not usable and reported as missing.
+func parseManifest(s string) (*resumeManifest, bool) {
+	lines := strings.Fields(s)
+	if len(lines) < 3 || lines[0] != "sha256" || lines[2] != strconv.Itoa(resumeChunk) {
+		return nil, false
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &resumeManifest{size: size, chunks: lines[3:]}, true
+}
+
+// chunkEnd returns the end offset of chunk i.
+func (m *resumeManifest) chunkEnd(i int) int64 {
+	return min(int64(i+1)*resumeChunk, m.size)
+}
+
+// resumeUpload prepares remoteFile on the remote for an upload of archive
+// that survives interruptions, and returns the offset to continue from. A
+// partial file left by an earlier attempt is only continued after the
+// chunks its manifest shares with archive have been verified on the remote;
+// the manifest of archive is then stored before any data is sent.
+func resumeUpload(archive *resumeManifest, remoteFile string, remote *remoteHost) (int64, error) {
+	manifestFile := remoteFile + ".manifest"
+	offset := int64(0)
+	if output, err := remote.run("cat " + remote.quote(manifestFile)); err == nil {
+		if previous, ok := parseManifest(output); ok {
+			offset = verifiedOffset(archive, previous, remoteFile, remote)
+		}
+	}
+
+	// Cut off what is not verified, then record what is about to be sent
+	cmd := "cat > " + remote.quote(manifestFile)
+	if offset > 0 {
+		cmd = fmt.Sprintf("dd if=/dev/null of=%s bs=1 seek=%d 2>/dev/null && %s", remote.quote(remoteFile), offset, cmd)
+	}
+	if _, err := remote.runInput(cmd, strings.NewReader(archive.String())); err != nil {
+		return 0, fmt.Errorf("failed to prepare resumable upload on %s: %v", remote.host, err)
+	}
+	switch {
+	case offset == archive.size:
+		console.Printf("[RESUME] %s is complete on %s from an earlier attempt, nothing to send\n", remoteFile, remote.host)
+	case offset > 0:
+		console.Printf("[RESUME] Continuing upload to %s:%s at %.2f of %.2f MB\n", remote.host, remoteFile, float64(offset)/1024/1024, float64(archive.size)/1024/1024)
+	}
+	return offset, nil
+}
+
+// verifiedOffset returns how much of the partial remoteFile matches archive:
+// the chunks the previous manifest shares with archive and that the remote
+// holds in full are checksummed on the remote, up to the first mismatch.
+func verifiedOffset(archive, previous *resumeManifest, remoteFile string, remote *remoteHost) int64 {
+	output, err := remote.run("wc -c < " + remote.quote(remoteFile))
+	if err != nil {
+		return 0
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return 0
+	}
+	candidates := 0
+	for i := range archive.chunks {
+		if i >= len(previous.chunks) || previous.chunks[i] != archive.chunks[i] || archive.chunkEnd(i) > size {
+			break
+		}
+		candidates++
+	}
+	if candidates == 0 {
+		return 0
+	}
+
+	script := fmt.Sprintf(`if command -v sha256sum >/dev/null 2>&1; then s=sha256sum; elif command -v shasum >/dev/null 2>&1; then s="shasum -a 256"; else echo "neither sha256sum nor shasum found" >&2; exit 3; fi
+i=0; while [ $i -lt %d ]; do dd if=%s bs=1048576 skip=$((i*%d)) count=%d 2>/dev/null | $s; i=$((i+1)); done`,
+		candidates, remote.quote(remoteFile), resumeChunk>>20, resumeChunk>>20)
+	output, err = remote.run(script)
+	if err != nil {
+		console.Printf("[WARNING] Unable to verify the partial archive on %s, starting over: %v\n", remote.host, err)
+		return 0
+	}
+	verified := 0
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if verified == candidates || len(fields) == 0 || fields[0] != archive.chunks[verified] {
+			break
+		}
+		verified++
+	}
+	if verified == 0 {
+		return 0
+	}
+	return archive.chunkEnd(verified - 1)
+}
@@ -0,0 +1,161 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+const (
+	// activeTag marks the version of an image that is in use on a host.
+	activeTag = "current"
+	// previousTagPrefix numbers the retained versions: prev1 is the one
+	// active before current, prev2 the one before that, and so on.
+	previousTagPrefix = "prev"
+)
+
+// previousTag returns the tag of the nth retained version, where the active
+// version counts as 0.
+func previousTag(n int) string {
+	if n == 0 {
+		return activeTag
+	}
+	return previousTagPrefix + strconv.Itoa(n)
+}
+
+// versionNumber is the inverse of previousTag; ok is false for tags not
+// managed by retention.
+func versionNumber(tag string) (int, bool) {
+	if tag == activeTag {
+		return 0, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(tag, previousTagPrefix))
+	return n, err == nil && n > 0 && strings.HasPrefix(tag, previousTagPrefix)
+}
+
+// remoteTags returns the IDs of the tagged versions of ref's repository on
+// the remote, by tag.
+func remoteTags(ref imageRef, remote *remoteHost) (map[string]string, error) {
+	repo := ref.Registry + "/" + ref.Repository
+	cmd := remote.docker(fmt.Sprintf("images --no-trunc --format %s %s", remote.quote("{{json .}}"), remote.quote(repo)))
+	output, err := remote.run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %s: %v", repo, err)
+	}
+	images, err := decodeJSONLines[imageSummary](output)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected output from remote docker images: %v", err)
+	}
+	tags := map[string]string{}
+	for _, image := range images {
+		tags[image.Tag] = image.ID
+	}
+	return tags, nil
+}
+
+// activate tags imageID as the active version of its repository on the
+// remote. The version it replaces is kept as prev1 and older ones move up,
+// up to opts.RetainVersions; versions beyond that are removed.
+func activate(imageName, imageID string, remote *remoteHost, opts Options) error {
+	if !opts.Retain || imageID == "" {
+		return nil
+	}
+	ref := parseReference(imageName)
+	tags, err := remoteTags(ref, remote)
+	if err != nil {
+		return err
+	}
+	if tags[activeTag] == imageID {
+		console.Printf("[RETAIN] %s is already the active version on %s\n", imageName, remote.host)
+		return nil
+	}
+
+	// Drop the versions that fall out of the retention window: all beyond
+	// it, and the last one if another version moves into its place. Removing
+	// the tag deletes the image unless it is still tagged or in use.
+	keep := opts.RetainVersions
+	for tag := range tags {
+		n, ok := versionNumber(tag)
+		if !ok || n < keep || (n == keep && n > 0 && tags[previousTag(n-1)] == "") {
+			continue
+		}
+		console.Printf("[RETAIN] Pruning %s on %s\n", ref.withTag(tag), remote.host)
+		if _, err := remote.run(remote.docker("rmi " + remote.quote(ref.withTag(tag)))); err != nil {
+			console.Printf("[WARNING] Failed to prune %s: %v\n", ref.withTag(tag), err)
+		}
+	}
+
+	// Rotate the retained versions, oldest first so none is overwritten
+	tag := func(id, tag string) error {
+		if _, err := remote.run(remote.docker("tag " + id + " " + remote.quote(ref.withTag(tag)))); err != nil {
+			return fmt.Errorf("failed to tag %s as %s: %v", id, ref.withTag(tag), err)
+		}
+		return nil
+	}
+	for n := keep - 1; n >= 0; n-- {
+		if id := tags[previousTag(n)]; id != "" {
+			if err := tag(id, previousTag(n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tag(imageID, activeTag); err != nil {
+		return err
+	}
+	console.Printf("[RETAIN] %s is now %s on %s\n", imageName, ref.withTag(activeTag), remote.host)
+	return nil
+}
+
+// Rollback makes a retained version of image the active one on remoteServer
+// again: prev<steps>, by default the version active before the current one.
+// The tags are swapped, so the version rolled back from takes the place of
+// the reactivated one and a second rollback undoes the first.
+func Rollback(ctx context.Context, remoteServer, image string, steps int, opts Options) error {
+	if steps < 1 {
+		return fmt.Errorf("rollback steps must be at least 1")
+	}
+	postLoad, err := parsePostLoad(opts.PostLoad)
+	if err != nil {
+		return err
+	}
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	ref := parseReference(image)
+	tags, err := remoteTags(ref, remote)
+	if err != nil {
+		return err
+	}
+	current, previous := ref.withTag(activeTag), ref.withTag(previousTag(steps))
+	previousID, currentID := tags[previousTag(steps)], tags[activeTag]
+	if previousID == "" {
+		return fmt.Errorf("no version %s of %s is retained on %s", previousTag(steps), image, remote.host)
+	}
+
+	console.Printf("[ROLLBACK] Activating %s (%s) on %s\n", previous, previousID, remote.host)
+	if _, err := remote.run(remote.docker("tag " + previousID + " " + remote.quote(current))); err != nil {
+		return fmt.Errorf("failed to tag %s as %s: %v", previous, current, err)
+	}
+	if currentID != "" {
+		if _, err := remote.run(remote.docker("tag " + currentID + " " + remote.quote(previous))); err != nil {
+			return fmt.Errorf("failed to retain %s as %s: %v", currentID, previous, err)
+		}
+	}
+
+	if postLoad != nil {
+		if err := runPostLoad(postLoad, opts.PostLoad, current, remote, nil, previousID); err != nil {
+			return err
+		}
+	}
+	if opts.HealthCheck.Command != "" {
+		if err := waitHealthy(remote, opts.HealthCheck); err != nil {
+			return err
+		}
+	}
+	console.Printf("[SUCCESS] Rolled back %s on %s\n", image, remote.host)
+	return nil
+}
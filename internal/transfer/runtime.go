@@ -0,0 +1,88 @@
+package transfer
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// Container runtimes selected with Options.Runtime and RemoteRuntime.
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+)
+
+// Runtimes lists the valid values of Options.Runtime.
+var Runtimes = []string{RuntimeDocker, RuntimePodman}
+
+// RemoteRuntimes lists the valid values of Options.RemoteRuntime.
+var RemoteRuntimes = []string{RuntimeDocker, RuntimePodman, RuntimeContainerd}
+
+// DefaultNamespace is the containerd namespace of Kubernetes, whose images
+// the kubelet sees.
+const DefaultNamespace = "k8s.io"
+
+// containerdProbe finds the CLI of containerd. On k3s, its embedded ctr knows
+// the location of the k3s containerd socket, which nerdctl does not.
+const containerdProbe = `if command -v k3s >/dev/null 2>&1; then echo "$(command -v k3s) ctr"; exit 0; fi
+for c in nerdctl ctr; do
+  if command -v "$c" >/dev/null 2>&1; then command -v "$c"; exit 0; fi
+done`
+
+// normalizeImageID adds the digest algorithm podman leaves out of image IDs,
+// so they compare equal to docker's.
+func normalizeImageID(id string) string {
+	if id == "" || strings.Contains(id, ":") {
+		return id
+	}
+	return "sha256:" + id
+}
+
+// containerdCommand returns the nerdctl or ctr command line for the
+// containerd namespace of the remote, probing for the CLI unless it was
+// configured.
+func (r *remoteHost) containerdCommand() string {
+	cmd := r.dockerCmd
+	if cmd == "" {
+		output, err := r.run(containerdProbe)
+		if cmd = strings.TrimSpace(output); err != nil || cmd == "" {
+			cmd = "ctr"
+		}
+		console.Printf("[RUNTIME] Using %s on %s\n", cmd, r)
+	}
+	return cmd + " --namespace " + r.quote(r.namespace)
+}
+
+// ctr reports whether the remote runtime is driven by containerd's ctr,
+// which has its own commands instead of docker's.
+func (r *remoteHost) ctr() bool {
+	return slices.ContainsFunc(strings.Fields(r.docker("")), func(arg string) bool {
+		return path.Base(arg) == "ctr"
+	})
+}
+
+// loadCommand returns the command loading the archive file into the remote
+// runtime, or the archive on stdin when file is empty.
+func (r *remoteHost) loadCommand(file string) string {
+	if r.ctr() {
+		if file == "" {
+			return r.docker("images import -")
+		}
+		return r.docker("images import " + r.quote(file))
+	}
+	if file == "" {
+		return r.docker("load")
+	}
+	return r.docker("load -i " + r.quote(file))
+}
+
+// podman reports whether the remote runtime is driven by the podman CLI,
+// whose output formats differ from docker's in places.
+func (r *remoteHost) podman() bool {
+	return slices.ContainsFunc(strings.Fields(r.docker("")), func(arg string) bool {
+		return path.Base(arg) == RuntimePodman
+	})
+}
@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// SIFOptions controls the conversion of an image to an Apptainer SIF file.
+type SIFOptions struct {
+	// Dest is the path of the SIF file on the remote. Defaults to
+	// <name>_<tag>.sif in the remote user's home directory.
+	Dest string
+	// LocalBuild converts the image with the local apptainer and transfers
+	// the SIF file, instead of building it on the remote from the image
+	// archive.
+	LocalBuild bool
+}
+
+// apptainerBuild is the remote shell snippet running the build with
+// apptainer, or singularity on older installations.
+const apptainerBuild = `$(command -v apptainer || command -v singularity) build --force`
+
+// PushSIF converts imageName to a SIF file for Apptainer/Singularity and
+// places it on the remote, typically an HPC login node without docker.
+func PushSIF(ctx context.Context, imageName, remoteServer string, sopts SIFOptions, opts Options) error {
+	src, imageName, err := openSource(ctx, imageName, opts.Runtime)
+	if err != nil {
+		return fmt.Errorf("local preflight failed: %v", err)
+	}
+	if err := checkPolicy(imageName, opts.AllowedRegistries); err != nil {
+		return err
+	}
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	if remote.windows() {
+		return fmt.Errorf("SIF transfer is only supported for linux hosts")
+	}
+	if !opts.SkipPull {
+		if err := src.pull(remote.ctx, imageName); err != nil {
+			return fmt.Errorf("error pulling local image: %v", err)
+		}
+	}
+	if err := authorizeTransfer(imageName, remote, src, opts.Policy); err != nil {
+		return err
+	}
+
+	ref := parseReference(imageName)
+	dest := sopts.Dest
+	if dest == "" {
+		dest = path.Base(ref.Repository) + "_" + valueOr(ref.Tag, "latest") + ".sif"
+	}
+
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	archiveName, err := uniqueArchiveName(imageName)
+	if err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(tmpDir, archiveName)
+	var localFiles []string
+	removeLocal := func() {
+		for _, f := range localFiles {
+			console.Printf("[CLEANUP] Removing temporary file %s\n", f)
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				console.Printf("[WARNING] Failed to remove temporary file %s: %v\n", f, err)
+			}
+		}
+	}
+	defer onInterrupt(removeLocal)()
+	defer removeLocal()
+
+	localFiles = append(localFiles, tmpFile)
+	console.Printf("[SAVING] Exporting Docker image %q to archive via %s\n", imageName, src.describe())
+	if err := src.save(remote.ctx, imageName, tmpFile); err != nil {
+		return fmt.Errorf("[ERROR] Failed to save image: %v", err)
+	}
+
+	remoteDir, err := remote.tempDir()
+	if err != nil {
+		return err
+	}
+
+	upload := tmpFile
+	var command string
+	if sopts.LocalBuild {
+		sifFile := strings.TrimSuffix(tmpFile, ".tar") + ".sif"
+		localFiles = append(localFiles, sifFile)
+		console.Printf("[CONVERT] Building %s with the local apptainer\n", sifFile)
+		cmd := exec.Command("sh", "-c", apptainerBuild+` "$0" "docker-archive://$1"`, sifFile, tmpFile)
+		cmd.Stdout = console.Writer("")
+		cmd.Stderr = console.Writer("")
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("apptainer build failed: %v", err)
+		}
+		upload = sifFile
+		command = fmt.Sprintf("mv -f %s %s", remote.quote(remote.join(remoteDir, filepath.Base(sifFile))), remote.quote(dest))
+	} else {
+		command = fmt.Sprintf("%s %s docker-archive://%s", apptainerBuild, remote.quote(dest), remote.quote(remote.join(remoteDir, archiveName)))
+	}
+
+	remote.track(remote.join(remoteDir, filepath.Base(upload)))
+	finishRemote := remote.removeArtifacts
+	if opts.KeepRemoteArchive {
+		finishRemote = remote.keepArtifacts
+	}
+	defer finishRemote()
+	defer onInterrupt(finishRemote)()
+
+	console.Printf("[TRANSFER] Starting transfer to %s\n", remote.host)
+	if err := ssh.CopyAndRun(remote.ctx, upload, remote.quotePath(remoteDir), command, remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return fmt.Errorf("[ERROR] SIF transfer failed: %v", err)
+	}
+
+	console.Printf("[SUCCESS] Image %s available as %s on %s\n", imageName, dest, remote.host)
+	return nil
+}
@@ -0,0 +1,89 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// maxCreatedBy bounds the build instruction printed per layer.
+const maxCreatedBy = 60
+
+// InspectSize prints the layers of imageName with their size, uncompressed
+// and as estimated gzip and zstd size, and the instruction that created
+// them. With remoteServer, the layers the host already has are marked and
+// the amount a transfer to it would send is shown. Nothing is transferred.
+func InspectSize(ctx context.Context, imageName, remoteServer string, opts Options) error {
+	est, imageName, remove, err := newEstimator(ctx, imageName, opts)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	var present map[string]bool
+	if remoteServer != "" {
+		remote, err := resolveRemote(ctx, remoteServer, opts)
+		if err != nil {
+			return err
+		}
+		console.Printf("[CHECKING] Listing the layers present on %s...\n", remote.host)
+		if present, err = remoteLayers(remote); err != nil {
+			return err
+		}
+	}
+
+	header := fmt.Sprintf("%-12s %10s %10s %10s %6s", "LAYER", "SIZE MB", "GZIP MB", "ZSTD MB", "SHARE")
+	if present != nil {
+		header += "  REMOTE "
+	}
+	console.Printf("\n%s  CREATED BY\n", header)
+	var layers, gzipped, zstded, needed int64
+	for _, layer := range est.layers {
+		gzipSize, err := est.compressedSize(layer, CompressGzip)
+		if err != nil {
+			return err
+		}
+		zstdSize, err := est.compressedSize(layer, CompressZstd)
+		if err != nil {
+			return err
+		}
+		layers += layer.Size
+		gzipped += gzipSize
+		zstded += zstdSize
+		line := fmt.Sprintf("%-12s %10.2f %10.2f %10.2f %5.1f%%", shortID(layer.ID), mb(layer.Size), mb(gzipSize), mb(zstdSize), 100*float64(layer.Size)/float64(max(est.archiveSize, 1)))
+		if present != nil {
+			if layer.DiffID != "" && present[layer.DiffID] {
+				line += "  present"
+			} else {
+				line += "  missing"
+				needed += layer.Size
+			}
+		}
+		console.Printf("%s  %s\n", line, createdBy(layer.CreatedBy))
+	}
+
+	// Everything that is not a layer (configs, manifests, tar headers) is
+	// always sent
+	overhead := est.archiveSize - layers
+	console.Printf("\n[SIZE] %s: %d layers, archive %.2f MB, about %.2f MB with gzip and %.2f MB with zstd\n",
+		imageName, len(est.layers), mb(est.archiveSize), mb(gzipped+overhead), mb(zstded+overhead))
+	if present != nil {
+		console.Printf("[SIZE] %s has %.2f MB of the layers, a transfer would send %.2f MB (--delta)\n",
+			remoteServer, mb(layers-needed), mb(needed+overhead))
+	}
+	return nil
+}
+
+// createdBy shortens a build instruction for display, dropping the shell
+// prefix docker records for RUN instructions.
+func createdBy(s string) string {
+	s = strings.TrimPrefix(s, "/bin/sh -c ")
+	s = strings.TrimPrefix(s, "#(nop) ")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxCreatedBy {
+		s = s[:maxCreatedBy-3] + "..."
+	}
+	return s
+}
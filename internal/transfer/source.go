@@ -0,0 +1,183 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"remote-pull/internal/console"
+)
+
+// imageSource provides access to images of the local container runtime.
+// Its operations are aborted when ctx is done, e.g. by --transfer-timeout.
+type imageSource interface {
+	// describe returns a short human readable name of the source.
+	describe() string
+	pull(ctx context.Context, imageName string) error
+	// size returns the uncompressed size of the image in bytes.
+	size(ctx context.Context, imageName string) (int64, error)
+	// save writes the image archive to dest.
+	save(ctx context.Context, imageName, dest string) error
+	// export writes the image archive to w as it is produced.
+	export(ctx context.Context, imageName string, w io.Writer) error
+	// inspect returns the metadata of a local image.
+	inspect(ctx context.Context, imageName string) (*imageInfo, error)
+}
+
+// imageInfo is the subset of `docker image inspect` exposed to policies.
+type imageInfo struct {
+	ID           string   `json:"Id"`
+	RepoTags     []string `json:"RepoTags"`
+	RepoDigests  []string `json:"RepoDigests"`
+	Created      string   `json:"Created"`
+	Architecture string   `json:"Architecture"`
+	OS           string   `json:"Os"`
+	Size         int64    `json:"Size"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// openSource returns the source of imageName and the name the image gets on
+// the remote. Besides images of the local runtime, an OCI layout directory
+// can be given as "oci:<dir>[:<tag>]" and a docker-archive as "tar:<file>".
+// runtime selects the local runtime (see selectSource).
+func openSource(ctx context.Context, imageName, runtime string) (imageSource, string, error) {
+	if ref, ok := strings.CutPrefix(imageName, ociPrefix); ok {
+		src, err := newOCISource(ref)
+		if err != nil {
+			return nil, "", err
+		}
+		return src, src.name, nil
+	}
+	if path, ok := strings.CutPrefix(imageName, tarPrefix); ok {
+		src, err := newTarSource(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return src, src.name, nil
+	}
+	src, err := selectSource(ctx, runtime)
+	return src, imageName, err
+}
+
+// imageLoader is implemented by the local runtimes, which can also load an
+// image archive (see FetchImage).
+type imageLoader interface {
+	load(ctx context.Context, r io.Reader) error
+}
+
+// platformSource is implemented by sources holding images for several
+// platforms, so that the one matching the remote is sent.
+type platformSource interface {
+	setPlatform(os, arch string)
+}
+
+// selectSource verifies that the local runtime is usable before any work is
+// done. The docker daemon is used through the Engine API (see
+// newEngineSource), so the docker CLI need not be installed; when no daemon
+// is reachable, the podman CLI is used if it is installed. runtime, one of
+// Runtimes, selects the runtime instead.
+func selectSource(ctx context.Context, runtime string) (imageSource, error) {
+	if runtime == RuntimePodman {
+		return podmanSource()
+	}
+	engine, err := newEngineSource()
+	if err != nil {
+		return nil, err
+	}
+	version, err := engine.ping(ctx)
+	if err == nil {
+		console.Printf("[PREFLIGHT] Using the Engine API at %s (daemon %s)\n", engine.host, version)
+		return engine, nil
+	}
+	if _, lookErr := exec.LookPath("podman"); lookErr == nil && runtime == "" {
+		console.Printf("[PREFLIGHT] docker daemon at %s is not reachable, using podman\n", engine.host)
+		return podmanSource()
+	}
+	return nil, fmt.Errorf("docker daemon at %s is not reachable: %v", engine.host, err)
+}
+
+// podmanSource returns the podman CLI, which needs no daemon.
+func podmanSource() (imageSource, error) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil, fmt.Errorf("podman CLI not found in PATH: %v", err)
+	}
+	output, err := exec.Command("podman", "version", "--format", "{{json .Client.Version}}").CombinedOutput()
+	var version string
+	if err == nil {
+		err = json.Unmarshal(output, &version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("podman CLI found but not usable: %s", strings.TrimSpace(string(output)))
+	}
+	console.Printf("[PREFLIGHT] Using podman CLI (podman %s)\n", version)
+	return cliSource{cmd: "podman"}, nil
+}
+
+// cliSource drives a command line client accepting the image commands of
+// the docker CLI, which podman does.
+type cliSource struct {
+	cmd string
+}
+
+func (s cliSource) describe() string {
+	return s.cmd + " CLI"
+}
+
+func (s cliSource) pull(ctx context.Context, imageName string) error {
+	cmd := exec.CommandContext(ctx, s.cmd, "pull", imageName)
+	cmd.Stdout = console.Writer("")
+	cmd.Stderr = console.Writer("")
+	return cmd.Run()
+}
+
+func (s cliSource) size(ctx context.Context, imageName string) (int64, error) {
+	output, err := exec.CommandContext(ctx, s.cmd, "image", "inspect", "--format", "{{json .Size}}", imageName).Output()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	if err := json.Unmarshal(output, &size); err != nil {
+		return 0, fmt.Errorf("unexpected image size %q: %v", strings.TrimSpace(string(output)), err)
+	}
+	return size, nil
+}
+
+func (s cliSource) inspect(ctx context.Context, imageName string) (*imageInfo, error) {
+	output, err := exec.CommandContext(ctx, s.cmd, "image", "inspect", "--format", "{{json .}}", imageName).Output()
+	if err != nil {
+		return nil, err
+	}
+	info := &imageInfo{}
+	if err := json.Unmarshal(output, info); err != nil {
+		return nil, fmt.Errorf("unexpected image inspect output: %v", err)
+	}
+	info.ID = normalizeImageID(info.ID)
+	return info, nil
+}
+
+func (s cliSource) save(ctx context.Context, imageName, dest string) error {
+	cmd := exec.CommandContext(ctx, s.cmd, "save", "-o", dest, imageName)
+	cmd.Stdout = console.Writer("")
+	cmd.Stderr = console.Writer("")
+	return cmd.Run()
+}
+
+func (s cliSource) export(ctx context.Context, imageName string, w io.Writer) error {
+	cmd := exec.CommandContext(ctx, s.cmd, "save", imageName)
+	cmd.Stdout = w
+	cmd.Stderr = console.Writer("")
+	return cmd.Run()
+}
+
+func (s cliSource) load(ctx context.Context, r io.Reader) error {
+	cmd := exec.CommandContext(ctx, s.cmd, "load")
+	cmd.Stdin = r
+	cmd.Stdout = console.Writer("")
+	cmd.Stderr = console.Writer("")
+	return cmd.Run()
+}
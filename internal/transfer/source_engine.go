@@ -0,0 +1,336 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+
+	"remote-pull/internal/console"
+)
+
+// engineSource reads images through the Docker Engine API with the Go SDK,
+// so no docker CLI is needed locally.
+type engineSource struct {
+	host   string
+	client *client.Client
+}
+
+// newEngineSource connects to the local daemon the way the docker CLI
+// would: DOCKER_HOST (set by --docker-host), else the endpoint of the
+// context in DOCKER_CONTEXT or the CLI's current context, else the default
+// socket. DOCKER_TLS_VERIFY and DOCKER_CERT_PATH apply to tcp:// hosts. The
+// daemon is not contacted yet, see ping.
+func newEngineSource() (*engineSource, error) {
+	host, tlsDir, err := dockerEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.Opt{client.WithAPIVersionNegotiation(), client.WithTLSClientConfigFromEnv()}
+	if tlsDir != "" {
+		file := func(name string) string {
+			if _, err := os.Stat(filepath.Join(tlsDir, name)); err != nil {
+				return ""
+			}
+			return filepath.Join(tlsDir, name)
+		}
+		opts = append(opts, client.WithTLSClientConfig(file("ca.pem"), file("cert.pem"), file("key.pem")))
+	}
+	if u, err := url.Parse(host); err == nil && u.Scheme == "ssh" {
+		// The SDK has no ssh transport: like the docker CLI, the ssh
+		// command runs "docker system dial-stdio" on the daemon host
+		opts = append(opts, client.WithHost("http://docker.example.com"), client.WithDialContext(sshDialer(u)))
+	} else {
+		opts = append(opts, client.WithHost(host))
+	}
+	c, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %v", host, err)
+	}
+	return &engineSource{host: host, client: c}, nil
+}
+
+// dockerEndpoint returns the daemon the docker CLI would use, and the
+// directory with the TLS material of its context, if any.
+func dockerEndpoint() (string, string, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host, "", nil
+	}
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return client.DefaultDockerHost, "", nil
+	}
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		var config struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, "config.json")); err == nil {
+			json.Unmarshal(data, &config)
+		}
+		name = config.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return client.DefaultDockerHost, "", nil
+	}
+
+	// Contexts are stored under the SHA-256 of their name
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return "", "", fmt.Errorf("docker context %q not found: %v", name, err)
+	}
+	var meta struct {
+		Endpoints struct {
+			Docker struct {
+				Host string `json:"Host"`
+			} `json:"docker"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", "", fmt.Errorf("invalid docker context %q: %v", name, err)
+	}
+	if meta.Endpoints.Docker.Host == "" {
+		return "", "", fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err != nil {
+		tlsDir = ""
+	}
+	return meta.Endpoints.Docker.Host, tlsDir, nil
+}
+
+// sshDialer connects to the daemon behind an ssh:// host through the
+// standard input and output of the ssh command.
+func sshDialer(u *url.URL) func(context.Context, string, string) (net.Conn, error) {
+	args := []string{}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		cmd := exec.Command("ssh", args...)
+		cmd.Stderr = console.Writer("")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to run ssh: %v", err)
+		}
+		return &cmdConn{cmd: cmd, Reader: stdout, WriteCloser: stdin}, nil
+	}
+}
+
+// cmdConn is a connection over the standard input and output of a command.
+type cmdConn struct {
+	cmd *exec.Cmd
+	io.Reader
+	io.WriteCloser
+}
+
+func (c *cmdConn) Close() error {
+	c.WriteCloser.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+type cmdAddr struct{}
+
+func (cmdAddr) Network() string { return "cmd" }
+func (cmdAddr) String() string  { return "ssh" }
+
+func (c *cmdConn) LocalAddr() net.Addr                { return cmdAddr{} }
+func (c *cmdConn) RemoteAddr() net.Addr               { return cmdAddr{} }
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (e *engineSource) describe() string {
+	return "Engine API at " + e.host
+}
+
+// ping returns the version of the daemon.
+func (e *engineSource) ping(ctx context.Context) (string, error) {
+	version, err := e.client.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return version.Version, nil
+}
+
+// pull pulls imageName with the credentials of the local docker
+// configuration, if there are any for its registry, and shows the bytes
+// downloaded over all layers.
+func (e *engineSource) pull(ctx context.Context, imageName string) error {
+	var pullOpts image.PullOptions
+	ref := parseReference(imageName)
+	if user, password, err := (RegistryLogin{}).registryCredentials(ref.Registry); err == nil {
+		server := ref.Registry
+		if server == defaultRegistry {
+			server = dockerHubServer
+		}
+		if pullOpts.RegistryAuth, err = registry.EncodeAuthConfig(registry.AuthConfig{Username: user, Password: password, ServerAddress: server}); err != nil {
+			return err
+		}
+	}
+	body, err := e.client.ImagePull(ctx, imageName, pullOpts)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	progressID := "pull " + imageName
+	defer console.EndProgress(progressID)
+	type layer struct{ current, total int64 }
+	layers := map[string]*layer{}
+	dec := json.NewDecoder(body)
+	for {
+		var msg struct {
+			Status         string `json:"status"`
+			ID             string `json:"id"`
+			ProgressDetail struct {
+				Current int64 `json:"current"`
+				Total   int64 `json:"total"`
+			} `json:"progressDetail"`
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+		if msg.ID == "" {
+			console.Println(msg.Status)
+			continue
+		}
+		l := layers[msg.ID]
+		if l == nil {
+			l = &layer{}
+			layers[msg.ID] = l
+		}
+		// Per-layer progress goes into the total, other states are logged
+		switch msg.Status {
+		case "Downloading":
+			l.current, l.total = msg.ProgressDetail.Current, msg.ProgressDetail.Total
+		case "Download complete":
+			l.current = l.total
+		case "Pulling fs layer", "Waiting", "Verifying Checksum", "Extracting":
+		default:
+			l.current = l.total
+			console.Printf("%s: %s\n", msg.ID, msg.Status)
+		}
+		var current, total int64
+		for _, l := range layers {
+			current += l.current
+			total += l.total
+		}
+		if total > 0 {
+			console.Progress(progressID, "Pulling %s: %.2f of %.2f MB", imageName, mb(current), mb(total))
+		}
+	}
+}
+
+// inspectRaw returns the image inspect document of imageName.
+func (e *engineSource) inspectRaw(ctx context.Context, imageName string) ([]byte, error) {
+	var raw bytes.Buffer
+	if _, err := e.client.ImageInspect(ctx, imageName, client.ImageInspectWithRawResponse(&raw)); err != nil {
+		return nil, err
+	}
+	return raw.Bytes(), nil
+}
+
+func (e *engineSource) size(ctx context.Context, imageName string) (int64, error) {
+	info, err := e.inspect(ctx, imageName)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (e *engineSource) inspect(ctx context.Context, imageName string) (*imageInfo, error) {
+	raw, err := e.inspectRaw(ctx, imageName)
+	if err != nil {
+		return nil, err
+	}
+	info := &imageInfo{}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, fmt.Errorf("unexpected image inspect response: %v", err)
+	}
+	return info, nil
+}
+
+func (e *engineSource) save(ctx context.Context, imageName, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if err := e.export(ctx, imageName, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *engineSource) export(ctx context.Context, imageName string, w io.Writer) error {
+	body, err := e.client.ImageSave(ctx, []string{imageName})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (e *engineSource) load(ctx context.Context, r io.Reader) error {
+	resp, err := e.client.ImageLoad(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The response is a stream of JSON messages like that of pull
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+		if msg.Stream != "" {
+			console.Printf("%s", msg.Stream)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package transfer
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	ociPrefix = "oci:"
+
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationRefName    = "org.opencontainers.image.ref.name"
+	annotationImageName  = "io.containerd.image.name"
+	defaultImagePlatform = "linux"
+)
+
+// ociSource reads an image from an OCI image layout, as written by
+// buildx --output type=oci,tar=false or skopeo, without a local
+// daemon. It is saved as docker-archive so that any remote docker can load
+// it.
+type ociSource struct {
+	dir  string
+	tag  string
+	name string
+	os   string
+	arch string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+func newOCISource(ref string) (*ociSource, error) {
+	dir, tag := ref, ""
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndexAny(ref, `/\`) {
+		dir, tag = ref[:i], ref[i+1:]
+	}
+	s := &ociSource{dir: dir, tag: tag, os: defaultImagePlatform, arch: runtime.GOARCH}
+	desc, err := s.selectTag()
+	if err != nil {
+		return nil, err
+	}
+
+	// Name the image after the reference recorded in the layout, falling
+	// back to the directory name
+	s.name = desc.Annotations[annotationImageName]
+	if s.name == "" {
+		refName := desc.Annotations[annotationRefName]
+		switch {
+		case strings.ContainsAny(refName, "/:"):
+			s.name = refName
+		case refName != "":
+			s.name = filepath.Base(filepath.Clean(dir)) + ":" + refName
+		default:
+			s.name = filepath.Base(filepath.Clean(dir)) + ":" + valueOr(tag, defaultTag)
+		}
+	}
+	s.name = strings.ToLower(s.name)
+	return s, nil
+}
+
+func (s *ociSource) describe() string {
+	return "OCI layout " + s.dir
+}
+
+func (s *ociSource) pull(ctx context.Context, imageName string) error {
+	return nil
+}
+
+func (s *ociSource) setPlatform(os, arch string) {
+	if os != "" && arch != "" {
+		s.os, s.arch = os, arch
+	}
+}
+
+// selectTag returns the index entry of the requested tag, or the only entry
+// when no tag is given.
+func (s *ociSource) selectTag() (*ociDescriptor, error) {
+	var index ociIndex
+	if err := s.readJSON(filepath.Join(s.dir, "index.json"), &index); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI layout: %v", s.dir, err)
+	}
+	var found []ociDescriptor
+	for _, m := range index.Manifests {
+		if s.tag == "" || m.Annotations[annotationRefName] == s.tag || strings.HasSuffix(m.Annotations[annotationImageName], ":"+s.tag) {
+			found = append(found, m)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("tag %q not found in OCI layout %s", s.tag, s.dir)
+	case len(found) > 1 && s.tag == "":
+		return nil, fmt.Errorf("OCI layout %s holds %d images, select one with oci:%s:<tag>", s.dir, len(found), s.dir)
+	}
+	return &found[0], nil
+}
+
+// manifest resolves the image manifest for the selected platform.
+func (s *ociSource) manifest() (*ociManifest, error) {
+	desc, err := s.selectTag()
+	if err != nil {
+		return nil, err
+	}
+	for desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerList {
+		var index ociIndex
+		if err := s.readJSON(s.blobPath(desc.Digest), &index); err != nil {
+			return nil, err
+		}
+		desc, err = s.selectPlatform(index.Manifests)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var m ociManifest
+	if err := s.readJSON(s.blobPath(desc.Digest), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *ociSource) selectPlatform(manifests []ociDescriptor) (*ociDescriptor, error) {
+	var available []string
+	for i, m := range manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if m.Platform.OS == s.os && m.Platform.Architecture == s.arch {
+			return &manifests[i], nil
+		}
+		// Attestation manifests are listed with platform unknown/unknown
+		if m.Platform.OS != "unknown" {
+			available = append(available, m.Platform.OS+"/"+m.Platform.Architecture)
+		}
+	}
+	return nil, fmt.Errorf("OCI layout %s has no image for %s/%s (available: %s)", s.dir, s.os, s.arch, strings.Join(available, ", "))
+}
+
+func (s *ociSource) blobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(s.dir, "blobs", algorithm, hex)
+}
+
+func (s *ociSource) readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *ociSource) size(ctx context.Context, imageName string) (int64, error) {
+	m, err := s.manifest()
+	if err != nil {
+		return 0, err
+	}
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+func (s *ociSource) inspect(ctx context.Context, imageName string) (*imageInfo, error) {
+	m, err := s.manifest()
+	if err != nil {
+		return nil, err
+	}
+	info := &imageInfo{ID: m.Config.Digest, Size: m.Config.Size}
+	for _, layer := range m.Layers {
+		info.Size += layer.Size
+	}
+	// The image config has the same fields as docker's inspect output,
+	// with lower-case keys
+	if err := s.readJSON(s.blobPath(m.Config.Digest), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *ociSource) save(ctx context.Context, imageName, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.export(ctx, imageName, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// export writes the selected image as docker-archive: its config and layer
+// blobs plus a manifest.json tagging it with the image name.
+func (s *ociSource) export(ctx context.Context, imageName string, w io.Writer) error {
+	m, err := s.manifest()
+	if err != nil {
+		return err
+	}
+	entry := struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}{Config: blobName(m.Config.Digest), RepoTags: []string{s.name}}
+	blobs := []string{m.Config.Digest}
+	for _, layer := range m.Layers {
+		entry.Layers = append(entry.Layers, blobName(layer.Digest))
+		blobs = append(blobs, layer.Digest)
+	}
+	manifest, err := json.Marshal([]any{entry})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	written := map[string]bool{}
+	for _, digest := range blobs {
+		if written[digest] {
+			continue
+		}
+		written[digest] = true
+		if err := addFile(tw, s.blobPath(digest), blobName(digest)); err != nil {
+			return err
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0o644, Size: int64(len(manifest))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func blobName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func addFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
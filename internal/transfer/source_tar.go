@@ -0,0 +1,145 @@
+package transfer
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const tarPrefix = "tar:"
+
+// tarSource sends an archive written earlier by docker save (or any other
+// docker-archive producer) as is, without pulling or saving.
+type tarSource struct {
+	path string
+	name string
+	// config is the archive entry holding the image config.
+	config archiveLayer
+}
+
+func newTarSource(path string) (*tarSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var manifest []struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags"`
+	}
+	var index ociIndex
+	entries := map[string]archiveLayer{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %v", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		offset, _ := f.Seek(0, io.SeekCurrent)
+		entries[hdr.Name] = archiveLayer{Path: hdr.Name, Size: hdr.Size, Offset: offset}
+		switch hdr.Name {
+		case "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest.json in %s: %v", path, err)
+			}
+		case "index.json":
+			json.NewDecoder(tr).Decode(&index)
+		}
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("%s is not a docker-archive: manifest.json is missing", path)
+	}
+	if len(manifest) > 1 {
+		return nil, fmt.Errorf("%s holds %d images, only archives of a single image can be sent", path, len(manifest))
+	}
+
+	s := &tarSource{path: path, config: entries[manifest[0].Config]}
+	// The tag comes from the archive, since docker load restores it
+	if len(manifest[0].RepoTags) > 0 {
+		s.name = manifest[0].RepoTags[0]
+	} else if len(index.Manifests) > 0 {
+		s.name = index.Manifests[0].Annotations[annotationImageName]
+	}
+	if s.name == "" {
+		return nil, fmt.Errorf("image in %s is not tagged; save it by name, e.g. docker save -o %s image:tag", path, path)
+	}
+	return s, nil
+}
+
+func (s *tarSource) describe() string {
+	return "archive " + s.path
+}
+
+func (s *tarSource) pull(ctx context.Context, imageName string) error {
+	return nil
+}
+
+func (s *tarSource) size(ctx context.Context, imageName string) (int64, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *tarSource) inspect(ctx context.Context, imageName string) (*imageInfo, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, err := io.ReadAll(io.NewSectionReader(f, s.config.Offset, s.config.Size))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(config)
+	info := &imageInfo{ID: "sha256:" + hex.EncodeToString(sum[:])}
+	if err := json.Unmarshal(config, info); err != nil {
+		return nil, fmt.Errorf("invalid image config in %s: %v", s.path, err)
+	}
+	info.Size, _ = s.size(ctx, imageName)
+	return info, nil
+}
+
+func (s *tarSource) export(ctx context.Context, imageName string, w io.Writer) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// save links the archive to dest, copying it only when dest is on another
+// filesystem, so the caller can treat it like a freshly saved archive.
+func (s *tarSource) save(ctx context.Context, imageName, dest string) error {
+	if err := os.Link(s.path, dest); err == nil {
+		return nil
+	}
+	src, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, src); err != nil {
+		return err
+	}
+	return f.Close()
+}
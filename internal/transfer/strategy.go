@@ -0,0 +1,164 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// StrategyAuto tries the transfer strategies in turn, falling back to the
+// next one when the remote lacks what a strategy needs.
+const StrategyAuto = "auto"
+
+// Strategies lists the valid values of Options.Strategy.
+var Strategies = []string{StrategyAuto, ssh.StrategyStream, ssh.StrategySFTP, ssh.StrategySCP, ssh.StrategyShell}
+
+// strategies returns the strategies to try for remote, in order. Streaming
+// leaves no archive to keep or resume, and PowerShell can neither stream binary input
+// to docker nor write it to a file.
+func (r *remoteHost) strategies(opts Options) []string {
+	if opts.Strategy != "" && opts.Strategy != StrategyAuto {
+		return []string{opts.Strategy}
+	}
+	if r.strategy != "" {
+		return []string{r.strategy}
+	}
+	var strategies []string
+	if !opts.KeepRemoteArchive && !opts.Resume && !r.windows() {
+		strategies = append(strategies, ssh.StrategyStream)
+	}
+	strategies = append(strategies, ssh.StrategySFTP, ssh.StrategySCP)
+	if !r.windows() {
+		strategies = append(strategies, ssh.StrategyShell)
+	}
+	return strategies
+}
+
+// sendAndLoad sends archive to remote and loads it, using the first strategy
+// the remote supports. The strategy that worked is used for the rest of the
+// run. Archives written to the remote are tracked for removal. With
+// compression the stream is compressed on the fly, while the strategies
+// writing a file send a compressed copy of the archive; decompress is the
+// remote command decompressing it, if docker load cannot. With
+// Options.Resume the remote file is named resumeName and kept when the
+// upload fails, so the next attempt continues it (see resumeUpload).
+func sendAndLoad(archive, resumeName, remoteDir, decompress string, remote *remoteHost, opts Options, sshOpts ssh.Options) error {
+	load := func(file string) string {
+		switch {
+		case decompress != "" && file != "":
+			return remote.command(decompress + " < " + remote.quote(file) + " | " + remote.loadCommand(""))
+		case decompress != "":
+			return remote.command(decompress + " | " + remote.loadCommand(""))
+		}
+		return remote.command(remote.loadCommand(file))
+	}
+
+	// The copy sent by the file strategies is created on first use
+	fileArchive := archive
+	if compressing(opts) {
+		var err error
+		if fileArchive, err = siblingFile(archive, ".tar"+compressExt(opts.Compress)); err != nil {
+			return fmt.Errorf("failed to compress archive: %v", err)
+		}
+		removeCompressed := func() {
+			if err := os.Remove(fileArchive); err != nil && !os.IsNotExist(err) {
+				console.Printf("[WARNING] Failed to remove compressed archive %s: %v\n", fileArchive, err)
+			}
+		}
+		defer onInterrupt(removeCompressed)()
+		defer removeCompressed()
+	}
+	compressed := false
+	remoteFile := remote.join(remoteDir, filepath.Base(fileArchive))
+	resume := opts.Resume && !remote.windows()
+	if resume {
+		remoteFile = remote.join(remoteDir, resumeName+".tar"+compressExt(opts.Compress))
+	}
+	var manifest *resumeManifest
+	tracked := false
+
+	strategies := remote.strategies(opts)
+	for i, strategy := range strategies {
+		if strategy != ssh.StrategyStream {
+			// A resumable upload is kept until it has been loaded
+			if !tracked && !resume {
+				remote.track(remoteFile)
+				tracked = true
+			}
+			if compressing(opts) && !compressed {
+				console.Printf("[COMPRESSING] Compressing archive with %s\n", opts.Compress)
+				if err := compressFile(archive, fileArchive, opts); err != nil {
+					return fmt.Errorf("failed to compress archive: %v", err)
+				}
+				compressed = true
+				// Progress of the compressed file says nothing about the
+				// position in the archive
+				sshOpts.Copied = nil
+			}
+		}
+		if resume && strategy != ssh.StrategySCP {
+			var err error
+			if manifest == nil {
+				if manifest, err = archiveManifest(fileArchive); err != nil {
+					return fmt.Errorf("failed to checksum archive: %v", err)
+				}
+			}
+			if sshOpts.Offset, err = resumeUpload(manifest, remoteFile, remote); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch strategy {
+		case ssh.StrategyStream:
+			streamOpts := sshOpts
+			streamOpts.Compressor = compressor(opts)
+			err = ssh.StreamRun(remote.ctx, archive, load(""), remote.user, remote.host, opts.LoadTimeout, streamOpts)
+		case ssh.StrategySFTP:
+			err = ssh.SFTPCopyAndRun(remote.ctx, fileArchive, remote.sftpPath(remoteFile), load(remoteFile), remote.user, remote.host, opts.LoadTimeout, sshOpts)
+		case ssh.StrategySCP:
+			err = ssh.CopyAndRun(remote.ctx, fileArchive, remote.quotePath(remoteDir), load(remoteFile), remote.user, remote.host, opts.LoadTimeout, sshOpts)
+		case ssh.StrategyShell:
+			err = ssh.ShellCopyAndRun(remote.ctx, fileArchive, remote.quote(remoteFile), load(remoteFile), remote.user, remote.host, opts.LoadTimeout, sshOpts)
+		default:
+			return fmt.Errorf("unknown transfer strategy %q", strategy)
+		}
+
+		var unsupported *ssh.UnsupportedError
+		if err == nil {
+			console.Printf("[STRATEGY] Transferred to %s with %s\n", remote.host, strategy)
+			remote.strategy = strategy
+			if resume {
+				remote.track(remoteFile)
+				remote.track(remoteFile + ".manifest")
+			}
+			return nil
+		}
+		if !errors.As(err, &unsupported) || i == len(strategies)-1 {
+			if resume {
+				console.Printf("[RESUME] Keeping %s on %s, run again to continue the transfer\n", remoteFile, remote.host)
+			}
+			return err
+		}
+		console.Printf("[FALLBACK] %s transfer not possible on %s (%v), trying %s\n", strategy, remote.host, err, strategies[i+1])
+	}
+	return nil
+}
+
+// sftpPath converts a remote path for use over SFTP, which expects forward
+// slashes and Windows drive letters as /C:/...
+func (r *remoteHost) sftpPath(p string) string {
+	if !r.windows() {
+		return p
+	}
+	p = strings.ReplaceAll(p, `\`, "/")
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/" + p
+	}
+	return p
+}
@@ -1,110 +1,598 @@
 package transfer
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
+	"remote-pull/internal/console"
 	"remote-pull/pkg/ssh"
 )
 
-func TransferImage(imageName, remoteServer string, skipPull bool) error {
-	// Split remote server into user and host
-	parts := strings.Split(remoteServer, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid remote server format, expected user@host")
+// Options controls how an image is transferred.
+type Options struct {
+	SkipPull bool
+	// RemoteOS selects the shell and path conventions of the target
+	// ("linux" or "windows").
+	RemoteOS string
+	// RemoteDocker is the command line invoking docker on the remote. When
+	// empty it is detected, including runtimes inside a VM on the remote.
+	RemoteDocker string
+	// Runtime is the container runtime used locally and on the remote, one
+	// of Runtimes. When empty, docker is preferred and podman used where
+	// docker is not installed.
+	Runtime string
+	// RemoteRuntime overrides Runtime on the remote, one of RemoteRuntimes.
+	// With RuntimeContainerd images are loaded into Namespace
+	// (DefaultNamespace when empty) with nerdctl or ctr.
+	RemoteRuntime string
+	Namespace     string
+	// KeepRemoteArchive leaves the archive on the remote host instead of
+	// removing it after the load (or after a failure), for debugging.
+	KeepRemoteArchive bool
+	// LocalTmp is the local directory used for the image archive. Defaults to
+	// the system temp directory, which honors TMPDIR.
+	LocalTmp string
+	// LoadTimeout bounds the remote "docker load" phase. Zero means no limit.
+	LoadTimeout time.Duration
+	// ConnectTimeout bounds every SSH connection attempt (see
+	// ssh.Options.ConnectTimeout).
+	ConnectTimeout time.Duration
+	// Unreachable decides about hosts that do not answer a quick probe
+	// before a run to several hosts, one of UnreachableModes; empty is
+	// UnreachableWait. ProbeTimeout bounds the probe of each host.
+	Unreachable  string
+	ProbeTimeout time.Duration
+	// TransferTimeout bounds the whole transfer to each host, from the
+	// first check to the health check. Zero means no limit.
+	TransferTimeout time.Duration
+	// Strategy selects how the archive gets to the remote: StrategyAuto
+	// (the default) tries streaming, SFTP, SCP and the remote shell in turn.
+	Strategy string
+	// Pipe feeds the local export straight into the remote docker load
+	// without a local archive (see pipeImage).
+	Pipe bool
+	// Delta sends only the layers the remote does not have yet (see
+	// deltaArchive).
+	Delta bool
+	// Agent installs the agent on the remote and sends archives through it
+	// (see agentLoad). AgentCache is the size of its archive cache in MB.
+	Agent      bool
+	AgentCache int64
+	// Checksum is the algorithm the agent verifies transfers with (see
+	// package checksum), sha256 when empty.
+	Checksum string
+	// FIPS restricts SSH and checksums to FIPS-approved algorithms.
+	FIPS bool
+	// RemoteHelper is a pre-installed command that is the only thing run on
+	// the remote, for restricted accounts (see helperTarget).
+	RemoteHelper string
+	// UpdateHostKey replaces a changed host key in known_hosts instead of
+	// aborting the connection.
+	UpdateHostKey bool
+	// StrictHostKeyChecking decides about hosts without a known_hosts
+	// entry (see ssh.HostKeyChecks).
+	StrictHostKeyChecking string
+	// BandwidthLimit limits the combined upload rate of all hosts of a run
+	// to this many MB/s, divided between the hosts being transferred to by
+	// BandwidthWeights, keyed by target (default 1 each). Zero
+	// disables the limit.
+	BandwidthLimit   float64
+	BandwidthWeights map[string]float64
+	// HostKeys pins the host keys of targets, keyed by host:port (see
+	// ssh.Target.Address and ssh.Options.HostKeys).
+	HostKeys map[string][]string
+	// Transport selects how the SSH server is reached (direct, or tunneled
+	// through Teleport and similar).
+	Transport ssh.Transport
+	// Vault configures certificate authentication through Vault's SSH
+	// secrets engine.
+	Vault ssh.VaultOptions
+	// Secrets supplies key passphrases and passwords from a keychain or
+	// password manager.
+	Secrets ssh.SecretOptions
+	// Login runs docker login on the remote before the transfer.
+	Login RegistryLogin
+	// Reporters receive per-host results (CI integrations, reports and
+	// metrics).
+	Reporters []Reporter
+	// Verbose prints additional detail such as the per-layer breakdown.
+	Verbose bool
+	// AllowedRegistries restricts transfers to images from these registries
+	// or namespaces, e.g. "registry.corp/*". Empty allows all images.
+	AllowedRegistries []string
+	// Policy authorizes every transfer with a Rego policy or webhook.
+	Policy PolicyOptions
+	// NoLoad delivers the archive as a file to RemotePath instead of
+	// loading it into the remote docker.
+	NoLoad bool
+	// RemotePath is the destination of the archive with NoLoad. Defaults
+	// to <name>_<tag>.tar in the remote user's home directory.
+	RemotePath string
+	// Compress selects the compression of the archive on its way to the
+	// remote (see Compressions), or of the file delivered with NoLoad.
+	// CompressLevel is the level of the algorithm, 0 for its default.
+	Compress      string
+	CompressLevel int
+	// PostLoad runs a command on the remote after the image was loaded.
+	PostLoad PostLoadOptions
+	// HealthCheck gates the run on a remote check after the load and the
+	// post-load command.
+	HealthCheck HealthCheckOptions
+	// Canary is the number of hosts transferred to first; the remaining
+	// hosts only follow if all of them succeed, after CanaryWait.
+	Canary     int
+	CanaryWait time.Duration
+	// Parallel is the number of hosts transferred to at the same time in
+	// a run to several hosts.
+	Parallel int
+	// ParallelImages is the number of images transferred at the same time
+	// in a run with several images (see TransferImages).
+	ParallelImages int
+	// Retain tags every image on the remote as <repository>:current and
+	// keeps the versions it replaces as <repository>:prev1, prev2, ... for
+	// rollback.
+	Retain bool
+	// RetainVersions is the number of replaced versions kept with Retain;
+	// older ones are removed.
+	RetainVersions int
+	// Metadata adds tags and labels to the image on the remote.
+	Metadata MetadataOptions
+	// RestartContainers recreates the running containers of previous
+	// versions of the image's repository with the transferred image.
+	RestartContainers bool
+	// Resume keeps an interrupted upload on the remote and continues it in
+	// the next run (see resumeUpload).
+	Resume bool
+
+	// archives shares the local archives between the hosts of a run.
+	archives *archiveCache
+	// events reports the progress of the host being transferred to.
+	events *hostEvents
+	// conns shares the connection to each host between the images of a
+	// run.
+	conns *ssh.Pool
+	// reportMu serializes the calls to Reporters of the images of a run.
+	reportMu *sync.Mutex
+	// bandwidth enforces BandwidthLimit across all uploads of a run.
+	bandwidth *ssh.Bandwidth
+}
+
+func TransferImage(ctx context.Context, imageName, remoteServer string, opts Options) error {
+	return TransferToTargets(ctx, imageName, []string{remoteServer}, opts)
+}
+
+// transferTarget transfers imageName to a single host, recording the outcome
+// in result. check, when set, holds the result of checking the host up
+// front. The transfer is aborted after opts.TransferTimeout.
+func transferTarget(ctx context.Context, imageName, remoteServer string, check *hostCheck, opts Options, result *Result) error {
+	if opts.TransferTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.TransferTimeout, fmt.Errorf("transfer to %s timed out after %v", remoteServer, opts.TransferTimeout))
+		defer cancel()
+	}
+
+	// Make sure the local runtime works before touching the remote
+	src, imageName, err := openSource(ctx, imageName, opts.Runtime)
+	if err != nil {
+		return fmt.Errorf("local preflight failed: %v", err)
+	}
+	if err := checkPolicy(imageName, opts.AllowedRegistries); err != nil {
+		return err
+	}
+	postLoad, err := parsePostLoad(opts.PostLoad)
+	if err != nil {
+		return err
+	}
+	var remote *remoteHost
+	if check != nil {
+		if check.remote == nil {
+			return check.err
+		}
+		// The host was checked up front, the rest of the transfer is
+		// bounded by ctx
+		remote = check.remote
+		remote.ctx = ctx
+	} else if remote, err = resolveRemote(ctx, remoteServer, opts); err != nil {
+		return err
+	}
+	if opts.NoLoad {
+		return deliverTarget(imageName, remote, src, opts, result)
+	}
+	if opts.RemoteHelper != "" {
+		return helperTarget(imageName, remote, src, opts, result)
 	}
-	user := parts[0]
-	host := parts[1]
 
-	// Check if image exists on remote
-	fmt.Printf("[CHECKING] Verifying if %s exists on %s...\n", imageName, remoteServer)
-	exists, err := checkRemoteImage(imageName, user, host)
+	logout, err := loginRemote(remote, opts.Login)
+	if err != nil {
+		return err
+	}
+	defer logout()
+
+	// Check if image exists on remote, unless that was done up front
+	opts.events.phase(PhaseCheck)
+	var imageID string
+	if check != nil {
+		imageID, err = check.imageID, check.err
+	} else {
+		console.Printf("[CHECKING] Verifying if %s exists on %s...\n", imageName, remoteServer)
+		imageID, err = checkRemoteImage(imageName, remote)
+	}
 	if err != nil {
 		return fmt.Errorf("error checking remote image: %v", err)
 	}
+	var previous []string
+	if opts.RestartContainers {
+		if previous, err = previousVersions(imageName, remote); err != nil {
+			return err
+		}
+	}
 
-	if exists {
-		fmt.Printf("[SKIPPING] Image %s already exists on %s - no transfer needed\n", imageName, remoteServer)
-		return nil
+	if imageID != "" {
+		console.Printf("[SKIPPING] Image %s already exists on %s - no transfer needed\n", imageName, remoteServer)
+		return skipExisting(imageName, imageID, previous, remote, src, opts, result)
+	}
+	console.Printf("[PROCEEDING] Image %s not found on %s - proceeding with transfer\n", imageName, remoteServer)
+
+	// Join an identical transfer that is already running instead of
+	// repeating it
+	unlock, waited, err := lockTransfer(imageName, remote)
+	if err != nil && remote.ctx.Err() != nil {
+		return err
+	} else if err != nil {
+		console.Printf("[WARNING] Unable to coordinate with concurrent transfers: %v\n", err)
+	} else {
+		defer unlock()
+	}
+	if waited {
+		if imageID, err = checkRemoteImage(imageName, remote); err != nil {
+			return fmt.Errorf("error checking remote image: %v", err)
+		}
+		if imageID != "" {
+			console.Printf("[COALESCED] Image %s was transferred to %s by a concurrent run\n", imageName, remoteServer)
+			return skipExisting(imageName, imageID, previous, remote, src, opts, result)
+		}
+	}
+
+	// Find out what the remote can load before doing any expensive work
+	rt, err := inspectRemoteRuntime(remote)
+	if err != nil {
+		return err
+	}
+	if ps, ok := src.(platformSource); ok {
+		ps.setPlatform(rt.OS, rt.Arch)
 	}
-	fmt.Printf("[PROCEEDING] Image %s not found on %s - proceeding with transfer\n", imageName, remoteServer)
 
 	// Pull image locally if needed and not skipped
-	if !skipPull {
-		if err := pullLocalImage(imageName); err != nil {
+	if !opts.SkipPull {
+		opts.events.phase(PhasePull)
+		if err := src.pull(remote.ctx, imageName); err != nil {
 			return fmt.Errorf("error pulling local image: %v", err)
 		}
 	} else {
-		fmt.Printf("[SKIPPING] Local pull for %s as requested\n", imageName)
+		console.Printf("[SKIPPING] Local pull for %s as requested\n", imageName)
+	}
+
+	if err := authorizeTransfer(imageName, remote, src, opts.Policy); err != nil {
+		return err
 	}
 
 	// Transfer image to remote
-	if err := transferImage(imageName, user, host); err != nil {
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	if opts.Pipe {
+		err = pipeImage(imageName, remote, rt, src, opts, result)
+	} else {
+		err = transferImage(imageName, remote, rt, src, opts, result)
+	}
+	if err != nil {
 		return fmt.Errorf("error transferring image: %v", err)
 	}
+	result.Status = StatusTransferred
+
+	// The ID is informational, a failure to read it does not fail the run
+	if result.ImageID, err = checkRemoteImage(imageName, remote); err != nil {
+		console.Printf("[WARNING] Unable to read image ID of %s on %s: %v\n", imageName, remoteServer, err)
+	}
+	if len(opts.Metadata.Labels) > 0 {
+		labeled, err := labelImage(imageName, opts.Metadata.Labels, remote)
+		if err != nil {
+			return err
+		}
+		if labeled != "" {
+			result.ImageID = labeled
+		}
+	}
+	if opts.Metadata.Tags && result.ImageID != "" {
+		if err := propagateTags(imageName, result.ImageID, remote, src); err != nil {
+			return err
+		}
+	}
 
+	if err := activate(imageName, result.ImageID, remote, opts); err != nil {
+		return err
+	}
+	if opts.RestartContainers && result.ImageID != "" {
+		if err := restartContainers(imageName, result.ImageID, previous, remote); err != nil {
+			return err
+		}
+	}
+	if postLoad != nil {
+		opts.events.phase(PhasePostLoad)
+		if err := runPostLoad(postLoad, opts.PostLoad, imageName, remote, src, result.ImageID); err != nil {
+			return err
+		}
+	}
+	if opts.HealthCheck.Command != "" {
+		opts.events.phase(PhaseHealthCheck)
+		return waitHealthy(remote, opts.HealthCheck)
+	}
 	return nil
 }
 
-func checkRemoteImage(imageName, user, host string) (bool, error) {
-	cmd := fmt.Sprintf("docker images -q %s", imageName)
-	output, err := ssh.RunCommand(cmd, user, host)
+// skipExisting finishes a transfer of imageName that is skipped because the
+// remote already has it as imageID: the local tags are propagated, the image
+// activated and the containers of previous versions restarted as requested.
+func skipExisting(imageName, imageID string, previous []string, remote *remoteHost, src imageSource, opts Options, result *Result) error {
+	result.Status = StatusSkipped
+	result.ImageID = imageID
+	if opts.Metadata.Tags {
+		if err := propagateTags(imageName, imageID, remote, src); err != nil {
+			return err
+		}
+	}
+	if err := activate(imageName, imageID, remote, opts); err != nil {
+		return err
+	}
+	if opts.RestartContainers {
+		return restartContainers(imageName, imageID, previous, remote)
+	}
+	return nil
+}
+
+// resolveRemote parses and validates remoteServer and sets up the remote
+// host handle used by all operations.
+func resolveRemote(ctx context.Context, remoteServer string, opts Options) (*remoteHost, error) {
+	// Split remote server into user, host and optional port
+	target, err := ssh.ParseTarget(remoteServer)
+	if err != nil {
+		return nil, err
+	}
+	if err := ssh.ValidateTarget(target); err != nil {
+		return nil, err
+	}
+	sshOpts := ssh.Options{Port: target.Port, ConnectTimeout: opts.ConnectTimeout, UpdateHostKey: opts.UpdateHostKey, StrictHostKeyChecking: opts.StrictHostKeyChecking, Transport: opts.Transport, Vault: opts.Vault, Secrets: opts.Secrets, FIPS: opts.FIPS, HostKeys: opts.HostKeys[target.Address()], Pool: opts.conns, Bandwidth: opts.bandwidth, BandwidthWeight: opts.BandwidthWeights[target.String()]}
+	return newRemoteHost(ctx, target.User, target.Host, opts.RemoteOS, opts.RemoteDocker, valueOr(opts.RemoteRuntime, opts.Runtime), opts.Namespace, sshOpts)
+}
+
+// uniqueArchiveName derives a file name for the archive of imageName that is
+// safe on any filesystem: a short readable prefix, a hash of the canonical
+// reference (so digests, ports and colons never end up in the name) and a
+// random suffix.
+func uniqueArchiveName(imageName string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate archive name: %v", err)
+	}
+	return fmt.Sprintf("%s-%s.tar", archiveBaseName(imageName), hex.EncodeToString(suffix)), nil
+}
+
+// resumableArchiveName is the name, without extension, of the remote archive
+// of imageName with Options.Resume; it is the same in every run so that a
+// later run finds a partial upload.
+func resumableArchiveName(imageName string) string {
+	return archiveBaseName(imageName) + "-resume"
+}
+
+// archiveBaseName is the readable prefix and hash of the archive names of
+// imageName.
+func archiveBaseName(imageName string) string {
+	ref := parseReference(imageName)
+	sum := sha256.Sum256([]byte(ref.String()))
+
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, ref.shortName())
+	if len(prefix) > 32 {
+		prefix = prefix[:32]
+	}
+	return prefix + "-" + hex.EncodeToString(sum[:6])
+}
+
+// checkLocalSpace verifies that dir can hold the archive of imageName, using
+// the image's uncompressed size as estimate of the archive size.
+func checkLocalSpace(ctx context.Context, imageName, dir string, src imageSource) error {
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("local temp directory %s is not usable: %v", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("local temp directory %s is not a directory", dir)
+	}
+
+	required, err := src.size(ctx, imageName)
 	if err != nil {
-		return false, err
+		console.Printf("[WARNING] Unable to determine size of %s, skipping free space check: %v\n", imageName, err)
+		return nil
 	}
-	return strings.TrimSpace(output) != "", nil
+
+	available, err := freeSpace(dir)
+	if err != nil {
+		console.Printf("[WARNING] Unable to determine free space in %s: %v\n", dir, err)
+		return nil
+	}
+	if available < required {
+		return fmt.Errorf("not enough space in %s: image needs about %.2f MB but only %.2f MB are available (use --local-tmp or TMPDIR to pick another directory)",
+			dir, float64(required)/1024/1024, float64(available)/1024/1024)
+	}
+	return nil
 }
 
-func pullLocalImage(imageName string) error {
-	cmd := exec.Command("docker", "pull", imageName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// checkRemoteImage returns the ID of imageName on the remote, or "" when it
+// is not present. The listing is requested as JSON so the result does not
+// depend on the remote docker version's table layout or locale.
+func checkRemoteImage(imageName string, remote *remoteHost) (string, error) {
+	if remote.ctr() {
+		// ctr lists images by their full name, with the digest in the
+		// third column
+		output, err := remote.run(remote.docker("images ls " + remote.quote("name=="+parseReference(imageName).String())))
+		if err != nil {
+			return "", err
+		}
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) < 2 {
+			return "", nil
+		}
+		fields := strings.Fields(lines[1])
+		if len(fields) < 3 {
+			return "", fmt.Errorf("unexpected output from ctr images ls: %q", lines[1])
+		}
+		return fields[2], nil
+	}
+	if remote.podman() {
+		// podman's images --format has other fields than docker's
+		name := remote.quote(imageName)
+		output, err := remote.run(fmt.Sprintf("if %s; then %s; fi", remote.docker("image exists "+name), remote.docker("image inspect --format "+remote.quote("{{.Id}}")+" "+name)))
+		if err != nil {
+			return "", err
+		}
+		return normalizeImageID(strings.TrimSpace(output)), nil
+	}
+	cmd := remote.docker(fmt.Sprintf("images --no-trunc --format %s %s", remote.quote("{{json .}}"), remote.quote(imageName)))
+	output, err := remote.run(cmd)
+	if err != nil {
+		return "", err
+	}
+	images, err := decodeJSONLines[imageSummary](output)
+	if err != nil {
+		return "", fmt.Errorf("unexpected output from remote docker images: %v", err)
+	}
+	for _, image := range images {
+		if image.ID != "" {
+			return image.ID, nil
+		}
+	}
+	return "", nil
 }
 
-func transferImage(imageName, user, host string) error {
-	fmt.Printf("[CONNECTING] Establishing connection to '%s@%s' ...\n", user, host)
-
-	// Create temp file for image tar
-	tmpFile := fmt.Sprintf("/tmp/%s.tar", strings.ReplaceAll(imageName, "/", "_"))
-	fmt.Printf("[PREPARING] Creating temporary archive at %s\n", tmpFile)
-
-	// Save local image to tar file
-	fmt.Printf("[SAVING] Exporting Docker image %q to archive\n", imageName)
-	saveCmd := exec.Command("docker", "save", "-o", tmpFile, imageName)
-	saveCmd.Stdout = os.Stdout
-	saveCmd.Stderr = os.Stderr
-	if err := saveCmd.Run(); err != nil {
-		return fmt.Errorf("[ERROR] Failed to save image: %v", err)
-	}
-	defer func() {
-		fmt.Printf("[CLEANUP] Removing temporary archive %s\n", tmpFile)
-		cmd := exec.Command("rm", "-f", tmpFile)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Run()
-	}()
+func transferImage(imageName string, remote *remoteHost, rt *remoteRuntime, src imageSource, opts Options, result *Result) error {
+	console.Printf("[CONNECTING] Establishing connection to '%s' ...\n", remote)
+
+	remoteDir, err := remote.tempDir()
+	if err != nil {
+		return fmt.Errorf("[ERROR] %v", err)
+	}
+
+	// The random suffix of the archive name keeps concurrent transfers of
+	// the same image from clobbering each other's archives, both locally and
+	// on the remote where the file keeps the same name.
+	opts.events.phase(PhaseSave)
+	tmpFile, removeLocal, err := saveArchive(remote.ctx, imageName, rt.OS+"/"+rt.Arch, src, opts)
+	if err != nil {
+		return err
+	}
+	defer removeLocal()
 
 	// Get file size for progress calculation
 	fileInfo, err := os.Stat(tmpFile)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get archive size: %v", err)
 	}
+	result.Bytes = fileInfo.Size()
 	sizeMB := float64(fileInfo.Size()) / 1024 / 1024
-	fmt.Printf("[STATUS] Archive size: %.2f MB\n", sizeMB)
+	console.Printf("[STATUS] Archive size: %.2f MB\n", sizeMB)
+
+	features, err := inspectArchive(tmpFile)
+	if err != nil {
+		return fmt.Errorf("[ERROR] %v", err)
+	}
+	if err := checkCompatibility(rt, features); err != nil {
+		return fmt.Errorf("[ERROR] Remote cannot load this image: %v", err)
+	}
+	decompress, err := remote.decompressor(rt, opts)
+	if err != nil {
+		return fmt.Errorf("[ERROR] %v", err)
+	}
 
 	// Transfer tar file to remote host
-	fmt.Printf("[TRANSFER] Starting transfer to %s (%.2f MB)\n", host, sizeMB)
-	fmt.Println("[PROGRESS] Transfer in progress...")
+	opts.events.phase(PhaseTransfer)
+	if compressing(opts) {
+		console.Printf("[TRANSFER] Starting transfer to %s (%.2f MB, compressed with %s)\n", remote.host, sizeMB, opts.Compress)
+	} else {
+		console.Printf("[TRANSFER] Starting transfer to %s (%.2f MB)\n", remote.host, sizeMB)
+	}
+	console.Println("[PROGRESS] Transfer in progress...")
 
-	transferCmd := fmt.Sprintf("docker load -i %s", tmpFile)
-	err = ssh.CopyAndRun(tmpFile, transferCmd, user, host)
-	if err != nil {
-		return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+	// The remote archive is registered before the copy starts so that a
+	// partial upload is removed as well if anything below fails or is
+	// interrupted.
+	finishRemote := remote.removeArtifacts
+	if opts.KeepRemoteArchive {
+		finishRemote = remote.keepArtifacts
+	}
+	defer finishRemote()
+	defer onInterrupt(finishRemote)()
+	send := func(archive, resumeName string, layers []archiveLayer) ([]LayerStat, error) {
+		timer := newLayerTimer(layers)
+		sshOpts := remote.sshOpts
+		sshOpts.Copied = timer.copied
+		if info, err := os.Stat(archive); err == nil {
+			sshOpts.Copied = opts.events.copied(info.Size(), timer.copied)
+		}
+		var err error
+		if path := remote.agentPath(opts); path != "" {
+			err = agentLoad(archive, path, remote, opts, sshOpts)
+		} else {
+			err = sendAndLoad(archive, resumeName, remoteDir, decompress, remote, opts, sshOpts)
+		}
+		return timer.result(), err
+	}
+
+	var delta *deltaArchive
+	if opts.Delta {
+		deltaFile, err := siblingFile(tmpFile, "-delta.tar")
+		if err != nil {
+			return fmt.Errorf("[ERROR] Failed to create delta archive: %v", err)
+		}
+		removeDelta := func() {
+			if err := os.Remove(deltaFile); err != nil && !os.IsNotExist(err) {
+				console.Printf("[WARNING] Failed to remove delta archive %s: %v\n", deltaFile, err)
+			}
+		}
+		defer onInterrupt(removeDelta)()
+		defer removeDelta()
+		delta = prepareDelta(tmpFile, deltaFile, features, remote)
+	}
+	if delta != nil {
+		sent, err := send(delta.path, resumableArchiveName(imageName)+"-delta", delta.layers)
+		if err == nil {
+			result.Bytes = delta.size
+			result.Layers = delta.layerStats(features.Layers, sent)
+		} else {
+			// Runtimes that import archives into a content store, such as
+			// the containerd image store, need every layer file
+			console.Printf("[FALLBACK] Loading the delta failed on %s (%v), sending all layers\n", remote.host, err)
+			delta = nil
+		}
+	}
+	if delta == nil {
+		if result.Layers, err = send(tmpFile, resumableArchiveName(imageName), features.Layers); err != nil {
+			return fmt.Errorf("[ERROR] Transfer failed: %v", err)
+		}
+	}
+	if opts.Verbose {
+		printLayers(result.Layers)
 	}
 
-	fmt.Printf("[SUCCESS] Image %s successfully transferred and loaded on %s\n", imageName, host)
+	console.Printf("[SUCCESS] Image %s successfully transferred and loaded on %s\n", imageName, remote.host)
 	return nil
 }
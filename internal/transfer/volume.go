@@ -0,0 +1,164 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"remote-pull/internal/console"
+	"remote-pull/pkg/ssh"
+)
+
+// DefaultHelperImage is the image used to read and write volume contents.
+const DefaultHelperImage = "alpine:3"
+
+// VolumeOptions controls how a volume is transferred.
+type VolumeOptions struct {
+	// HelperImage runs tar against the volume locally and on the remote. It
+	// is transferred to the remote first if it is missing there.
+	HelperImage string
+	// Force restores into a volume that already exists on the remote.
+	Force bool
+}
+
+// PushVolume archives the local named volume, transfers it and restores it
+// into a volume of the same name on the remote.
+func PushVolume(ctx context.Context, volume, remoteServer string, vopts VolumeOptions, opts Options) error {
+	remote, err := resolveRemote(ctx, remoteServer, opts)
+	if err != nil {
+		return err
+	}
+	if remote.windows() {
+		return fmt.Errorf("volume transfer is only supported for linux hosts")
+	}
+	engine, err := newEngineSource()
+	if err != nil {
+		return err
+	}
+	if _, err := engine.client.VolumeInspect(ctx, volume); err != nil {
+		return fmt.Errorf("local volume %s not found: %v", volume, err)
+	}
+
+	console.Printf("[CHECKING] Verifying if volume %s exists on %s...\n", volume, remoteServer)
+	if _, err := remote.run(remote.docker("volume inspect " + remote.quote(volume))); err == nil && !vopts.Force {
+		return fmt.Errorf("volume %s already exists on %s, use --force to restore into it", volume, remoteServer)
+	}
+
+	// The helper image must be available on the remote for the restore
+	if err := TransferImage(ctx, vopts.HelperImage, remoteServer, opts); err != nil {
+		return fmt.Errorf("failed to provide helper image %s: %v", vopts.HelperImage, err)
+	}
+
+	stopWatching := remote.watchInterrupts()
+	defer stopWatching()
+
+	tmpDir := opts.LocalTmp
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	f, err := os.CreateTemp(tmpDir, "volume-"+volume+"-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %v", err)
+	}
+	tmpFile := f.Name()
+	removeLocal := func() {
+		console.Printf("[CLEANUP] Removing temporary archive %s\n", tmpFile)
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			console.Printf("[WARNING] Failed to remove temporary archive %s: %v\n", tmpFile, err)
+		}
+	}
+	defer onInterrupt(removeLocal)()
+	defer removeLocal()
+
+	// Stream the volume out of a helper container; this also works when the
+	// daemon is remote and its filesystem is not accessible
+	console.Printf("[SAVING] Archiving volume %s to %s\n", volume, tmpFile)
+	err = archiveVolume(ctx, engine, volume, vopts.HelperImage, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to archive volume %s: %v", volume, err)
+	}
+	if info, err := os.Stat(tmpFile); err == nil {
+		console.Printf("[STATUS] Archive size: %.2f MB\n", float64(info.Size())/1024/1024)
+	}
+
+	remoteDir, err := remote.tempDir()
+	if err != nil {
+		return err
+	}
+	remoteFile := remote.join(remoteDir, filepath.Base(tmpFile))
+	remote.track(remoteFile)
+	finishRemote := remote.removeArtifacts
+	if opts.KeepRemoteArchive {
+		finishRemote = remote.keepArtifacts
+	}
+	defer finishRemote()
+	defer onInterrupt(finishRemote)()
+
+	restoreCmd := strings.Join([]string{
+		remote.docker("volume create "+remote.quote(volume)) + " >/dev/null",
+		remote.docker(fmt.Sprintf("run --rm -i -v %s:/volume %s tar -C /volume -xf - < %s",
+			remote.quote(volume), remote.quote(vopts.HelperImage), remote.quote(remoteFile))),
+	}, " && ")
+	console.Printf("[TRANSFER] Starting transfer of volume %s to %s\n", volume, remote.host)
+	if err := ssh.CopyAndRun(remote.ctx, tmpFile, remote.quotePath(remoteDir), restoreCmd, remote.user, remote.host, opts.LoadTimeout, remote.sshOpts); err != nil {
+		return fmt.Errorf("[ERROR] Volume transfer failed: %v", err)
+	}
+
+	console.Printf("[SUCCESS] Volume %s successfully transferred and restored on %s\n", volume, remote.host)
+	return nil
+}
+
+// archiveVolume writes a tar archive of the contents of volume to w, from a
+// container of helperImage that mounts it read-only.
+func archiveVolume(ctx context.Context, engine *engineSource, volume, helperImage string, w io.Writer) error {
+	config := &container.Config{
+		Image:        helperImage,
+		Cmd:          []string{"tar", "-C", "/volume", "-cf", "-", "."},
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	hostConfig := &container.HostConfig{Binds: []string{volume + ":/volume:ro"}}
+	created, err := engine.client.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if cerrdefs.IsNotFound(err) {
+		if err := engine.pull(ctx, helperImage); err != nil {
+			return fmt.Errorf("failed to pull %s: %v", helperImage, err)
+		}
+		created, err = engine.client.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	}
+	if err != nil {
+		return err
+	}
+	defer engine.client.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+
+	attach, err := engine.client.ContainerAttach(ctx, created.ID, container.AttachOptions{Stream: true, Stdout: true, Stderr: true})
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+	if err := engine.client.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+	if _, err := stdcopy.StdCopy(w, console.Writer(""), attach.Reader); err != nil {
+		return err
+	}
+	waitC, errC := engine.client.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+	select {
+	case result := <-waitC:
+		if result.StatusCode != 0 {
+			return fmt.Errorf("tar exited with status %d", result.StatusCode)
+		}
+		return nil
+	case err := <-errC:
+		return err
+	}
+}
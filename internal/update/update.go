@@ -0,0 +1,232 @@
+// Package update replaces the running binary with the latest GitHub release.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// DefaultRepository is the GitHub repository releases are taken from.
+const DefaultRepository = "alexjx/remote-pull"
+
+// checksumsAsset is the release asset listing the SHA-256 of every binary.
+// It is signed with minisign, the signature is checksumsAsset+".minisig".
+const checksumsAsset = "checksums.txt"
+
+// publicKey is the minisign public key the releases are signed with, set by
+// "make release" with -ldflags "-X remote-pull/internal/update.publicKey=...".
+// Builds without it cannot verify releases and refuse to update.
+var publicKey string
+
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) assetURL(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// assetName is the name of the release binary for the running platform, as
+// built by "make release".
+func assetName() string {
+	name := fmt.Sprintf("remote-pull-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Run updates the running binary to the latest release of repo unless it
+// already is at that version. The release's checksums must carry a valid
+// signature by publicKey and the download must match them before the binary
+// is replaced. Development builds and versions newer than the release are
+// only replaced with force.
+func Run(repo, current string, force bool) error {
+	if publicKey == "" {
+		return fmt.Errorf("this build has no release signing key and cannot verify updates, install a release binary instead")
+	}
+	console.Printf("[UPDATE] Checking latest release of %s\n", repo)
+	var rel release
+	if err := getJSON("https://api.github.com/repos/"+repo+"/releases/latest", &rel); err != nil {
+		return fmt.Errorf("failed to query latest release: %v", err)
+	}
+	latest, ok := parseVersion(rel.TagName)
+	if !ok {
+		return fmt.Errorf("latest release has an invalid version %q", rel.TagName)
+	}
+	running, ok := parseVersion(current)
+	switch {
+	case !ok && !force:
+		return fmt.Errorf("this is a development build (version %s), use --force to replace it with %s", current, rel.TagName)
+	case ok && latest.compare(running) == 0:
+		console.Printf("[UPDATE] Already at the latest version %s\n", rel.TagName)
+		return nil
+	case ok && latest.compare(running) < 0 && !force:
+		return fmt.Errorf("the latest release %s is older than this version %s, use --force to downgrade", rel.TagName, current)
+	}
+
+	name := assetName()
+	binaryURL := rel.assetURL(name)
+	if binaryURL == "" {
+		return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL, signatureURL := rel.assetURL(checksumsAsset), rel.assetURL(checksumsAsset+".minisig")
+	if checksumsURL == "" || signatureURL == "" {
+		return fmt.Errorf("release %s has no signed %s, refusing to install an unverified binary", rel.TagName, checksumsAsset)
+	}
+	checksums, err := fetch(checksumsURL)
+	if err != nil {
+		return fmt.Errorf("failed to download checksums: %v", err)
+	}
+	signature, err := fetch(signatureURL)
+	if err != nil {
+		return fmt.Errorf("failed to download checksums signature: %v", err)
+	}
+	if err := verifySignature(publicKey, checksums, signature); err != nil {
+		return fmt.Errorf("%s of release %s: %v", checksumsAsset, rel.TagName, err)
+	}
+	want, err := expectedChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to locate the running binary: %v", err)
+	}
+
+	// Download next to the binary so the final rename stays on one filesystem
+	console.Printf("[UPDATE] Downloading %s %s\n", name, rel.TagName)
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".remote-pull-update-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %v", filepath.Dir(exe), err)
+	}
+	defer os.Remove(tmp.Name())
+
+	got, err := download(binaryURL, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+
+	if err := replace(exe, tmp.Name()); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", exe, err)
+	}
+	console.Printf("[UPDATE] Updated %s from %s to %s\n", exe, current, rel.TagName)
+	return nil
+}
+
+// replace moves the new binary into place. A running executable cannot be
+// overwritten on Windows, but it can be renamed out of the way.
+func replace(exe, newFile string) error {
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(newFile, exe)
+}
+
+// expectedChecksum looks up the SHA-256 of name in a sha256sum style list.
+func expectedChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read checksums: %v", err)
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", name, checksumsAsset)
+}
+
+// download writes url to w and returns the hex SHA-256 of the content.
+func download(url string, w io.Writer) (string, error) {
+	resp, err := get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetch returns the content of url, which is expected to be small.
+func fetch(url string) ([]byte, error) {
+	resp, err := get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func getJSON(url string, v any) error {
+	resp, err := get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && strings.HasPrefix(url, "https://api.github.com/") {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
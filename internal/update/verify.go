@@ -0,0 +1,101 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// verifySignature checks a minisign signature of data made with the secret
+// key of pubKey, the base64 line of a minisign public key file. Both the
+// prehashed (default) and legacy signatures are accepted, and the trusted
+// comment must be signed as well.
+func verifySignature(pubKey string, data, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pubKey))
+	if err != nil || len(key) != 42 || string(key[:2]) != "Ed" {
+		return fmt.Errorf("invalid release signing key")
+	}
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("malformed signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("malformed signature")
+	}
+	if !bytes.Equal(sig[2:10], key[2:10]) {
+		return fmt.Errorf("signed with another key")
+	}
+	message := data
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", sig[:2])
+	}
+	pub := ed25519.PublicKey(key[10:])
+	if !ed25519.Verify(pub, message, sig[10:]) {
+		return fmt.Errorf("invalid signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(pub, append(sig[10:74:74], strings.TrimPrefix(lines[2], "trusted comment: ")...), global) {
+		return fmt.Errorf("invalid signature of the trusted comment")
+	}
+	return nil
+}
+
+// version is a release version, vMAJOR.MINOR.PATCH with an optional
+// pre-release suffix.
+type version struct {
+	numbers [3]int
+	pre     string
+}
+
+func parseVersion(s string) (version, bool) {
+	var v version
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.numbers[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is older than, equal to or newer than o. A
+// pre-release is older than the release itself.
+func (v version) compare(o version) int {
+	for i := range v.numbers {
+		if v.numbers[i] != o.numbers[i] {
+			if v.numbers[i] < o.numbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	case v.pre < o.pre:
+		return -1
+	}
+	return 1
+}
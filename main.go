@@ -1,33 +1,410 @@
 package main
 
 import (
-	"flag"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/checksum"
+	"remote-pull/internal/ci"
+	"remote-pull/internal/console"
+	"remote-pull/internal/report"
 	"remote-pull/internal/transfer"
+	"remote-pull/pkg/ssh"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
-	// Define flags
-	skipPull := flag.Bool("skip-pull", false, "Skip pulling the image locally before transfer")
+	if err := newRootCmd().Execute(); err != nil {
+		console.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
 
-	// Parse flags but keep positional args
-	flag.Parse()
-	args := flag.Args()
+func newRootCmd() *cobra.Command {
+	var (
+		opts          transfer.Options
+		targets       targetFlags
+		passwordStdin bool
+		ciOpts        ci.Options
+		reportOpts    report.Options
+		eventsDest    string
+		dockerSocket  string
+		dockerHost    string
+		sshDir        string
+		auditLog      string
+		auditChain    bool
+		recordFile    string
+		composeFile   string
+		estimate      bool
+		imagesFile    string
+		hostKeyPins   []string
+	)
 
-	if len(args) != 2 {
-		fmt.Printf("Usage: %s [OPTIONS] <image> <user@host>\n\n", os.Args[0])
-		fmt.Println("Options:")
-		flag.PrintDefaults()
-		os.Exit(1)
+	cmd := &cobra.Command{
+		Use:     "remote-pull <image> <[user@]host[:port]>...",
+		Short:   "Transfer Docker images to remote hosts over SSH",
+		Example: "  remote-pull nginx:1.27 user@example.com\n  remote-pull app:1.2 worker:1.2 user@example.com\n  remote-pull app worker -- user@example.com\n  remote-pull --file images.txt user@example.com",
+		Version: version,
+		Args: func(cmd *cobra.Command, args []string) error {
+			// The images come from the compose file or --file and the
+			// hosts may come from the target selection flags
+			n := 2
+			if targets.active() {
+				n--
+			}
+			if composeFile != "" || imagesFile != "" {
+				n--
+			}
+			return cobra.MinimumNArgs(n)(cmd, args)
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		// Paths are configurable so the tool runs cleanly in a container
+		// with mounted sockets and identities and no usable $HOME
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if dockerSocket != "" {
+				if dockerHost != "" {
+					return fmt.Errorf("--docker-socket and --docker-host cannot be combined")
+				}
+				dockerHost = "unix://" + dockerSocket
+			}
+			if dockerHost != "" {
+				// Set for this process only, the Engine API client picks it up
+				if scheme, _, _ := strings.Cut(dockerHost, "://"); !slices.Contains([]string{"unix", "tcp", "ssh", "npipe"}, scheme) {
+					return fmt.Errorf("invalid --docker-host %q, expected a unix://, tcp://, ssh:// or npipe:// URL", dockerHost)
+				}
+				os.Setenv("DOCKER_HOST", dockerHost)
+			}
+			if opts.Runtime != "" && !slices.Contains(transfer.Runtimes, opts.Runtime) {
+				return fmt.Errorf("invalid --runtime %q, expected one of %s", opts.Runtime, strings.Join(transfer.Runtimes, ", "))
+			}
+			if opts.RemoteRuntime != "" && !slices.Contains(transfer.RemoteRuntimes, opts.RemoteRuntime) {
+				return fmt.Errorf("invalid --remote-runtime %q, expected one of %s", opts.RemoteRuntime, strings.Join(transfer.RemoteRuntimes, ", "))
+			}
+			if opts.StrictHostKeyChecking != "" && !slices.Contains(ssh.HostKeyChecks, opts.StrictHostKeyChecking) {
+				return fmt.Errorf("invalid --strict-host-key-checking %q, expected one of %s", opts.StrictHostKeyChecking, strings.Join(ssh.HostKeyChecks, ", "))
+			}
+			if sshDir != "" {
+				ssh.SetUserDir(sshDir)
+			}
+			// Pins without port take the one of ssh_config
+			for _, pin := range hostKeyPins {
+				target, key, ok := strings.Cut(pin, "=")
+				if !ok {
+					return fmt.Errorf("invalid --host-key %q, expected host[:port]=[TYPE ]SHA256:...", pin)
+				}
+				t, err := ssh.ParseTarget(target)
+				if err == nil && t.User != "" {
+					err = fmt.Errorf("a host key belongs to the host, give it without %q", t.User+"@")
+				}
+				if err == nil {
+					err = ssh.CheckHostKeyPin(key)
+				}
+				if err != nil {
+					return fmt.Errorf("invalid --host-key %q: %v", pin, err)
+				}
+				if opts.HostKeys == nil {
+					opts.HostKeys = map[string][]string{}
+				}
+				opts.HostKeys[t.Address()] = append(opts.HostKeys[t.Address()], key)
+			}
+			if recordFile != "" {
+				if err := ssh.SetRecording(recordFile, version); err != nil {
+					return err
+				}
+			}
+			if auditLog != "" {
+				return ssh.SetAuditLog(auditLog, auditChain)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if passwordStdin {
+				password, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read registry password: %v", err)
+				}
+				opts.Login.Password = strings.TrimRight(string(password), "\r\n")
+			}
+			reporter, err := ci.New(ciOpts)
+			if err != nil {
+				return err
+			}
+			if reporter != nil {
+				opts.Reporters = append(opts.Reporters, reporter)
+			}
+			images, hosts, err := splitArgs(cmd, args, imagesFile, composeFile != "")
+			if err != nil {
+				return err
+			}
+			// Compose and estimate runs record their failures below
+			// rather than per transferred image
+			failedFile := reportOpts.Failed
+			if composeFile != "" || estimate {
+				reportOpts.Failed = ""
+			}
+			if opts.RemotePath != "" && !opts.NoLoad {
+				return fmt.Errorf("--remote-path requires --no-load")
+			}
+			if !slices.Contains(transfer.Compressions, opts.Compress) {
+				return fmt.Errorf("invalid --compress %q, expected one of %s", opts.Compress, strings.Join(transfer.Compressions, ", "))
+			}
+			if opts.CompressLevel != 0 {
+				maxLevel, ok := map[string]int{transfer.CompressGzip: 9, transfer.CompressZstd: 22}[opts.Compress]
+				if !ok {
+					return fmt.Errorf("--compress-level requires --compress gzip or zstd")
+				}
+				if opts.CompressLevel < 1 || opts.CompressLevel > maxLevel {
+					return fmt.Errorf("invalid --compress-level %d for %s, expected 1 to %d", opts.CompressLevel, opts.Compress, maxLevel)
+				}
+			}
+			if opts.Compress != transfer.CompressNone && (opts.Agent || opts.RemoteHelper != "") {
+				return fmt.Errorf("--compress cannot be combined with --agent or --remote-helper")
+			}
+			if !slices.Contains(transfer.Strategies, opts.Strategy) {
+				return fmt.Errorf("invalid --strategy %q, expected one of %s", opts.Strategy, strings.Join(transfer.Strategies, ", "))
+			}
+			if opts.Pipe && (opts.KeepRemoteArchive || opts.RemoteHelper != "" || opts.Delta || (opts.Strategy != transfer.StrategyAuto && opts.Strategy != ssh.StrategyStream)) {
+				return fmt.Errorf("--pipe cannot be combined with --keep-remote-archive, --remote-helper, --delta or a --strategy other than stream")
+			}
+			if !slices.Contains(checksum.Algorithms, opts.Checksum) {
+				return fmt.Errorf("invalid --checksum %q, expected one of %s", opts.Checksum, strings.Join(checksum.Algorithms, ", "))
+			}
+			if opts.FIPS && !checksum.Approved(opts.Checksum) {
+				return fmt.Errorf("--checksum %s is not FIPS-approved, use sha256 or sha512 with --fips", opts.Checksum)
+			}
+			if opts.Agent && (opts.Pipe || opts.RemoteHelper != "" || opts.KeepRemoteArchive || opts.NoLoad) {
+				return fmt.Errorf("--agent cannot be combined with --pipe, --remote-helper, --keep-remote-archive or --no-load")
+			}
+			if opts.AgentCache < 0 || (opts.AgentCache > 0 && !opts.Agent) {
+				return fmt.Errorf("--agent-cache requires --agent and must not be negative")
+			}
+			if opts.RemoteHelper != "" && (opts.NoLoad || opts.Delta || opts.Retain || opts.PostLoad.Command != "" || opts.HealthCheck.Command != "" || len(opts.Login.Registries) > 0 || estimate || composeFile != "") {
+				return fmt.Errorf("--remote-helper cannot be combined with --no-load, --delta, --retain, --post-cmd, --healthcheck, --remote-login, --estimate or --deploy-compose")
+			}
+			if (opts.Metadata.Tags || len(opts.Metadata.Labels) > 0 || opts.Metadata.DeployLabels) && (opts.NoLoad || opts.RemoteHelper != "") {
+				return fmt.Errorf("--propagate-tags, --label and --deploy-labels cannot be combined with --no-load or --remote-helper")
+			}
+			if opts.Resume && (opts.Pipe || opts.Agent || opts.NoLoad || opts.RemoteHelper != "" || opts.Strategy == ssh.StrategyStream) {
+				return fmt.Errorf("--resume needs an archive written on the remote and cannot be combined with --pipe, --agent, --no-load, --remote-helper or --strategy stream")
+			}
+			if opts.RestartContainers && (opts.NoLoad || opts.RemoteHelper != "") {
+				return fmt.Errorf("--restart-containers-using-image cannot be combined with --no-load or --remote-helper")
+			}
+			if opts.RemoteRuntime == transfer.RuntimeContainerd && (opts.Metadata.Tags || len(opts.Metadata.Labels) > 0 || opts.Metadata.DeployLabels || opts.RestartContainers || opts.Retain || len(opts.Login.Registries) > 0) {
+				return fmt.Errorf("--remote-runtime containerd cannot be combined with --propagate-tags, --label, --deploy-labels, --restart-containers-using-image, --retain or --remote-login")
+			}
+			if !slices.Contains(transfer.UnreachableModes, opts.Unreachable) {
+				return fmt.Errorf("invalid --unreachable %q, expected one of %s", opts.Unreachable, strings.Join(transfer.UnreachableModes, ", "))
+			}
+			if opts.Unreachable != transfer.UnreachableWait && opts.ProbeTimeout <= 0 {
+				return fmt.Errorf("--probe-timeout must be positive")
+			}
+			if opts.RetainVersions < 0 {
+				return fmt.Errorf("--retain-versions must not be negative")
+			}
+			if opts.Parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+			if opts.HealthCheck.Command != "" && opts.HealthCheck.Interval <= 0 {
+				return fmt.Errorf("--healthcheck-interval must be positive")
+			}
+			if estimate && composeFile != "" {
+				return fmt.Errorf("--estimate cannot be combined with --deploy-compose")
+			}
+			if imagesFile != "" && composeFile != "" {
+				return fmt.Errorf("--file cannot be combined with --deploy-compose")
+			}
+			if opts.BandwidthLimit < 0 {
+				return fmt.Errorf("--bandwidth-limit must not be negative")
+			}
+			if opts.ParallelImages < 1 {
+				return fmt.Errorf("--parallel-images must be at least 1")
+			}
+			if opts.NoLoad && composeFile != "" {
+				return fmt.Errorf("--no-load cannot be combined with --deploy-compose")
+			}
+			if eventsDest != "" {
+				reporter, err := report.NewEvents(eventsDest)
+				if err != nil {
+					return err
+				}
+				opts.Reporters = append(opts.Reporters, reporter)
+			}
+			if targets.active() {
+				resolved, err := targets.resolve()
+				if err != nil {
+					return err
+				}
+				hosts = append(slices.Clip(hosts), resolved...)
+			}
+			if err := checkHostKeyPins(opts.HostKeys, hosts); err != nil {
+				return err
+			}
+			if opts.HostKeys == nil {
+				opts.HostKeys = map[string][]string{}
+			}
+			opts.BandwidthWeights = targets.weights
+			// retry-failed does not read the inventory again, and takes
+			// the images from the record rather than --file
+			reportOpts.FailedArgs = append(retryArgs(cmd, append(targets.names(), "failed-file", "events", "file")), targets.pinHostKeys(opts.HostKeys)...)
+			opts.Reporters = append(opts.Reporters, report.New(reportOpts)...)
+			if len(hosts) == 0 && len(images) > 1 && cmd.ArgsLenAtDash() < 0 {
+				return fmt.Errorf("no hosts given; arguments such as redis:7 are taken as images, give the hosts as user@host[:port] or after \"--\"")
+			} else if len(hosts) == 0 {
+				return fmt.Errorf("no hosts given")
+			}
+			if composeFile != "" {
+				err := transfer.DeployCompose(cmd.Context(), composeFile, hosts, opts)
+				writeFailed(failedFile, report.Failed{Hosts: failedHosts(err, hosts), Args: reportOpts.FailedArgs})
+				return err
+			}
+			if estimate {
+				failed := report.Failed{Args: reportOpts.FailedArgs}
+				var errs []error
+				for _, image := range images {
+					err := transfer.EstimateTargets(cmd.Context(), image, hosts, opts)
+					if err != nil {
+						console.Printf("[FAILED] %s: %v\n", image, err)
+						errs = append(errs, err)
+					}
+					for _, host := range failedHosts(err, hosts) {
+						failed.Add(image, host)
+					}
+				}
+				writeFailed(failedFile, failed)
+				if len(errs) == 1 && len(images) == 1 {
+					return errs[0]
+				} else if len(errs) > 0 {
+					return fmt.Errorf("estimate failed for %d of %d images", len(errs), len(images))
+				}
+				return nil
+			}
+			return transfer.TransferImages(cmd.Context(), images, hosts, opts)
+		},
 	}
 
-	imageName := args[0]
-	remoteServer := args[1]
+	// Connection flags are shared by all subcommands
+	pflags := cmd.PersistentFlags()
+	pflags.StringVar(&dockerSocket, "docker-socket", "", "Local Docker daemon socket (default $DOCKER_HOST or /var/run/docker.sock)")
+	pflags.StringVar(&dockerHost, "docker-host", "", "Local Docker daemon to read images from, e.g. unix:///run/user/1000/docker.sock, tcp://builder:2375 or ssh://user@builder (default $DOCKER_HOST or the current docker context)")
+	pflags.StringVar(&sshDir, "ssh-dir", os.Getenv("REMOTE_PULL_SSH_DIR"), "Directory with ssh config, keys and known_hosts (default ~/.ssh)")
+	pflags.StringVar(&auditLog, "audit-log", os.Getenv("REMOTE_PULL_AUDIT_LOG"), "Append a record of every remote command to this file")
+	pflags.BoolVar(&auditChain, "audit-chain", false, "Hash-chain the audit log records to make tampering detectable")
+	pflags.StringVar(&recordFile, "record", "", "Record connections and remote commands with their output to this file for bug reports")
+	pflags.StringVar(&opts.RemoteOS, "remote-os", transfer.OSLinux, "Operating system of the remote host (linux, darwin or windows; macOS is also detected)")
+	pflags.StringVar(&opts.RemoteDocker, "remote-docker", "", "Command invoking docker on the remote (default detected)")
+	pflags.StringVar(&opts.Runtime, "runtime", "", "Container runtime used locally and on the remote: docker or podman (default docker, podman where docker is missing)")
+	pflags.StringVar(&opts.RemoteRuntime, "remote-runtime", "", "Container runtime on the remote: docker, podman or containerd (default --runtime)")
+	pflags.StringVar(&opts.Namespace, "namespace", transfer.DefaultNamespace, "containerd namespace images are loaded into with --remote-runtime containerd")
+	pflags.BoolVar(&opts.FIPS, "fips", false, "Only use FIPS-approved SSH algorithms, keys and checksums")
+	pflags.StringVar(&opts.StrictHostKeyChecking, "strict-host-key-checking", "", "Hosts without known_hosts entry: yes refuses, no accepts, accept-new records, ask confirms and records (default from ssh_config, else ask)")
+	pflags.StringArrayVar(&hostKeyPins, "host-key", nil, "Pin the host key of a host as host[:port]=[TYPE ]SHA256:<fingerprint> instead of using known_hosts (repeatable)")
+	pflags.BoolVar(&opts.UpdateHostKey, "update-host-key", false, "Replace a changed host key in ~/.ssh/known_hosts instead of aborting")
+	pflags.StringVar(&opts.Transport.Name, "transport", ssh.TransportSSH, "How to reach the SSH server (ssh, teleport, ssm, iap, bastion, tailscale or cloudflare)")
+	pflags.StringVarP(&opts.Transport.Jump, "jump", "J", "", "Connect through these jump hosts ([user@]host[:port], comma-separated) instead of ssh_config's ProxyJump")
+	pflags.StringVar(&opts.Transport.TeleportCluster, "teleport-cluster", "", "Teleport cluster to connect through (default from the tsh profile)")
+	pflags.StringVar(&opts.Transport.InstanceID, "instance-id", "", "EC2 instance reached through SSM (default the host argument)")
+	pflags.StringVar(&opts.Transport.GCPProject, "project", "", "GCP project of the instance reached through IAP")
+	pflags.StringVar(&opts.Transport.GCPZone, "zone", "", "GCP zone of the instance reached through IAP")
+	pflags.StringVar(&opts.Transport.GCPInstance, "instance", "", "GCE instance reached through IAP (default the host argument)")
+	pflags.StringVar(&opts.Transport.BastionName, "bastion-name", "", "Azure Bastion host to tunnel through")
+	pflags.StringVar(&opts.Transport.BastionResourceGroup, "bastion-resource-group", "", "Resource group of the Azure Bastion host")
+	pflags.StringVar(&opts.Transport.AzureVMID, "azure-vm-id", "", "Resource ID of the Azure VM reached through Bastion")
+	pflags.StringVar(&opts.Vault.Role, "vault-ssh-role", "", "Authenticate with a certificate signed by this Vault SSH role")
+	pflags.StringVar(&opts.Vault.Mount, "vault-ssh-mount", "ssh", "Mount path of Vault's SSH secrets engine")
+	pflags.BoolVar(&opts.Secrets.Keychain, "keychain", false, "Read key passphrases and passwords from the OS keychain")
+	pflags.StringVar(&opts.Secrets.Command, "secret-command", "", "Command printing key passphrases and passwords (account in $REMOTE_PULL_SECRET_ACCOUNT)")
+	pflags.BoolVar(&opts.Secrets.Batch, "batch", false, "Never prompt for key passphrases, passwords or host keys")
+	pflags.DurationVar(&opts.ConnectTimeout, "connect-timeout", 0, "Maximum duration of each SSH connection attempt up to the key exchange (default ssh_config's ConnectTimeout, or no limit)")
+	pflags.StringSliceVar(&opts.AllowedRegistries, "allow-registry", envList("REMOTE_PULL_ALLOWED_REGISTRIES"), "Only transfer images from this registry or namespace, e.g. registry.corp/* (repeatable)")
+	pflags.StringVar(&opts.Policy.Rego, "policy-rego", "", "Authorize transfers with this Rego policy (package remote_pull, evaluated with opa)")
+	pflags.StringVar(&opts.Policy.URL, "policy-url", "", "Authorize transfers with this policy webhook, e.g. OPA's /v1/data/remote_pull")
 
-	if err := transfer.TransferImage(imageName, remoteServer, *skipPull); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+	flags := cmd.Flags()
+	flags.BoolVar(&opts.SkipPull, "skip-pull", false, "Skip pulling the image locally before transfer")
+	flags.BoolVar(&opts.KeepRemoteArchive, "keep-remote-archive", false, "Keep the transferred archive on the remote host (for debugging)")
+	flags.BoolVar(&opts.Resume, "resume", false, "Keep an interrupted upload on the remote and continue it on the next run")
+	flags.StringVar(&opts.LocalTmp, "local-tmp", "", "Local directory for the temporary image archive (default $TMPDIR or /tmp)")
+	flags.DurationVar(&opts.LoadTimeout, "load-timeout", time.Hour, "Maximum duration of the remote docker load (0 for no limit)")
+	flags.DurationVar(&opts.TransferTimeout, "transfer-timeout", 0, "Maximum duration of the transfer to each host, checks and load included (0 for no limit)")
+	flags.StringVar(&opts.Strategy, "strategy", transfer.StrategyAuto, "How the image gets to the remote: auto, stream, sftp, scp or shell")
+	flags.BoolVar(&opts.Pipe, "pipe", false, "Pipe the local export straight into the remote docker load, without a temporary archive")
+	flags.BoolVar(&opts.Delta, "delta", false, "Send only the image layers the remote does not have yet")
+	flags.BoolVar(&opts.Agent, "agent", false, "Install a helper agent on the remote that verifies and loads the archive")
+	flags.StringVar(&opts.Checksum, "checksum", checksum.SHA256, "Checksum algorithm the agent verifies transfers with: "+strings.Join(checksum.Algorithms, ", "))
+	flags.Int64Var(&opts.AgentCache, "agent-cache", 0, "Keep loaded archives up to this many MB in the agent's cache on the remote (0 disables)")
+	flags.StringVar(&opts.RemoteHelper, "remote-helper", "", "Pre-installed remote command that is the only thing run on restricted accounts")
+	flags.StringVar(&opts.PostLoad.Command, "post-cmd", "", "Remote command run after a successful load, a template with {{.Image}}, {{.Tag}}, {{.Digest}} etc.")
+	flags.StringArrayVar(&opts.PostLoad.Vars, "post-var", nil, "Additional KEY=VALUE variable for --post-cmd (repeatable)")
+	flags.StringVar(&opts.HealthCheck.Command, "healthcheck", "", "Remote command that must succeed after the load and --post-cmd, retried until --healthcheck-timeout")
+	flags.DurationVar(&opts.HealthCheck.Interval, "healthcheck-interval", 5*time.Second, "Delay between health check attempts")
+	flags.DurationVar(&opts.HealthCheck.Timeout, "healthcheck-timeout", 2*time.Minute, "Time the health check may take to pass")
+	flags.BoolVar(&opts.Retain, "retain", false, "Tag the image as <repository>:current and keep replaced versions as :prev1, :prev2, ... for rollback")
+	flags.BoolVar(&opts.Metadata.Tags, "propagate-tags", false, "Also apply the other local tags of the image on the remote")
+	flags.StringArrayVar(&opts.Metadata.Labels, "label", nil, "Add this KEY=VALUE label to the image on the remote after loading it (repeatable)")
+	flags.BoolVar(&opts.Metadata.DeployLabels, "deploy-labels", false, "Label the image on the remote with the deploy time and the local git revision")
+	flags.BoolVar(&opts.RestartContainers, "restart-containers-using-image", false, "Recreate the running containers of previous versions of the image with the transferred one")
+	flags.IntVar(&opts.RetainVersions, "retain-versions", 1, "Number of replaced versions kept with --retain (prev1, prev2, ...); older ones are removed")
+	flags.IntVar(&opts.Parallel, "parallel", 4, "Number of hosts transferred to at the same time")
+	flags.Float64Var(&opts.BandwidthLimit, "bandwidth-limit", 0, "Limit the combined upload rate of all hosts to this many MB/s, shared fairly or by inventory weight (0 disables)")
+	flags.IntVar(&opts.ParallelImages, "parallel-images", 1, "Number of images transferred at the same time")
+	flags.StringVar(&imagesFile, "file", "", "Transfer the images listed in this file, one per line; all arguments are hosts")
+	flags.IntVar(&opts.Canary, "canary", 0, "Transfer to this many hosts first and only continue if they all succeed")
+	flags.DurationVar(&opts.CanaryWait, "canary-wait", 0, "Time to wait after the canary hosts succeeded before continuing")
+	flags.StringVar(&opts.Unreachable, "unreachable", transfer.UnreachableWait, "With several hosts, probe them first and skip or defer the unreachable ones: wait, skip or defer")
+	flags.DurationVar(&opts.ProbeTimeout, "probe-timeout", 5*time.Second, "Time a host may take to answer the probe of --unreachable")
+	flags.BoolVar(&opts.NoLoad, "no-load", false, "Deliver the archive as a file on the remote instead of loading it")
+	flags.StringVar(&opts.RemotePath, "remote-path", "", "Remote destination of the archive with --no-load (default <name>_<tag>.tar in the home directory)")
+	flags.StringVar(&opts.Compress, "compress", transfer.CompressNone, "Compress the archive on its way to the remote, or the file delivered with --no-load: none, gzip or zstd (--compress alone means gzip)")
+	flags.Lookup("compress").NoOptDefVal = transfer.CompressGzip
+	flags.IntVar(&opts.CompressLevel, "compress-level", 0, "Level of --compress, 1-9 for gzip and 1-22 for zstd (default of the algorithm)")
+	flags.BoolVar(&estimate, "estimate", false, "Report how much data would be transferred to each host without sending anything")
+	flags.StringVar(&composeFile, "deploy-compose", "", "Transfer the images of this compose file and start the stack on the remote")
+	flags.StringVar(&ciOpts.System, "ci", "", "Integrate output with a CI system (github or gitlab)")
+	flags.StringVar(&ciOpts.Dotenv, "ci-dotenv", "remote-pull.env", "File the GitLab results are written to as dotenv report")
+	flags.StringVar(&reportOpts.JSON, "json-report", "", "Write the results, including per-layer sizes and times, to this JSON file")
+	flags.StringVar(&reportOpts.Metrics, "metrics-file", "", "Write Prometheus metrics to this file (node_exporter textfile format)")
+	flags.StringVar(&eventsDest, "events", "", "Stream JSON events of the run live to fd:N or unix:PATH")
+	flags.StringVar(&reportOpts.Failed, "failed-file", defaultFailedFile, "Record the hosts that failed in this file for retry-failed (empty disables)")
+	flags.BoolVarP(&opts.Verbose, "verbose", "v", false, "Print a per-layer breakdown of the transfer")
+	flags.StringSliceVar(&opts.Login.Registries, "remote-login", nil, "Run docker login for this registry on the remote (repeatable)")
+	flags.StringVar(&opts.Login.Username, "registry-username", "", "Registry user for --remote-login (default from the local docker config)")
+	flags.BoolVar(&passwordStdin, "registry-password-stdin", false, "Read the registry password for --remote-login from stdin")
+
+	targets.register(flags)
+
+	cmd.AddCommand(newPreflightCmd(&opts))
+	cmd.AddCommand(newDoctorCmd(&opts))
+	cmd.AddCommand(newFetchCmd(&opts))
+	cmd.AddCommand(newCopyCmd(&opts))
+	cmd.AddCommand(newInspectSizeCmd(&opts))
+	cmd.AddCommand(newVolumeCmd(&opts))
+	cmd.AddCommand(newContainerCmd(&opts))
+	cmd.AddCommand(newBuildRemoteCmd(&opts))
+	cmd.AddCommand(newBuilderCmd(&opts))
+	cmd.AddCommand(newSIFCmd(&opts))
+	cmd.AddCommand(newRollbackCmd(&opts))
+	cmd.AddCommand(newRetryFailedCmd())
+	cmd.AddCommand(newFleetReportCmd(&opts))
+	cmd.AddCommand(newAuditCmd())
+	cmd.AddCommand(newSelfUpdateCmd())
+	cmd.AddCommand(newAgentCmd())
+	return cmd
+}
+
+// envList splits a comma-separated environment variable into a flag default.
+func envList(name string) []string {
+	if v := os.Getenv(name); v != "" {
+		return strings.Split(v, ",")
 	}
+	return nil
 }
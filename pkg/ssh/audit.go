@@ -0,0 +1,188 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"remote-pull/internal/console"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	LocalUser string    `json:"local_user"`
+	Host      string    `json:"host"`
+	Command   string    `json:"command"`
+	// DurationMS is the run time of the command in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+	// ExitStatus is -1 when the command did not exit normally.
+	ExitStatus int    `json:"exit_status"`
+	Error      string `json:"error,omitempty"`
+	// Prev and Hash chain the records: Hash covers Prev and the record, so
+	// any edit or removal breaks the chain from there on.
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// hash returns the chain hash of r, computed with the Hash field cleared.
+func (r auditRecord) hash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+var auditLog struct {
+	sync.Mutex
+	file  *os.File
+	chain bool
+}
+
+// SetAuditLog appends a record of every remote command to path. The file is
+// only ever appended to; with chain set every record carries a hash linking
+// it to its predecessor so tampering can be detected with VerifyAuditLog.
+// Every record is appended under a file lock, so several runs can share the
+// log.
+func SetAuditLog(path string, chain bool) error {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	if chain {
+		// Refuse a corrupt log before running anything
+		if _, err := lastAuditHash(f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	auditLog.file, auditLog.chain = f, chain
+	return nil
+}
+
+// lastAuditHash returns the hash of the last record in the audit log f, or
+// "" for an empty log. Only the end of the file is read.
+func lastAuditHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log: %v", err)
+	}
+	size := info.Size()
+	for chunk := int64(4096); ; chunk *= 2 {
+		offset := max(size-chunk, 0)
+		tail := make([]byte, size-offset)
+		if _, err := f.ReadAt(tail, offset); err != nil {
+			return "", fmt.Errorf("failed to read audit log: %v", err)
+		}
+		tail = bytes.TrimSpace(tail)
+		i := bytes.LastIndexByte(tail, '\n')
+		if i < 0 && offset > 0 {
+			// The last line starts before the chunk
+			continue
+		}
+		line := tail[i+1:]
+		if len(line) == 0 {
+			return "", nil
+		}
+		var r auditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return "", fmt.Errorf("audit log %s is corrupt: %v", f.Name(), err)
+		}
+		return r.Hash, nil
+	}
+}
+
+// audit records cmd run through c. Failures to write the log are reported
+// but do not fail the command.
+func audit(c *Client, cmd string, start time.Time, err error) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.file == nil {
+		return
+	}
+
+	r := auditRecord{
+		Time:       start.UTC(),
+		LocalUser:  localUser(),
+		Host:       c.target.String(),
+		Command:    console.Redacted(cmd),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		r.ExitStatus = exitErr.ExitStatus()
+	default:
+		r.ExitStatus = -1
+		r.Error = console.Redacted(err.Error())
+	}
+	if err := appendAudit(r); err != nil {
+		console.Printf("[WARNING] Failed to write audit log: %v\n", err)
+	}
+}
+
+// appendAudit appends r to the audit log, chained to the record last
+// written by any process.
+func appendAudit(r auditRecord) error {
+	unlock, err := lockAuditLog(auditLog.file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if auditLog.chain {
+		if r.Prev, err = lastAuditHash(auditLog.file); err != nil {
+			return err
+		}
+		r.Hash = r.hash()
+	}
+	data, _ := json.Marshal(r)
+	_, err = auditLog.file.Write(append(data, '\n'))
+	return err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path and returns
+// the number of records verified. Records written without chaining are
+// reported as errors.
+func VerifyAuditLog(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	prev := ""
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		n++
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return n - 1, fmt.Errorf("line %d: %v", n, err)
+		}
+		if r.Hash == "" {
+			return n - 1, fmt.Errorf("line %d: record is not chained", n)
+		}
+		if r.Prev != prev {
+			return n - 1, fmt.Errorf("line %d: chain broken, a record before it was removed or altered", n)
+		}
+		if r.hash() != r.Hash {
+			return n - 1, fmt.Errorf("line %d: record was altered", n)
+		}
+		prev = r.Hash
+	}
+	return n, scanner.Err()
+}
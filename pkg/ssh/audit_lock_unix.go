@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockAuditLog takes an exclusive lock on the audit log f, waiting for
+// other processes appending to it, and returns the function releasing it.
+func lockAuditLog(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package ssh
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockAuditLog takes an exclusive lock on the audit log f, waiting for
+// other processes appending to it, and returns the function releasing it.
+func lockAuditLog(f *os.File) (func(), error) {
+	h := windows.Handle(f.Fd())
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(h, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol); err != nil {
+		return nil, err
+	}
+	return func() { windows.UnlockFileEx(h, 0, 1, 0, ol) }, nil
+}
@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAuditChainSharedLog appends to one log through two files, as two runs
+// sharing the log do, and checks that the chain stays intact.
+func TestAuditChainSharedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := SetAuditLog(path, true); err != nil {
+		t.Fatal(err)
+	}
+	first := auditLog.file
+	if err := SetAuditLog(path, true); err != nil {
+		t.Fatal(err)
+	}
+	second := auditLog.file
+	t.Cleanup(func() {
+		first.Close()
+		second.Close()
+		auditLog.file = nil
+	})
+
+	for i := 0; i < 20; i++ {
+		auditLog.file = first
+		if i%3 == 0 {
+			auditLog.file = second
+		}
+		// Long commands make records span the chunks read from the end
+		command := fmt.Sprintf("docker load %d %s", i, strings.Repeat("x", i*500))
+		if err := appendAudit(auditRecord{Host: "user@example.com", Command: command}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := VerifyAuditLog(path)
+	if err != nil || n != 20 {
+		t.Fatalf("VerifyAuditLog = %d, %v, want 20 records", n, err)
+	}
+}
+
+func TestAuditLogCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("{\"host\":\"a\"}\nnot json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAuditLog(path, true); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+		t.Fatalf("SetAuditLog = %v, want a corrupt log error", err)
+	}
+}
@@ -0,0 +1,75 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth limits the combined rate of the uploads sharing it, e.g. the
+// transfers to several hosts over one uplink. The rate is divided between
+// the uploads in progress by their weights, so a fast host cannot take the
+// whole link; when an upload ends the others get its share.
+type Bandwidth struct {
+	rate float64 // bytes per second
+
+	mu sync.Mutex
+	// weight is the sum of the weights of the uploads in progress.
+	weight float64
+}
+
+// NewBandwidth returns a limit of rate bytes per second.
+func NewBandwidth(rate float64) *Bandwidth {
+	return &Bandwidth{rate: rate}
+}
+
+// bandwidthFlow is one upload within a Bandwidth.
+type bandwidthFlow struct {
+	b      *Bandwidth
+	weight float64
+	// next is when the flow may send again.
+	next time.Time
+}
+
+// join starts an upload with weight, 1 when not positive.
+func (b *Bandwidth) join(weight float64) *bandwidthFlow {
+	if weight <= 0 {
+		weight = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weight += weight
+	return &bandwidthFlow{b: b, weight: weight}
+}
+
+func (f *bandwidthFlow) leave() {
+	f.b.mu.Lock()
+	defer f.b.mu.Unlock()
+	f.b.weight -= f.weight
+}
+
+// wait paces the flow after n bytes were sent, at its current share of the
+// rate. Time spent idle is not saved up for bursts.
+func (f *bandwidthFlow) wait(n int) {
+	f.b.mu.Lock()
+	share := f.b.rate * f.weight / f.b.weight
+	f.b.mu.Unlock()
+	now := time.Now()
+	if f.next.Before(now) {
+		f.next = now
+	}
+	f.next = f.next.Add(time.Duration(float64(n) / share * float64(time.Second)))
+	time.Sleep(time.Until(f.next))
+}
+
+// limitedWriter paces the writes to w by flow.
+type limitedWriter struct {
+	w    io.Writer
+	flow *bandwidthFlow
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	n, err := l.w.Write(b)
+	l.flow.wait(n)
+	return n, err
+}
@@ -0,0 +1,434 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sshConfig holds the effective ssh_config settings for one host.
+type sshConfig struct {
+	HostName      string
+	User          string
+	Port          string
+	IdentityFiles []string
+
+	// options holds the first value obtained for every other keyword,
+	// keyed by the lower-cased keyword.
+	options map[string]string
+}
+
+// option returns the effective value of an arbitrary ssh_config keyword.
+func (c *sshConfig) option(key string) string {
+	return c.options[strings.ToLower(key)]
+}
+
+func userConfigFile() string {
+	return userPath("config")
+}
+
+const systemConfigFile = "/etc/ssh/ssh_config"
+
+// parseSSHConfig evaluates the user and system ssh_config files for host
+// with OpenSSH semantics: for each keyword the first obtained value wins
+// (IdentityFile accumulates), Host lines accept several patterns with
+// wildcards and negation, Match supports the all/host/originalhost/user/
+// localuser criteria, values may be quoted or separated by '=', Include is
+// followed and %-tokens are expanded.
+func parseSSHConfig(host, user string) (*sshConfig, error) {
+	p := &configParser{
+		host:   host,
+		user:   user,
+		config: &sshConfig{options: map[string]string{}},
+	}
+	for _, file := range []string{userConfigFile(), systemConfigFile} {
+		if err := p.parseFile(file, 0); err != nil {
+			return nil, err
+		}
+	}
+	p.config.expandTokens(host, user)
+	return p.config, nil
+}
+
+const maxIncludeDepth = 16
+
+type configParser struct {
+	host   string
+	user   string
+	config *sshConfig
+
+	// aliases collects the literal (non-wildcard) Host patterns seen
+	aliases []string
+}
+
+// configAliases lists the host aliases declared in the ssh_config files.
+func configAliases() ([]string, error) {
+	p := &configParser{config: &sshConfig{options: map[string]string{}}}
+	for _, file := range []string{userConfigFile(), systemConfigFile} {
+		if err := p.parseFile(file, 0); err != nil {
+			return nil, err
+		}
+	}
+	return p.aliases, nil
+}
+
+// IsConfigAlias reports whether host is declared as a host alias in the
+// ssh_config files.
+func IsConfigAlias(host string) bool {
+	aliases, err := configAliases()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(aliases, func(alias string) bool { return strings.EqualFold(alias, host) })
+}
+
+func (p *configParser) parseFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", path)
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Settings before the first Host/Match line apply to every host
+	active := true
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			for _, pattern := range args {
+				if !strings.ContainsAny(pattern, "*?!") {
+					p.aliases = append(p.aliases, pattern)
+				}
+			}
+			active = matchHostPatterns(p.host, args)
+			continue
+		case "match":
+			active, err = p.evalMatch(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+			}
+			continue
+		}
+
+		if !active {
+			continue
+		}
+		if key == "include" {
+			for _, pattern := range args {
+				if err := p.include(path, pattern, depth); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: missing argument for %s", path, lineNo, key)
+		}
+		p.config.set(key, args)
+	}
+	return scanner.Err()
+}
+
+func (p *configParser) include(from, pattern string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		// Relative includes are resolved against ~/.ssh for user files and
+		// /etc/ssh for system files
+		base := filepath.Dir(userConfigFile())
+		if from == systemConfigFile || strings.HasPrefix(from, filepath.Dir(systemConfigFile)+"/") {
+			base = filepath.Dir(systemConfigFile)
+		}
+		pattern = filepath.Join(base, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("%s: bad Include pattern %q: %v", from, pattern, err)
+	}
+	for _, match := range matches {
+		if err := p.parseFile(match, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *configParser) evalMatch(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("Match requires at least one criterion")
+	}
+	result := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var matched bool
+		switch criterion {
+		case "all":
+			matched = true
+		case "host", "originalhost", "user", "localuser":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("Match %s requires an argument", criterion)
+			}
+			i++
+			patterns := strings.Split(args[i], ",")
+			switch criterion {
+			case "host":
+				host := p.host
+				if p.config.HostName != "" {
+					host = expandPercent(p.config.HostName, map[byte]string{'h': p.host})
+				}
+				matched = matchHostPatterns(host, patterns)
+			case "originalhost":
+				matched = matchHostPatterns(p.host, patterns)
+			case "user":
+				matched = matchPatternList(p.effectiveUser(), patterns)
+			case "localuser":
+				matched = matchPatternList(localUser(), patterns)
+			}
+		case "canonical", "final":
+			// Canonicalization is not performed, so these never match
+			matched = false
+		default:
+			// exec, tagged and other criteria are not supported; treat the
+			// block as not applicable rather than guessing
+			if i+1 < len(args) {
+				i++
+			}
+			matched = false
+		}
+		if negate {
+			matched = !matched
+		}
+		result = result && matched
+	}
+	return result, nil
+}
+
+func (p *configParser) effectiveUser() string {
+	if p.user != "" {
+		return p.user
+	}
+	if p.config.User != "" {
+		return p.config.User
+	}
+	return localUser()
+}
+
+// set records a keyword unless a value was already obtained for it.
+func (c *sshConfig) set(key string, args []string) {
+	value := strings.Join(args, " ")
+	switch key {
+	case "hostname":
+		if c.HostName == "" {
+			c.HostName = value
+		}
+	case "user":
+		if c.User == "" {
+			c.User = value
+		}
+	case "port":
+		if c.Port == "" {
+			c.Port = value
+		}
+	case "identityfile":
+		c.IdentityFiles = append(c.IdentityFiles, value)
+	default:
+		if _, ok := c.options[key]; !ok {
+			c.options[key] = value
+		}
+	}
+}
+
+// expandTokens applies the %-tokens and ~ expansion supported by OpenSSH for
+// the keywords that accept them.
+func (c *sshConfig) expandTokens(originalHost, user string) {
+	c.HostName = expandPercent(c.HostName, map[byte]string{'h': originalHost})
+
+	host := originalHost
+	if c.HostName != "" {
+		host = c.HostName
+	}
+	if user == "" {
+		user = c.User
+	}
+	if user == "" {
+		user = localUser()
+	}
+	port := c.Port
+	if port == "" {
+		port = "22"
+	}
+	tokens := map[byte]string{
+		'h': host,
+		'n': originalHost,
+		'p': port,
+		'r': user,
+		'u': localUser(),
+		'd': homeDir(),
+	}
+	for i, f := range c.IdentityFiles {
+		c.IdentityFiles[i] = expandHome(expandPercent(f, tokens))
+	}
+}
+
+// splitConfigLine splits a config line into its lower-cased keyword and
+// arguments. Keyword and arguments may be separated by whitespace and/or a
+// single '='; arguments may be double-quoted to contain spaces.
+func splitConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var args []string
+	for rest != "" {
+		if strings.HasPrefix(rest, "#") {
+			break
+		}
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote in %q", line)
+			}
+			args = append(args, rest[1:closing+1])
+			rest = strings.TrimLeft(rest[closing+2:], " \t")
+			continue
+		}
+		next := strings.IndexAny(rest, " \t")
+		if next < 0 {
+			args = append(args, rest)
+			break
+		}
+		args = append(args, rest[:next])
+		rest = strings.TrimLeft(rest[next:], " \t")
+	}
+	return key, args, nil
+}
+
+// matchHostPatterns reports whether host matches the Host pattern list: at
+// least one positive pattern must match and no negated pattern may match.
+func matchHostPatterns(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if wildcardMatch(negated, host) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+func matchPatternList(s string, patterns []string) bool {
+	return matchHostPatterns(s, patterns)
+}
+
+// wildcardMatch implements the ssh_config pattern syntax, where '*' matches
+// any sequence and '?' a single character.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for pattern != "" && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return s == ""
+}
+
+func expandPercent(s string, tokens map[byte]string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if s[i] == '%' {
+			b.WriteByte('%')
+		} else if v, ok := tokens[s[i]]; ok {
+			b.WriteString(v)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func expandHome(p string) string {
+	if p == "~" {
+		return homeDir()
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(homeDir(), p[2:])
+	}
+	return p
+}
+
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
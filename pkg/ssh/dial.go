@@ -0,0 +1,158 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"remote-pull/internal/console"
+)
+
+const (
+	dialAttempts = 4
+	dialBackoff  = time.Second
+)
+
+// dial establishes an SSH connection to addr over the connection returned
+// by connect, retrying with exponential backoff when the failure looks
+// transient (connection reset, DNS hiccup, VPN blip). The host name is
+// resolved again on every attempt, up to attempts times. Each attempt is
+// bounded by timeout, if set (see handshake); ctx aborts the attempts and
+// the waits between them.
+func dial(ctx context.Context, addr string, config *ssh.ClientConfig, timeout time.Duration, attempts int, connect func(context.Context) (net.Conn, error)) (*ssh.Client, error) {
+	backoff := dialBackoff
+	for attempt := 1; ; attempt++ {
+		client, err := handshake(ctx, addr, config, timeout, connect)
+		if err == nil {
+			if attempt > 1 {
+				console.Printf("[RECONNECT] Connected to %s after %d attempts\n", addr, attempt)
+			}
+			return client, nil
+		}
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		if attempt >= attempts || !isTransient(err) {
+			return nil, err
+		}
+		console.Printf("[RECONNECT] Connection to %s failed (%v), retrying in %v (attempt %d/%d)\n", addr, err, backoff, attempt+1, attempts)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+		backoff *= 2
+	}
+}
+
+// handshake connects to addr and sets up the SSH connection. timeout bounds
+// the connect and the key exchange, like OpenSSH's ConnectTimeout;
+// authentication is not bounded as it may wait for a passphrase typed on the
+// terminal. The connection is closed when ctx is done, which also interrupts
+// proxy commands and tunnels that cannot be dialed with a context.
+func handshake(ctx context.Context, addr string, config *ssh.ClientConfig, timeout time.Duration, connect func(context.Context) (net.Conn, error)) (*ssh.Client, error) {
+	attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		attemptCtx, cancel = context.WithTimeoutCause(ctx, timeout, &connectTimeoutError{addr: addr, timeout: timeout})
+	}
+	defer cancel()
+
+	conn, err := connect(attemptCtx)
+	if err != nil {
+		if attemptCtx.Err() != nil {
+			return nil, context.Cause(attemptCtx)
+		}
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		exchange = true // the key exchange is still running
+		done     bool
+		aborted  error
+	)
+	abort := func(c context.Context, duringExchange bool) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if !done && aborted == nil && (exchange || !duringExchange) {
+				aborted = context.Cause(c)
+				conn.Close()
+			}
+		}
+	}
+	defer context.AfterFunc(attemptCtx, abort(attemptCtx, true))()
+	defer context.AfterFunc(ctx, abort(ctx, false))()
+
+	// The host key is verified once the key exchange is complete
+	bounded := *config
+	bounded.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		exchange = false
+		mu.Unlock()
+		return config.HostKeyCallback(hostname, remote, key)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &bounded)
+
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	if aborted != nil {
+		if err == nil {
+			c.Close()
+		}
+		return nil, aborted
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// connectTimeoutError reports a connection attempt that exceeded the connect
+// timeout; it is a net.Error so the attempt is retried.
+type connectTimeoutError struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (e *connectTimeoutError) Error() string {
+	return fmt.Sprintf("connection to %s timed out after %v", e.addr, e.timeout)
+}
+
+func (e *connectTimeoutError) Timeout() bool   { return true }
+func (e *connectTimeoutError) Temporary() bool { return true }
+
+// Unreachable reports whether err, returned by NewClient, means the host
+// could not be reached (a network failure or timeout), as opposed to e.g. an
+// authentication or host key error of a host that answered.
+func Unreachable(err error) bool {
+	return isTransient(err)
+}
+
+// isTransient reports whether err is a network failure worth retrying, as
+// opposed to e.g. an authentication or host key error.
+func isTransient(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
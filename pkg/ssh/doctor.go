@@ -0,0 +1,163 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Check statuses, as printed in checklists.
+const (
+	CheckPass = "PASS"
+	CheckWarn = "WARN"
+	CheckFail = "FAIL"
+)
+
+// Check is the outcome of one local self-test, with a suggested fix when it
+// did not pass.
+type Check struct {
+	Name   string
+	Status string
+	Detail string
+	Fix    string
+}
+
+// CheckLocal tests the local SSH setup used to reach host: the agent, the
+// identities, the ssh_config evaluation and the known_hosts files. No
+// connection is made.
+func CheckLocal(user, host string) []Check {
+	var checks []Check
+	add := func(name, status, detail, fix string) {
+		checks = append(checks, Check{Name: name, Status: status, Detail: detail, Fix: fix})
+	}
+
+	agentKeys := checkAgent(add)
+
+	config, err := parseSSHConfig(host, user)
+	if err != nil {
+		add("ssh_config", CheckFail, err.Error(), "fix the syntax error in "+userConfigFile()+" or "+systemConfigFile)
+		config = &sshConfig{options: map[string]string{}}
+	} else if host == "" {
+		add("ssh_config", CheckPass, "parsed, pass a host to see its effective settings", "")
+	} else {
+		add("ssh_config", CheckPass, describeConfig(config, host, user), "")
+	}
+
+	keyPaths := config.IdentityFiles
+	if len(keyPaths) == 0 {
+		keyPaths = []string{userPath("id_rsa"), userPath("id_ecdsa"), userPath("id_ed25519")}
+	}
+	usable := 0
+	for _, keyPath := range keyPaths {
+		if ok := checkIdentity(keyPath, len(config.IdentityFiles) > 0, add); ok {
+			usable++
+		}
+	}
+	if usable == 0 && agentKeys == 0 {
+		add("Identities", CheckFail, "no usable private key and no agent keys",
+			"create a key with ssh-keygen -t ed25519 and install it with ssh-copy-id, or load one with ssh-add")
+	}
+
+	files := knownHostsFiles()
+	switch _, err := knownhosts.New(files...); {
+	case len(files) == 0:
+		add("known_hosts", CheckWarn, "no known_hosts file, host keys are confirmed and recorded on first connect",
+			"record the host keys in "+userKnownHostsFile()+" with ssh-keyscan to verify them from the start")
+	case err != nil:
+		add("known_hosts", CheckFail, err.Error(), "remove or fix the malformed line")
+	default:
+		add("known_hosts", CheckPass, strings.Join(files, ", "), "")
+	}
+	return checks
+}
+
+// checkAgent reports on the SSH agent and returns the number of keys it
+// holds.
+func checkAgent(add func(name, status, detail, fix string)) int {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		add("SSH agent", CheckWarn, "SSH_AUTH_SOCK is not set", `start one with eval "$(ssh-agent)" and add your key with ssh-add`)
+		return 0
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		add("SSH agent", CheckFail, fmt.Sprintf("%s is not reachable: %v", sock, err), "restart the agent or unset a stale SSH_AUTH_SOCK")
+		return 0
+	}
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	switch {
+	case err != nil:
+		add("SSH agent", CheckFail, fmt.Sprintf("failed to list keys: %v", err), "restart the agent")
+	case len(keys) == 0:
+		add("SSH agent", CheckWarn, "reachable but holds no keys", "add your key with ssh-add")
+	default:
+		add("SSH agent", CheckPass, fmt.Sprintf("%d key(s) at %s", len(keys), sock), "")
+	}
+	return len(keys)
+}
+
+// checkIdentity reports on the private key at keyPath and whether it can be
+// used without further input. Missing default keys are not worth a line,
+// missing configured ones are.
+func checkIdentity(keyPath string, configured bool, add func(name, status, detail, fix string)) bool {
+	name := "Identity"
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		if configured {
+			add(name, CheckWarn, fmt.Sprintf("%s: %v", keyPath, err), "correct the IdentityFile in ssh_config")
+		}
+		return false
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		add(name, CheckFail, fmt.Sprintf("%s: %v", keyPath, err), "make the key readable by your user")
+		return false
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		add(name, CheckWarn, keyPath+" is encrypted", "load it into the agent with ssh-add, enter the passphrase when prompted, or pass --secret-command to supply it")
+		return false
+	case err != nil:
+		add(name, CheckFail, fmt.Sprintf("%s: %v", keyPath, err), "regenerate the key or remove it from ssh_config")
+		return false
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		add(name, CheckWarn, fmt.Sprintf("%s is accessible by other users (%v)", keyPath, info.Mode().Perm()), "chmod 600 "+keyPath)
+		return true
+	}
+	add(name, CheckPass, fmt.Sprintf("%s (%s %s)", keyPath, signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey())), "")
+	return true
+}
+
+func describeConfig(c *sshConfig, host, user string) string {
+	hostName := c.HostName
+	if hostName == "" {
+		hostName = host
+	}
+	if user == "" {
+		user = c.User
+	}
+	if user == "" {
+		user = localUser()
+	}
+	port := c.Port
+	if port == "" {
+		port = "22"
+	}
+	detail := fmt.Sprintf("%s resolves to %s@%s port %s", host, user, hostName, port)
+	if jump := c.option("proxyjump"); jump != "" && !strings.EqualFold(jump, "none") {
+		detail += " via jump host " + jump
+	} else if proxy := c.option("proxycommand"); proxy != "" && !strings.EqualFold(proxy, "none") {
+		detail += " via " + proxy
+	}
+	return detail
+}
@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"remote-pull/internal/console"
+)
+
+// stderrTailLines is the number of remote stderr lines kept for errors.
+const stderrTailLines = 10
+
+// CommandError describes a remote command that did not complete successfully.
+type CommandError struct {
+	Command string
+	// ExitStatus is the remote exit code, or -1 if the command did not exit
+	// normally (e.g. it was killed by a signal or the connection dropped).
+	ExitStatus int
+	// Stderr holds the last lines the command wrote to stderr.
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	var msg string
+	if e.ExitStatus >= 0 {
+		msg = fmt.Sprintf("remote command exited with status %d", e.ExitStatus)
+	} else {
+		msg = fmt.Sprintf("remote command failed: %v", e.Err)
+	}
+	if e.Stderr != "" {
+		msg += "\nremote stderr:\n    " + strings.ReplaceAll(e.Stderr, "\n", "\n    ")
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func newCommandError(cmd string, err error, stderr *tailBuffer) *CommandError {
+	cmdErr := &CommandError{Command: cmd, ExitStatus: -1, Err: err, Stderr: stderr.String()}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		cmdErr.ExitStatus = exitErr.ExitStatus()
+	}
+	return cmdErr
+}
+
+// runSession runs cmd on session, mirroring stderr to the console while
+// keeping its last lines so they can be attached to the returned error.
+// Every command is recorded in the audit log and the session recording, if
+// configured.
+func (c *Client) runSession(session *ssh.Session, cmd string) error {
+	tail := newTailBuffer(stderrTailLines)
+	stderr := console.Writer("")
+	session.Stderr = io.MultiWriter(stderr, tail)
+	stdout := session.Stdout
+	defer trackSession(session)()
+	defer c.abortOnCancel(session)()
+	recorded := recordCommand(c, session, cmd)
+	start := time.Now()
+	err := session.Run(cmd)
+	// A last line without newline, e.g. of an error message, is still shown
+	console.Flush(stdout)
+	console.Flush(stderr)
+	audit(c, cmd, start, err)
+	recorded(err)
+	if err != nil {
+		return newCommandError(cmd, c.aborted(err), tail)
+	}
+	return nil
+}
+
+// runSessionTimeout is like runSession but gives up after timeout, asking
+// the remote process to terminate and closing the session. A zero timeout
+// waits indefinitely.
+func (c *Client) runSessionTimeout(session *ssh.Session, cmd string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return c.runSession(session, cmd)
+	}
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+	})
+	defer timer.Stop()
+
+	err := c.runSession(session, cmd)
+	if err != nil && timedOut.Load() {
+		return fmt.Errorf("remote command timed out after %v: %v", timeout, err)
+	}
+	return err
+}
+
+// tailBuffer is an io.Writer that retains only the last n lines written.
+type tailBuffer struct {
+	mu      sync.Mutex
+	n       int
+	lines   []string
+	partial bytes.Buffer
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{n: n}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial.Write(p)
+	for {
+		line, err := t.partial.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			t.partial.Reset()
+			t.partial.WriteString(line)
+			break
+		}
+		t.lines = append(t.lines, strings.TrimRight(line, "\r\n"))
+		if len(t.lines) > t.n {
+			t.lines = t.lines[len(t.lines)-t.n:]
+		}
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines
+	if last := strings.TrimSpace(t.partial.String()); last != "" {
+		lines = append(append([]string{}, lines...), last)
+		if len(lines) > t.n {
+			lines = lines[len(lines)-t.n:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
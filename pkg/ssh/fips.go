@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms allowed in FIPS mode, following the FIPS crypto policy of
+// OpenSSH in RHEL: key exchange over NIST curves or finite field groups with
+// SHA-2, AES and HMAC-SHA2, and ECDSA or RSA with SHA-2 signatures.
+// Curve25519, Ed25519, ChaCha20-Poly1305 and SHA-1 are left out.
+var (
+	fipsKeyExchanges = []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+	}
+	fipsCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	fipsMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+	}
+	fipsHostKeyAlgorithms = []string{
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	}
+	// fipsRSASignatures are the signature algorithms RSA keys may use
+	fipsRSASignatures = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+)
+
+// restrictToFIPS limits config to the algorithms allowed in FIPS mode.
+func restrictToFIPS(config *ssh.ClientConfig) {
+	config.KeyExchanges = fipsKeyExchanges
+	config.Ciphers = fipsCiphers
+	config.MACs = fipsMACs
+	config.HostKeyAlgorithms = fipsHostKeyAlgorithms
+}
+
+// fipsSigner returns signer restricted to the signature algorithms allowed
+// in FIPS mode, or false when its key type is not allowed at all.
+func fipsSigner(signer ssh.Signer) (ssh.Signer, bool) {
+	keyType := signer.PublicKey().Type()
+	if cert, ok := signer.PublicKey().(*ssh.Certificate); ok {
+		keyType = cert.Key.Type()
+	}
+	switch keyType {
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return signer, true
+	case ssh.KeyAlgoRSA:
+		// Without the restriction the SHA-1 based ssh-rsa may be used with
+		// servers that do not announce their signature algorithms
+		if as, ok := signer.(ssh.AlgorithmSigner); ok {
+			if restricted, err := ssh.NewSignerWithAlgorithms(as, fipsRSASignatures); err == nil {
+				return restricted, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// fipsSigners filters signers through fipsSigner.
+func fipsSigners(signers []ssh.Signer) []ssh.Signer {
+	var allowed []ssh.Signer
+	for _, signer := range signers {
+		if s, ok := fipsSigner(signer); ok {
+			allowed = append(allowed, s)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,360 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+
+	"remote-pull/internal/console"
+)
+
+func userKnownHostsFile() string {
+	return userPath("known_hosts")
+}
+
+func knownHostsFiles() []string {
+	var files []string
+	for _, f := range []string{userKnownHostsFile(), "/etc/ssh/ssh_known_hosts"} {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Values of Options.StrictHostKeyChecking, as in ssh_config.
+const (
+	HostKeyCheckYes       = "yes"
+	HostKeyCheckNo        = "no"
+	HostKeyCheckAcceptNew = "accept-new"
+	HostKeyCheckAsk       = "ask"
+)
+
+// HostKeyChecks lists the valid values of Options.StrictHostKeyChecking.
+var HostKeyChecks = []string{HostKeyCheckYes, HostKeyCheckNo, HostKeyCheckAcceptNew, HostKeyCheckAsk}
+
+// knownHostsMu serializes prompts and changes to known_hosts between
+// concurrent connections.
+var knownHostsMu sync.Mutex
+
+// hostKeyMode returns the effective StrictHostKeyChecking mode: option when
+// set, else the ssh_config value, else ask.
+func hostKeyMode(option, configured string) (string, error) {
+	mode := strings.ToLower(option)
+	if mode == "" {
+		mode = strings.ToLower(configured)
+	}
+	switch mode {
+	case "":
+		return HostKeyCheckAsk, nil
+	case "off":
+		return HostKeyCheckNo, nil
+	case HostKeyCheckYes, HostKeyCheckNo, HostKeyCheckAcceptNew, HostKeyCheckAsk:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid StrictHostKeyChecking %q, expected one of %s", mode, strings.Join(HostKeyChecks, ", "))
+}
+
+// hostKeyCallback verifies server keys against the known_hosts files. Hosts
+// without an entry are handled according to mode (see unknownHost); a key
+// that differs from the recorded one is rejected unless updateHostKey is
+// set, in which case the user's known_hosts entry is replaced. known is set
+// when the key matched a recorded entry. interactive allows asking about
+// unknown keys on the terminal.
+func hostKeyCallback(mode string, updateHostKey, interactive bool, known *bool) (ssh.HostKeyCallback, error) {
+	check, err := knownhosts.New(knownHostsFiles()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %v", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		if err == nil {
+			*known = true
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) == 0 {
+			return unknownHost(mode, interactive, hostname, remote, key)
+		}
+		if !updateHostKey {
+			return &HostKeyMismatchError{Host: hostname, Key: key, Known: keyErr.Want}
+		}
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		return replaceKnownHost(hostname, key, keyErr.Want)
+	}, nil
+}
+
+// CheckHostKeyPin validates a pinned host key, "[TYPE ]SHA256:FINGERPRINT"
+// as shown by ssh-keygen -l and ssh-keyscan, e.g. "ssh-ed25519 SHA256:...".
+func CheckHostKeyPin(pin string) error {
+	keyType, fingerprint := splitHostKeyPin(pin)
+	if !strings.HasPrefix(fingerprint, "SHA256:") || len(fingerprint) != len("SHA256:")+43 {
+		return fmt.Errorf("invalid host key %q, expected [TYPE ]SHA256:FINGERPRINT", pin)
+	}
+	if keyType != "" && hostKeyAlgorithms(keyType) == nil {
+		return fmt.Errorf("invalid host key %q: unknown key type %s", pin, keyType)
+	}
+	return nil
+}
+
+func splitHostKeyPin(pin string) (string, string) {
+	fields := strings.Fields(pin)
+	if len(fields) == 2 {
+		return fields[0], fields[1]
+	}
+	return "", strings.TrimSpace(pin)
+}
+
+// hostKeyAlgorithms returns the host key algorithms that negotiate a key of
+// keyType.
+func hostKeyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return []string{keyType}
+	}
+	return nil
+}
+
+// pinnedAlgorithms returns the host key algorithms to offer so the server
+// presents a pinned key, or nil when a pin without a type matches any key.
+func pinnedAlgorithms(pins []string) []string {
+	var algorithms []string
+	for _, pin := range pins {
+		keyType, _ := splitHostKeyPin(pin)
+		if keyType == "" {
+			return nil
+		}
+		algorithms = append(algorithms, hostKeyAlgorithms(keyType)...)
+	}
+	return algorithms
+}
+
+// pinnedHostKeyCallback accepts only server keys with one of the pinned
+// fingerprints; known_hosts is neither consulted nor updated.
+func pinnedHostKeyCallback(pins []string, known *bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		for _, pin := range pins {
+			keyType, pinned := splitHostKeyPin(pin)
+			if pinned == fingerprint && (keyType == "" || keyType == key.Type()) {
+				*known = true
+				return nil
+			}
+		}
+		return &HostKeyPinError{Host: hostname, Key: key, Pinned: pins}
+	}
+}
+
+// unknownHost decides about the key of a host without a known_hosts entry:
+// yes rejects it, no accepts it without recording it, accept-new records it
+// and ask asks for confirmation first, refusing the key like OpenSSH when it
+// cannot ask.
+func unknownHost(mode string, interactive bool, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	switch mode {
+	case HostKeyCheckYes:
+		return &HostKeyUnknownError{Host: hostname, Key: key}
+	case HostKeyCheckNo:
+		console.Printf("[WARNING] Host key of %s is not in known_hosts, accepting %s %s without verification\n", hostname, key.Type(), ssh.FingerprintSHA256(key))
+		return nil
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	// A concurrent connection to the same host may have recorded the key
+	// while this one waited
+	if check, err := knownhosts.New(knownHostsFiles()...); err == nil && check(hostname, remote, key) == nil {
+		return nil
+	}
+	if mode == HostKeyCheckAsk {
+		if !interactive {
+			return &HostKeyUnknownError{Host: hostname, Key: key, NoPrompt: true}
+		}
+		if err := confirmHostKey(hostname, remote, key); err != nil {
+			return err
+		}
+	}
+	return addKnownHost(hostname, key)
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirmHostKey shows the fingerprint of key and asks whether to trust it,
+// accepting yes or the fingerprint itself like OpenSSH.
+func confirmHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fingerprint := ssh.FingerprintSHA256(key)
+	address := ""
+	if remote != nil && remote.String() != hostname {
+		address = " (" + remote.String() + ")"
+	}
+	keyType := strings.ToUpper(strings.TrimPrefix(key.Type(), "ssh-"))
+	if strings.HasPrefix(keyType, "ECDSA") {
+		keyType = "ECDSA"
+	}
+	console.Printf("The authenticity of host '%s%s' can't be established.\n%s key fingerprint is %s.\n", hostname, address, keyType, fingerprint)
+	console.Printf("Are you sure you want to continue connecting (yes/no/[fingerprint])? ")
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		switch {
+		case strings.EqualFold(answer, "yes") || answer == fingerprint:
+			return nil
+		case strings.EqualFold(answer, "no") || err != nil:
+			return &HostKeyUnknownError{Host: hostname, Key: key, Rejected: true}
+		}
+		console.Printf("Please type 'yes', 'no' or the fingerprint: ")
+	}
+}
+
+// addKnownHost records key for hostname in the user's known_hosts file.
+// Without a home directory the key is accepted without being recorded.
+func addKnownHost(hostname string, key ssh.PublicKey) error {
+	file := userKnownHostsFile()
+	if file == "" {
+		console.Printf("[WARNING] No home directory to record the host key of %s in, accepting %s %s for this run\n", hostname, key.Type(), ssh.FingerprintSHA256(key))
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(file), err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+	if data, err := os.ReadFile(file); err == nil && len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %v", file, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line); err != nil {
+		return fmt.Errorf("failed to update %s: %v", file, err)
+	}
+	console.Printf("[HOSTKEY] Added host key for %s to %s (%s %s)\n", hostname, file, key.Type(), ssh.FingerprintSHA256(key))
+	return f.Close()
+}
+
+// HostKeyUnknownError is returned when a host without a known_hosts entry
+// is refused, by --strict-host-key-checking=yes or at the prompt.
+type HostKeyUnknownError struct {
+	Host string
+	Key  ssh.PublicKey
+	// Rejected is set when the key was refused at the prompt.
+	Rejected bool
+	// NoPrompt is set when the key could not be confirmed because there
+	// is no terminal or prompts are disabled.
+	NoPrompt bool
+}
+
+// Fingerprint returns the SHA256 fingerprint of the offered key.
+func (e *HostKeyUnknownError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	if e.Rejected {
+		return fmt.Sprintf("host key verification failed: %s key %s of %s was not accepted", e.Key.Type(), e.Fingerprint(), e.Host)
+	}
+	if e.NoPrompt {
+		return fmt.Sprintf("host key verification failed: no known_hosts entry for %s (offered %s %s) and no terminal to confirm it, or --batch is set; add it with ssh-keyscan, pin it with --host-key or rerun with --strict-host-key-checking=accept-new", e.Host, e.Key.Type(), e.Fingerprint())
+	}
+	return fmt.Sprintf("host key verification failed: no known_hosts entry for %s (offered %s %s); add it with ssh-keyscan or rerun with --strict-host-key-checking=accept-new", e.Host, e.Key.Type(), e.Fingerprint())
+}
+
+// HostKeyMismatchError is returned when the server presents a key that
+// differs from the one recorded in known_hosts.
+type HostKeyMismatchError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+// Fingerprint returns the SHA256 fingerprint of the offered key.
+func (e *HostKeyMismatchError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "REMOTE HOST IDENTIFICATION HAS CHANGED for %s!\n", e.Host)
+	fmt.Fprintf(&b, "Someone could be eavesdropping on you right now, or the host key has just been changed.\n")
+	for _, known := range e.Known {
+		fmt.Fprintf(&b, "  known key:   %s %s (%s:%d)\n", known.Key.Type(), ssh.FingerprintSHA256(known.Key), known.Filename, known.Line)
+	}
+	fmt.Fprintf(&b, "  offered key: %s %s\n", e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	fmt.Fprintf(&b, "If the change is expected (e.g. the host was reinstalled), rerun with --update-host-key")
+	return b.String()
+}
+
+// HostKeyPinError is returned when the server presents a key other than
+// the ones pinned in Options.HostKeys.
+type HostKeyPinError struct {
+	Host   string
+	Key    ssh.PublicKey
+	Pinned []string
+}
+
+// Fingerprint returns the SHA256 fingerprint of the offered key.
+func (e *HostKeyPinError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+func (e *HostKeyPinError) Error() string {
+	return fmt.Sprintf("host key verification failed: %s offered %s %s, which is not pinned (pinned: %s)", e.Host, e.Key.Type(), e.Fingerprint(), strings.Join(e.Pinned, ", "))
+}
+
+// replaceKnownHost removes the stale entries for hostname from the user's
+// known_hosts file and records key in their place.
+func replaceKnownHost(hostname string, key ssh.PublicKey, stale []knownhosts.KnownKey) error {
+	file := userKnownHostsFile()
+
+	drop := map[int]bool{}
+	for _, known := range stale {
+		if known.Filename != file {
+			return fmt.Errorf("stale host key for %s is recorded in %s:%d, which must be updated manually", hostname, known.Filename, known.Line)
+		}
+		drop[known.Line] = true
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	var kept []string
+	for i, line := range lines {
+		if !drop[i+1] {
+			kept = append(kept, line)
+		}
+	}
+	content := strings.Join(kept, "")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to update %s: %v", file, err)
+	}
+	console.Printf("[HOSTKEY] Replaced host key for %s in %s (%s %s)\n", hostname, file, key.Type(), ssh.FingerprintSHA256(key))
+	return nil
+}
@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxJumpDepth bounds the chain of jump hosts, including those added by the
+// ssh_config of the jump hosts themselves.
+const maxJumpDepth = 8
+
+// jumpConn is a connection forwarded through a jump host; closing it closes
+// the connection to the jump host as well.
+type jumpConn struct {
+	net.Conn
+	jump *Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.jump.Close()
+	return err
+}
+
+// parseJumps parses a ProxyJump list of [user@]host[:port] entries separated
+// by commas, in the order they are passed through. "none" disables jumping.
+func parseJumps(spec string) ([]Target, error) {
+	if spec == "" || strings.EqualFold(spec, "none") {
+		return nil, nil
+	}
+	var jumps []Target
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "ssh://")
+		jump, err := ParseTarget(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host: %v", err)
+		}
+		jumps = append(jumps, jump)
+	}
+	return jumps, nil
+}
+
+func joinJumps(jumps []Target) string {
+	s := make([]string, len(jumps))
+	for i, jump := range jumps {
+		s[i] = jump.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// dialJump returns a function connecting to addr through the last of jumps,
+// which is itself reached through the ones before it. Every jump host is
+// connected to with opts, so it authenticates and verifies host keys like
+// the target, and honors its own ssh_config entry.
+func dialJump(addr string, jumps []Target, opts Options) func(context.Context) (net.Conn, error) {
+	last := jumps[len(jumps)-1]
+	jumpOpts := opts
+	jumpOpts.Port = last.Port
+	jumpOpts.Transport.Jump = joinJumps(jumps[:len(jumps)-1])
+	jumpOpts.jumpDepth++
+	// Pinned keys are those of the target, jump hosts use known_hosts
+	jumpOpts.HostKeys = nil
+	return func(ctx context.Context) (net.Conn, error) {
+		jump, err := NewClient(ctx, last.User, last.Host, jumpOpts)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %v", last, err)
+		}
+		conn, err := jump.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			jump.Close()
+			return nil, fmt.Errorf("jump host %s cannot reach %s: %v", last, addr, err)
+		}
+		return &jumpConn{Conn: conn, jump: jump}, nil
+	}
+}
@@ -0,0 +1,43 @@
+package ssh
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// userDir overrides the directory holding the user's ssh config, keys and
+// known_hosts.
+var userDir string
+
+// SetUserDir makes dir the user's SSH directory instead of ~/.ssh, e.g. a
+// mounted directory when running inside a container.
+func SetUserDir(dir string) {
+	userDir = dir
+}
+
+// homeDir returns the user's home directory, or "" when it cannot be
+// determined, e.g. in a container running as an arbitrary UID without HOME.
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return ""
+}
+
+// userPath returns the path of name in the user's SSH directory, or "" when
+// there is no such directory.
+func userPath(name string) string {
+	dir := userDir
+	if dir == "" {
+		home := homeDir()
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".ssh")
+	}
+	return filepath.Join(dir, name)
+}
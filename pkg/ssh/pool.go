@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool shares one connection per host between operations that would
+// otherwise each connect on their own, such as the transfers of several
+// images in one run. NewClient returns the pooled connection of a host when
+// Options.Pool is set; closing it leaves the connection open for the next
+// operation, until the pool is closed.
+type Pool struct {
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+}
+
+type poolEntry struct {
+	mu     sync.Mutex
+	client *Client
+}
+
+func NewPool() *Pool {
+	return &Pool{entries: map[string]*poolEntry{}}
+}
+
+// Close closes the pooled connections.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.entries {
+		entry.mu.Lock()
+		if entry.client != nil {
+			entry.client.Client.Close()
+			entry.client = nil
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// client returns the pooled connection to host, connecting first if there
+// is none or it no longer answers. Other hosts are connected to
+// concurrently.
+func (p *Pool) client(ctx context.Context, user, host string, opts Options) (*Client, error) {
+	key := Target{User: user, Host: host, Port: opts.Port}.String()
+	p.mu.Lock()
+	entry := p.entries[key]
+	if entry == nil {
+		entry = &poolEntry{}
+		p.entries[key] = entry
+	}
+	p.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.client != nil {
+		if _, _, err := entry.client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+			return entry.client.shared(ctx), nil
+		}
+		entry.client.Client.Close()
+		entry.client = nil
+	}
+	opts.Pool = nil
+	client, err := NewClient(ctx, user, host, opts)
+	if err != nil {
+		return nil, err
+	}
+	entry.client = client
+	return client.shared(ctx), nil
+}
+
+// shared returns a handle on the connection of c whose commands are aborted
+// once ctx is done, and whose Close does not close the connection.
+func (c *Client) shared(ctx context.Context) *Client {
+	return &Client{Client: c.Client, HostKeyKnown: c.HostKeyKnown, target: c.target, ctx: ctx, pooled: true}
+}
+
+// Close closes the connection, unless it belongs to a Pool.
+func (c *Client) Close() error {
+	if c.pooled {
+		return nil
+	}
+	return c.Client.Close()
+}
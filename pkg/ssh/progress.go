@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"io"
+	"math/bits"
+)
+
+// progressWriter counts the bytes written through it and reports the
+// completed percentage. Percentages are derived from integer basis points so
+// that multi-gigabyte totals never lose precision in float conversions.
+type progressWriter struct {
+	w       io.Writer
+	total   int64
+	written int64
+	report  func(percent float64)
+	last    int64
+	// copied, when set, receives the byte count after every write.
+	copied func(written int64)
+	// flow, when set, limits the rate of the copy (see limit).
+	flow *bandwidthFlow
+}
+
+// limit returns w paced by the bandwidth limit of the copy, if any.
+func (p *progressWriter) limit(w io.Writer) io.Writer {
+	if p.flow == nil {
+		return w
+	}
+	return &limitedWriter{w: w, flow: p.flow}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if p.copied != nil {
+		p.copied(p.written)
+	}
+	if p.report != nil {
+		basisPoints := progressBasisPoints(p.written, p.total)
+		if basisPoints != p.last {
+			p.last = basisPoints
+			p.report(float64(basisPoints) / 100)
+		}
+	}
+	return n, err
+}
+
+// progressBasisPoints returns written as hundredths of a percent of total,
+// rounded down. The product written*10000 is computed in 128 bits so it
+// cannot overflow for any int64 size.
+func progressBasisPoints(written, total int64) int64 {
+	if total <= 0 || written >= total {
+		return 10000
+	}
+	if written <= 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(uint64(written), 10000)
+	quo, _ := bits.Div64(hi, lo, uint64(total))
+	return int64(quo)
+}
@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgressBasisPoints(t *testing.T) {
+	tests := []struct {
+		written, total, want int64
+	}{
+		{0, 10000, 0},
+		{1, 10000, 1},
+		{9999, 10000, 9999},
+		{10000, 10000, 10000},
+		{1, 3, 3333},
+		{2, 3, 6666},
+		{5, 0, 10000},
+		{1<<32 - 1, 1 << 32, 9999},
+		{1 << 32, 1<<32 + 1, 9999},
+		{1 << 32, 1 << 33, 5000},
+		{5 << 30, 6 << 30, 8333},
+		{math.MaxInt64 / 2, math.MaxInt64, 4999},
+		{math.MaxInt64 - 1, math.MaxInt64, 9999},
+	}
+	for _, tt := range tests {
+		if got := progressBasisPoints(tt.written, tt.total); got != tt.want {
+			t.Errorf("progressBasisPoints(%d, %d) = %d, want %d", tt.written, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestSCPHeader(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{1<<32 - 1, "C0644 4294967295 image.tar\n"},
+		{1 << 32, "C0644 4294967296 image.tar\n"},
+		{1<<32 + 1, "C0644 4294967297 image.tar\n"},
+		{5 << 30, "C0644 5368709120 image.tar\n"},
+	}
+	for _, tt := range tests {
+		if got := scpHeader(tt.size, "/tmp/archives/image.tar"); got != tt.want {
+			t.Errorf("scpHeader(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+// sparseFile creates a file of size bytes that takes no space on disk.
+func sparseFile(t *testing.T, size int64) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "image.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		t.Skipf("sparse files not supported: %v", err)
+	}
+	return f
+}
+
+func TestCopyFileMultiGigabyte(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams several GB")
+	}
+	for _, size := range []int64{1<<32 + 1, 5 << 30} {
+		f := sparseFile(t, size)
+		var reports []float64
+		var copied int64
+		pw := &progressWriter{w: io.Discard, total: size, report: func(percent float64) {
+			reports = append(reports, percent)
+		}, copied: func(written int64) { copied = written }}
+		if err := copyFile(pw, f, size); err != nil {
+			t.Fatalf("copyFile(%d): %v", size, err)
+		}
+		if pw.written != size || copied != size {
+			t.Errorf("copyFile(%d) wrote %d, reported %d", size, pw.written, copied)
+		}
+		if len(reports) == 0 || reports[len(reports)-1] != 100 {
+			t.Fatalf("copyFile(%d) did not report 100%%: %v", size, reports[max(0, len(reports)-3):])
+		}
+		for i := 1; i < len(reports); i++ {
+			if reports[i] <= reports[i-1] {
+				t.Fatalf("copyFile(%d) progress went from %.2f to %.2f", size, reports[i-1], reports[i])
+			}
+		}
+		if reports[len(reports)-2] >= 100 {
+			t.Errorf("copyFile(%d) reported 100%% before the end", size)
+		}
+	}
+}
+
+func TestCopyFileResumedOffset(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams several GB")
+	}
+	// A resumed upload starts counting at the offset already on the remote
+	size, offset := int64(5<<30), int64(1<<32)
+	f := sparseFile(t, size)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var first float64 = -1
+	pw := &progressWriter{w: io.Discard, total: size, written: offset, report: func(percent float64) {
+		if first < 0 {
+			first = percent
+		}
+	}}
+	if err := copyFile(pw, f, size-offset); err != nil {
+		t.Fatal(err)
+	}
+	if pw.written != size || first < 80 {
+		t.Errorf("resumed copy wrote %d, first report %.2f%%", pw.written, first)
+	}
+}
+
+func TestCopyFileShortRead(t *testing.T) {
+	f := sparseFile(t, 1<<32+1)
+	pw := &progressWriter{w: io.Discard, total: 1<<32 + 2}
+	// Stop reading just past the 4 GiB boundary
+	if _, err := f.Seek(1<<32-1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(pw, f, 3); err == nil {
+		t.Fatal("copyFile succeeded on a file shorter than announced")
+	}
+}
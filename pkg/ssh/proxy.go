@@ -0,0 +1,148 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"remote-pull/internal/console"
+)
+
+// proxyConn is a net.Conn backed by the stdin/stdout of a proxy command, as
+// used for ProxyCommand and the tunnel based transports.
+type proxyConn struct {
+	cmd *exec.Cmd
+	r   io.ReadCloser
+	w   io.WriteCloser
+}
+
+// dialProxyCommand starts command through the local shell and returns its
+// stdio as connection to the SSH server.
+func dialProxyCommand(command string) (net.Conn, error) {
+	cmd := shellCommand(command)
+
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &proxyConn{cmd: cmd, r: r, w: w}, nil
+}
+
+func (c *proxyConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *proxyConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *proxyConn) Close() error {
+	c.w.Close()
+	c.r.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	// The command is expected to die from the kill
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *proxyConn) LocalAddr() net.Addr                { return proxyAddr{} }
+func (c *proxyConn) RemoteAddr() net.Addr               { return proxyAddr{} }
+func (c *proxyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *proxyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *proxyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type proxyAddr struct{}
+
+func (proxyAddr) Network() string { return "proxy" }
+func (proxyAddr) String() string  { return "proxy-command" }
+
+// tunnelReadyTimeout bounds how long a local tunnel may take to accept
+// connections.
+const tunnelReadyTimeout = 30 * time.Second
+
+// tunnelConn is a connection through a local port forwarded by a tunnel
+// process, which is stopped when the connection is closed.
+type tunnelConn struct {
+	net.Conn
+	cmd    *exec.Cmd
+	exited chan error
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.cmd.Process.Kill()
+	<-c.exited
+	return err
+}
+
+// dialLocalTunnel starts command with the %l token replaced by a free local
+// port and connects to that port once the tunnel accepts connections.
+func dialLocalTunnel(command string) (net.Conn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find a free local port: %v", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	cmd := shellCommand(strings.ReplaceAll(command, "%l", port))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	addr := net.JoinHostPort("127.0.0.1", port)
+	deadline := time.Now().Add(tunnelReadyTimeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			return &tunnelConn{Conn: conn, cmd: cmd, exited: exited}, nil
+		}
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("tunnel command exited before accepting connections: %v", err)
+		case <-time.After(250 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			<-exited
+			return nil, fmt.Errorf("tunnel did not accept connections within %v", tunnelReadyTimeout)
+		}
+	}
+}
+
+// shellTokens quotes the values of tokens for the shell run by
+// shellCommand, so user and host names cannot inject commands. cmd on
+// Windows has no such quoting; ParseTarget refuses metacharacters in users.
+func shellTokens(tokens map[byte]string) map[byte]string {
+	if runtime.GOOS == "windows" {
+		return tokens
+	}
+	quoted := make(map[byte]string, len(tokens))
+	for token, value := range tokens {
+		quoted[token] = shellQuote(value)
+	}
+	return quoted
+}
+
+// shellCommand runs command through the local shell, passing its stderr to
+// the console.
+func shellCommand(command string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", "exec "+command)
+	}
+	cmd.Stderr = console.Writer("[proxy] ")
+	return cmd
+}
@@ -0,0 +1,232 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"remote-pull/internal/console"
+)
+
+// recordOutputLimit caps the output kept per stream and command, so that a
+// command streaming an image does not blow up the recording.
+const recordOutputLimit = 1 << 20
+
+// sessionRecord opens a recording and describes the local side.
+type sessionRecord struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Version   string    `json:"version"`
+	Args      []string  `json:"args"`
+	LocalUser string    `json:"local_user"`
+	Platform  string    `json:"platform"`
+}
+
+// connectRecord describes the establishment of a connection.
+type connectRecord struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	Addr string    `json:"addr"`
+	User string    `json:"user"`
+	// Via is the tunnel or proxy command the connection went through.
+	Via string `json:"via,omitempty"`
+	// Auth lists the authentication methods offered, in order.
+	Auth          []string `json:"auth"`
+	ClientVersion string   `json:"client_version,omitempty"`
+	ServerVersion string   `json:"server_version,omitempty"`
+	RemoteAddr    string   `json:"remote_addr,omitempty"`
+	HostKey       string   `json:"host_key,omitempty"`
+	HostKeyKnown  bool     `json:"host_key_known"`
+	Banner        string   `json:"banner,omitempty"`
+	DurationMS    int64    `json:"duration_ms"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// commandRecord holds a remote command with its complete output.
+type commandRecord struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	Command    string    `json:"command"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	ExitStatus int       `json:"exit_status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+var recording struct {
+	sync.Mutex
+	file *os.File
+}
+
+// SetRecording writes a troubleshooting bundle to path: one JSON object
+// per line for the session, every connection with its negotiation details
+// and every remote command with its output and timing. Registered secrets
+// are redacted.
+func SetRecording(path, version string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %v", err)
+	}
+	recording.Lock()
+	recording.file = f
+	recording.Unlock()
+
+	args := make([]string, len(os.Args))
+	for i, arg := range os.Args {
+		args[i] = console.Redacted(arg)
+	}
+	record(sessionRecord{
+		Type:      "session",
+		Time:      time.Now().UTC(),
+		Version:   version,
+		Args:      args,
+		LocalUser: localUser(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	})
+	return nil
+}
+
+func recordingEnabled() bool {
+	recording.Lock()
+	defer recording.Unlock()
+	return recording.file != nil
+}
+
+// record appends v to the recording. Failures are reported but do not fail
+// the operation being recorded.
+func record(v any) {
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.file == nil {
+		return
+	}
+	data, _ := json.Marshal(v)
+	if _, err := recording.file.Write(append(data, '\n')); err != nil {
+		console.Printf("[WARNING] Failed to write recording: %v\n", err)
+	}
+}
+
+// recordConnect hooks into config to collect the negotiation details of a
+// connection and returns the function recording them once it is
+// established or has failed.
+func recordConnect(target Target, addr, via string, auth []string, config *ssh.ClientConfig) func(*ssh.Client, bool, error) {
+	if !recordingEnabled() {
+		return func(*ssh.Client, bool, error) {}
+	}
+	r := connectRecord{
+		Type: "connect",
+		Time: time.Now().UTC(),
+		Host: target.String(),
+		Addr: addr,
+		User: config.User,
+		Via:  console.Redacted(via),
+		Auth: auth,
+	}
+	check := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		r.HostKey = key.Type() + " " + ssh.FingerprintSHA256(key)
+		return check(hostname, remote, key)
+	}
+	config.BannerCallback = func(message string) error {
+		r.Banner = strings.TrimSpace(message)
+		return nil
+	}
+	start := time.Now()
+	return func(client *ssh.Client, known bool, err error) {
+		r.DurationMS = time.Since(start).Milliseconds()
+		r.HostKeyKnown = known
+		if client != nil {
+			r.ClientVersion = string(client.ClientVersion())
+			r.ServerVersion = string(client.ServerVersion())
+			r.RemoteAddr = client.RemoteAddr().String()
+		}
+		if err != nil {
+			r.Error = console.Redacted(err.Error())
+		}
+		record(r)
+	}
+}
+
+// recordCommand captures the output of session and returns the function
+// recording cmd once it has run. Stdin is never recorded, it may carry
+// secrets or the image archive.
+func recordCommand(c *Client, session *ssh.Session, cmd string) func(error) {
+	if !recordingEnabled() {
+		return func(error) {}
+	}
+	stdout := &capBuffer{limit: recordOutputLimit}
+	stderr := &capBuffer{limit: recordOutputLimit}
+	session.Stdout = teeWriter(session.Stdout, stdout)
+	session.Stderr = teeWriter(session.Stderr, stderr)
+	start := time.Now()
+	return func(err error) {
+		r := commandRecord{
+			Type:       "command",
+			Time:       start.UTC(),
+			Host:       c.target.String(),
+			Command:    console.Redacted(cmd),
+			Stdout:     console.Redacted(stdout.String()),
+			Stderr:     console.Redacted(stderr.String()),
+			Truncated:  stdout.truncated || stderr.truncated,
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+		var exitErr *ssh.ExitError
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr):
+			r.ExitStatus = exitErr.ExitStatus()
+		default:
+			r.ExitStatus = -1
+			r.Error = console.Redacted(err.Error())
+		}
+		record(r)
+	}
+}
+
+// capBuffer keeps the first limit bytes written to it.
+type capBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *capBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := min(len(p), b.limit-b.buf.Len())
+	if n < len(p) {
+		b.truncated = true
+	}
+	b.buf.Write(p[:n])
+	return len(p), nil
+}
+
+func (b *capBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// teeWriter returns a writer duplicating writes to w, which may be nil, and
+// to capture.
+func teeWriter(w, capture io.Writer) io.Writer {
+	if w == nil {
+		return capture
+	}
+	return io.MultiWriter(w, capture)
+}
@@ -0,0 +1,158 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+
+	"remote-pull/internal/console"
+)
+
+// keychainService is the service name secrets are stored under in the OS
+// keychain.
+const keychainService = "remote-pull"
+
+// SecretOptions configures where key passphrases and passwords come from.
+// Secrets are looked up by account: "passphrase:<key path>" for private keys
+// and "password:<user>@<host>" for password authentication.
+type SecretOptions struct {
+	// Keychain reads secrets from the OS keychain (macOS Keychain, or the
+	// Secret Service via secret-tool on Linux).
+	Keychain bool
+	// Command is run through the local shell and prints the secret on
+	// stdout, e.g. a password manager CLI. The account is passed in
+	// REMOTE_PULL_SECRET_ACCOUNT.
+	Command string
+	// Batch disables the terminal prompts otherwise shown for secrets no
+	// other source provides, and for unknown host keys.
+	Batch bool
+}
+
+// promptAttempts is the number of times a prompted secret is asked for
+// when it is wrong.
+const promptAttempts = 3
+
+// promptMu serializes terminal prompts between concurrent connections.
+var promptMu sync.Mutex
+
+// prompted caches the secrets entered at a prompt for the rest of the run,
+// keyed by account, so that every connection to a host does not ask again.
+var prompted = struct {
+	sync.Mutex
+	secrets map[string][]byte
+}{secrets: map[string][]byte{}}
+
+func (o SecretOptions) enabled() bool {
+	return o.configured() || o.interactive()
+}
+
+// configured reports whether a non-interactive source is set up.
+func (o SecretOptions) configured() bool {
+	return o.Keychain || o.Command != ""
+}
+
+// interactive reports whether secrets may be asked for on the terminal.
+func (o SecretOptions) interactive() bool {
+	return !o.Batch && stdinIsTerminal()
+}
+
+// prompting reports whether secrets are only asked for on the terminal, so
+// that a wrong one is worth asking for again.
+func (o SecretOptions) prompting() bool {
+	return !o.configured() && o.interactive()
+}
+
+// lookup returns the secret for account from the configured source, or asks
+// for it on the terminal when there is none or it failed. The caller owns
+// the returned slice and should clear it once the secret has been used.
+func (o SecretOptions) lookup(account string) ([]byte, error) {
+	if !o.configured() {
+		if !o.interactive() {
+			return nil, fmt.Errorf("no secret source configured")
+		}
+		return promptSecret(account)
+	}
+	secret, err := o.lookupSource(account)
+	if err != nil && o.interactive() {
+		console.Printf("[WARNING] %v\n", err)
+		return promptSecret(account)
+	}
+	return secret, err
+}
+
+// lookupSource returns the secret stored for account in the keychain or
+// printed by the secret command.
+func (o SecretOptions) lookupSource(account string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch {
+	case o.Command != "":
+		cmd = shellCommand(o.Command)
+		cmd.Env = append(os.Environ(), "REMOTE_PULL_SECRET_ACCOUNT="+account)
+	case o.Keychain && runtime.GOOS == "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	case o.Keychain && runtime.GOOS == "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	case o.Keychain:
+		return nil, fmt.Errorf("no keychain support on %s, use a secret command instead", runtime.GOOS)
+	default:
+		return nil, fmt.Errorf("no secret source configured")
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		clear(stdout.Bytes())
+		return nil, fmt.Errorf("failed to look up secret %s: %v", account, err)
+	}
+	secret := bytes.TrimRight(stdout.Bytes(), "\r\n")
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret %s is empty", account)
+	}
+	console.Redact(string(secret))
+	return secret, nil
+}
+
+// promptSecret asks for the secret of account on the terminal without
+// echoing it, or returns the one entered earlier in the run.
+func promptSecret(account string) ([]byte, error) {
+	prompted.Lock()
+	defer prompted.Unlock()
+	if secret, ok := prompted.secrets[account]; ok {
+		return bytes.Clone(secret), nil
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	kind, name, _ := strings.Cut(account, ":")
+	if kind == "passphrase" {
+		console.Printf("Enter passphrase for key '%s': ", name)
+	} else {
+		console.Printf("%s's password: ", name)
+	}
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	console.Printf("\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", kind, err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("no %s entered for %s", kind, name)
+	}
+	console.Redact(string(secret))
+	prompted.secrets[account] = secret
+	return bytes.Clone(secret), nil
+}
+
+// forgetPrompted drops the secret entered for account, after it turned out
+// to be wrong.
+func forgetPrompted(account string) {
+	prompted.Lock()
+	defer prompted.Unlock()
+	clear(prompted.secrets[account])
+	delete(prompted.secrets, account)
+}
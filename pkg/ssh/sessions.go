@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signalGrace is how long remote processes get to exit after SIGTERM before
+// they are sent SIGKILL.
+const signalGrace = 2 * time.Second
+
+// active holds the sessions whose remote command is currently running.
+var active = struct {
+	sync.Mutex
+	sessions map[*ssh.Session]struct{}
+}{sessions: map[*ssh.Session]struct{}{}}
+
+func trackSession(session *ssh.Session) func() {
+	active.Lock()
+	defer active.Unlock()
+	active.sessions[session] = struct{}{}
+	return func() {
+		active.Lock()
+		defer active.Unlock()
+		delete(active.sessions, session)
+	}
+}
+
+// TerminateSessions stops every running remote command: it sends SIGTERM,
+// escalates to SIGKILL after a short grace period and finally closes the
+// sessions so no remote scp or docker load keeps running after the local
+// process exits.
+func TerminateSessions() {
+	active.Lock()
+	sessions := make([]*ssh.Session, 0, len(active.sessions))
+	for s := range active.sessions {
+		sessions = append(sessions, s)
+	}
+	active.Unlock()
+	if len(sessions) == 0 {
+		return
+	}
+
+	for _, s := range sessions {
+		s.Signal(ssh.SIGTERM)
+	}
+	deadline := time.Now().Add(signalGrace)
+	for time.Now().Before(deadline) {
+		active.Lock()
+		remaining := len(active.sessions)
+		active.Unlock()
+		if remaining == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, s := range sessions {
+		s.Signal(ssh.SIGKILL)
+		s.Close()
+	}
+}
+
+// abortOnCancel asks the command of session to terminate and closes the
+// session once the context of c is done. The returned function stops
+// watching.
+func (c *Client) abortOnCancel(session *ssh.Session) func() bool {
+	return context.AfterFunc(c.ctx, func() {
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+	})
+}
+
+// aborted returns why the context of c is done in place of err, which is
+// then only the consequence of the session being closed.
+func (c *Client) aborted(err error) error {
+	if c.ctx.Err() != nil {
+		return context.Cause(c.ctx)
+	}
+	return err
+}
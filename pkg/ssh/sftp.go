@@ -0,0 +1,254 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// SFTP protocol version 3 (draft-ietf-secsh-filexfer-02), reduced to what is
+// needed to upload a file: open, write, close and rename.
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpWrite   = 6
+	sftpRemove  = 13
+	sftpRename  = 18
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpExtend  = 200
+
+	// sftpPosixRename is the OpenSSH extension replacing an existing file,
+	// which plain SFTP rename refuses to do.
+	sftpPosixRename = "posix-rename@openssh.com"
+
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpStatusOK = 0
+
+	// sftpChunk is the payload of a write request, which every server
+	// accepts, and sftpInFlight the number of writes sent ahead of their
+	// acknowledgement.
+	sftpChunk    = 32 * 1024
+	sftpInFlight = 64
+)
+
+// sftpStatusNames names the status codes servers report for failed requests.
+var sftpStatusNames = map[uint32]string{
+	1: "end of file",
+	2: "no such file",
+	3: "permission denied",
+	4: "failure",
+	5: "bad message",
+	8: "operation unsupported",
+}
+
+// sftpConn speaks SFTP over the stdin and stdout of a session running the
+// sftp subsystem.
+type sftpConn struct {
+	w      io.Writer
+	r      *bufio.Reader
+	nextID uint32
+	// extensions holds the extensions announced by the server.
+	extensions map[string]bool
+}
+
+func newSFTPConn(w io.Writer, r io.Reader) (*sftpConn, error) {
+	c := &sftpConn{w: w, r: bufio.NewReader(r), extensions: map[string]bool{}}
+	if err := c.send(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return nil, err
+	}
+	typ, payload, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion || len(payload) < 4 {
+		return nil, fmt.Errorf("unexpected sftp packet type %d in handshake", typ)
+	}
+	// The version is followed by pairs of extension name and data
+	for rest := payload[4:]; len(rest) > 0; {
+		name, ok := sftpString(rest)
+		if !ok {
+			break
+		}
+		rest = rest[4+len(name):]
+		data, ok := sftpString(rest)
+		if !ok {
+			break
+		}
+		rest = rest[4+len(data):]
+		c.extensions[name] = true
+	}
+	return c, nil
+}
+
+func (c *sftpConn) send(typ byte, payload []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)+1))
+	header[4] = typ
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+	_, err := c.w.Write(payload)
+	return err
+}
+
+func (c *sftpConn) recv() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > 256*1024 {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// request sends a packet prefixed with a new request id and returns the id.
+func (c *sftpConn) request(typ byte, payload []byte) (uint32, error) {
+	c.nextID++
+	return c.nextID, c.send(typ, append(binary.BigEndian.AppendUint32(nil, c.nextID), payload...))
+}
+
+// response reads the reply to a request, returning the payload after the id
+// of a handle reply and failing on an unsuccessful status.
+func (c *sftpConn) response(op string) ([]byte, error) {
+	typ, payload, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("short sftp response to %s", op)
+	}
+	payload = payload[4:]
+	switch typ {
+	case sftpHandle:
+		return payload, nil
+	case sftpStatus:
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("short sftp status for %s", op)
+		}
+		code := binary.BigEndian.Uint32(payload)
+		if code == sftpStatusOK {
+			return nil, nil
+		}
+		msg, _ := sftpString(payload[4:])
+		if msg == "" {
+			msg = sftpStatusNames[code]
+		}
+		return nil, fmt.Errorf("sftp %s failed: %s (status %d)", op, msg, code)
+	}
+	return nil, fmt.Errorf("unexpected sftp packet type %d for %s", typ, op)
+}
+
+// upload writes size bytes from r to path starting at offset start,
+// creating path or, when start is 0, truncating it. Writes are pipelined so
+// throughput does not depend on the round trip time.
+func (c *sftpConn) upload(r io.Reader, start, size int64, path string) error {
+	flags := uint32(sftpFlagWrite | sftpFlagCreat)
+	if start == 0 {
+		flags |= sftpFlagTrunc
+	}
+	open := appendSFTPString(nil, []byte(path))
+	open = binary.BigEndian.AppendUint32(open, flags)
+	open = binary.BigEndian.AppendUint32(open, 0) // no attributes
+	if _, err := c.request(sftpOpen, open); err != nil {
+		return err
+	}
+	reply, err := c.response("open " + path)
+	if err != nil {
+		return err
+	}
+	handle, ok := sftpString(reply)
+	if !ok {
+		return fmt.Errorf("invalid sftp handle for %s", path)
+	}
+
+	buf := make([]byte, sftpChunk)
+	inFlight := 0
+	offset, end := start, start+size
+	for offset < end {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), end-offset)])
+		if err != nil {
+			return err
+		}
+		write := appendSFTPString(nil, []byte(handle))
+		write = binary.BigEndian.AppendUint64(write, uint64(offset))
+		write = appendSFTPString(write, buf[:n])
+		if _, err := c.request(sftpWrite, write); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if inFlight++; inFlight == sftpInFlight {
+			if _, err := c.response("write"); err != nil {
+				return err
+			}
+			inFlight--
+		}
+	}
+	for ; inFlight > 0; inFlight-- {
+		if _, err := c.response("write"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.request(sftpClose, appendSFTPString(nil, []byte(handle))); err != nil {
+		return err
+	}
+	_, err = c.response("close")
+	return err
+}
+
+// rename moves oldPath to newPath, replacing newPath if it exists.
+func (c *sftpConn) rename(oldPath, newPath string) error {
+	paths := appendSFTPString(appendSFTPString(nil, []byte(oldPath)), []byte(newPath))
+	if c.extensions[sftpPosixRename] {
+		if _, err := c.request(sftpExtend, append(appendSFTPString(nil, []byte(sftpPosixRename)), paths...)); err != nil {
+			return err
+		}
+		_, err := c.response("rename " + oldPath)
+		return err
+	}
+	// Plain rename fails if the target exists; without the extension the
+	// replacement cannot be atomic
+	c.remove(newPath)
+	if _, err := c.request(sftpRename, paths); err != nil {
+		return err
+	}
+	_, err := c.response("rename " + oldPath)
+	return err
+}
+
+func (c *sftpConn) remove(path string) error {
+	if _, err := c.request(sftpRemove, appendSFTPString(nil, []byte(path))); err != nil {
+		return err
+	}
+	_, err := c.response("remove " + path)
+	return err
+}
+
+func appendSFTPString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func sftpString(b []byte) (string, bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", false
+	}
+	return string(b[4 : 4+n]), true
+}
@@ -1,89 +1,106 @@
 package ssh
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
-)
-
-type sshConfig struct {
-	HostName     string
-	User         string
-	Port         string
-	IdentityFile string
-}
-
-func parseSSHConfig(host string) (*sshConfig, error) {
-	config := &sshConfig{}
-	configFile := filepath.Join(os.Getenv("HOME"), ".ssh", "config")
-
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		return config, nil
-	}
 
-	file, err := os.Open(configFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	currentHost := ""
-	inMatchingHost := false
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-
-		key := strings.ToLower(fields[0])
-		value := strings.Join(fields[1:], " ")
-
-		if key == "host" {
-			currentHost = value
-			inMatchingHost = currentHost == host || currentHost == "*"
-			continue
-		}
+	"remote-pull/internal/console"
+)
 
-		if !inMatchingHost {
-			continue
-		}
+type Client struct {
+	*ssh.Client
 
-		switch key {
-		case "hostname":
-			config.HostName = value
-		case "user":
-			config.User = value
-		case "port":
-			config.Port = value
-		case "identityfile":
-			config.IdentityFile = strings.Replace(value, "~", os.Getenv("HOME"), 1)
-		}
-	}
+	// HostKeyKnown reports whether the server key was found in known_hosts
+	// (as opposed to an unknown host being accepted).
+	HostKeyKnown bool
 
-	return config, scanner.Err()
+	// target is the host as given by the caller, for the audit log.
+	target Target
+	// ctx aborts the commands run on the connection once it is done.
+	ctx context.Context
+	// pooled is set for connections owned by a Pool.
+	pooled bool
 }
 
-type Client struct {
-	*ssh.Client
+// Options tunes how connections to a remote host are established.
+type Options struct {
+	// Port overrides the port from ssh_config and the default of 22.
+	Port string
+	// ConnectTimeout bounds every connection attempt up to the key
+	// exchange. Zero uses ssh_config's ConnectTimeout, or no limit.
+	ConnectTimeout time.Duration
+	// ConnectionAttempts is the number of times a connection is tried
+	// before giving up on network failures. Zero uses ssh_config's
+	// ConnectionAttempts, or 4.
+	ConnectionAttempts int
+	// UpdateHostKey replaces a mismatching known_hosts entry instead of
+	// refusing to connect.
+	UpdateHostKey bool
+	// StrictHostKeyChecking decides about hosts without a known_hosts
+	// entry, one of HostKeyChecks. Empty uses ssh_config's
+	// StrictHostKeyChecking, or asks.
+	StrictHostKeyChecking string
+	// Transport selects how the server is reached.
+	Transport Transport
+	// Vault, when a role is set, authenticates with a certificate signed by
+	// Vault's SSH secrets engine.
+	Vault VaultOptions
+	// Secrets supplies passphrases of encrypted keys and passwords.
+	Secrets SecretOptions
+	// Copied, when set, is called with the number of bytes sent so far as a
+	// file copy advances.
+	Copied func(written int64)
+	// Offset continues an interrupted copy: the remote file already holds
+	// the first Offset bytes of the source, and the sftp and shell copies
+	// only append the rest.
+	Offset int64
+	// Compressor, when set, compresses the input of StreamRun and PipeRun
+	// on its way to the remote. Progress and Copied count the bytes before
+	// compression.
+	Compressor func(io.Writer) (io.WriteCloser, error)
+	// FIPS restricts connections to FIPS-approved algorithms and keys (see
+	// restrictToFIPS).
+	FIPS bool
+	// HostKeys pins the host key: the server must present a key with one of
+	// these fingerprints (see CheckHostKeyPin), and known_hosts and
+	// StrictHostKeyChecking do not apply.
+	HostKeys []string
+	// Pool, when set, provides the connection instead of a new one being
+	// dialed for every operation.
+	Pool *Pool
+	// Bandwidth, when set, limits the rate of file copies together with
+	// the other copies sharing it; BandwidthWeight is the share of this
+	// host relative to the others (default 1).
+	Bandwidth       *Bandwidth
+	BandwidthWeight float64
+
+	// jumpDepth counts the jump hosts this connection is made through.
+	jumpDepth int
 }
 
-func NewClient(user, host string) (*Client, error) {
+// NewClient connects to host as user. ctx bounds the connection attempts
+// and aborts the commands run on the client once it is done.
+func NewClient(ctx context.Context, user, host string, opts Options) (*Client, error) {
+	if opts.Pool != nil {
+		return opts.Pool.client(ctx, user, host, opts)
+	}
 	// Parse SSH config for this host
-	sshConfig, err := parseSSHConfig(host)
+	sshConfig, err := parseSSHConfig(host, user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse SSH config: %v", err)
 	}
@@ -94,87 +111,369 @@ func NewClient(user, host string) (*Client, error) {
 		effectiveHost = sshConfig.HostName
 	}
 
+	// As with OpenSSH, an explicit user takes precedence over ssh_config
 	effectiveUser := user
-	if sshConfig.User != "" {
+	if effectiveUser == "" {
 		effectiveUser = sshConfig.User
 	}
 
 	port := "22"
-	if sshConfig.Port != "" {
+	if opts.Port != "" {
+		port = opts.Port
+	} else if sshConfig.Port != "" {
 		port = sshConfig.Port
 	}
 
 	authMethods := []ssh.AuthMethod{}
+	// offered names the methods for the session recording
+	var offered []string
+
+	// All keys are offered by a single publickey method, the client does
+	// not try another one after the first failed
+	var sources []func() ([]ssh.Signer, error)
+
+	// A Vault-signed certificate is tried first, it is what the server
+	// expects when a role is configured
+	if opts.Vault.Role != "" {
+		signer, err := vaultSigner(opts.Vault, effectiveUser, opts.FIPS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain certificate from vault: %v", err)
+		}
+		sources = append(sources, func() ([]ssh.Signer, error) { return []ssh.Signer{signer}, nil })
+		offered = append(offered, "vault certificate "+ssh.FingerprintSHA256(signer.PublicKey()))
+	}
 
 	// Try SSH agent auth if available
 	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
 		if conn, err := net.Dial("unix", sock); err == nil {
-			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			sources = append(sources, agent.NewClient(conn).Signers)
+			offered = append(offered, "agent "+sock)
 		}
 	}
 
-	// Try public key auth from standard locations and config
-	keyPaths := []string{
-		filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"),
-		filepath.Join(os.Getenv("HOME"), ".ssh", "id_ecdsa"),
-		filepath.Join(os.Getenv("HOME"), ".ssh", "id_ed25519"),
-	}
-	if sshConfig.IdentityFile != "" {
-		keyPaths = append(keyPaths, sshConfig.IdentityFile)
+	// Try public key auth from config, or the standard locations when the
+	// config names no identities
+	keyPaths := sshConfig.IdentityFiles
+	if len(keyPaths) == 0 {
+		keyPaths = []string{
+			userPath("id_rsa"),
+			userPath("id_ecdsa"),
+			userPath("id_ed25519"),
+		}
 	}
 
 	for _, keyPath := range keyPaths {
 		if key, err := os.ReadFile(keyPath); err == nil {
-			if signer, err := ssh.ParsePrivateKey(key); err == nil {
-				authMethods = append(authMethods, ssh.PublicKeys(signer))
+			if signer, err := parsePrivateKey(key, keyPath, opts.Secrets); err == nil {
+				sources = append(sources, func() ([]ssh.Signer, error) { return []ssh.Signer{signer}, nil })
+				description := "publickey " + keyPath + " " + ssh.FingerprintSHA256(signer.PublicKey())
+				if _, ok := signer.(*encryptedSigner); ok {
+					description += " (encrypted)"
+				}
+				offered = append(offered, description)
 			}
 		}
 	}
 
+	if len(sources) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			for _, source := range sources {
+				// A failing source, like an agent that went away, leaves
+				// the others to try
+				if s, err := source(); err == nil {
+					signers = append(signers, s...)
+				}
+			}
+			if opts.FIPS {
+				signers = fipsSigners(signers)
+			}
+			return signers, nil
+		}))
+	}
+
 	// Fall back to password auth if no other methods worked
-	authMethods = append(authMethods, ssh.Password(""))
+	if opts.Secrets.enabled() {
+		account := "password:" + effectiveUser + "@" + host
+		attempts := 0
+		password := ssh.PasswordCallback(func() (string, error) {
+			// Asking again means the password entered before was wrong
+			if attempts++; attempts > 1 {
+				forgetPrompted(account)
+			}
+			password, err := opts.Secrets.lookup(account)
+			if err != nil {
+				return "", err
+			}
+			defer clear(password)
+			return string(password), nil
+		})
+		if opts.Secrets.prompting() {
+			password = ssh.RetryableAuthMethod(password, promptAttempts)
+		}
+		authMethods = append(authMethods, password)
+		offered = append(offered, "password")
+	} else {
+		authMethods = append(authMethods, ssh.Password(""))
+		offered = append(offered, "password (empty)")
+	}
+
+	hostKeyKnown := false
+	mode, err := hostKeyMode(opts.StrictHostKeyChecking, sshConfig.option("stricthostkeychecking"))
+	if err != nil {
+		return nil, err
+	}
+	var verifyHostKey ssh.HostKeyCallback
+	if len(opts.HostKeys) > 0 {
+		verifyHostKey = pinnedHostKeyCallback(opts.HostKeys, &hostKeyKnown)
+	} else if verifyHostKey, err = hostKeyCallback(mode, opts.UpdateHostKey, !opts.Secrets.Batch && stdinIsTerminal(), &hostKeyKnown); err != nil {
+		return nil, err
+	}
 
 	config := &ssh.ClientConfig{
 		User:            effectiveUser,
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: verifyHostKey,
+	}
+	if opts.FIPS {
+		restrictToFIPS(config)
+	}
+	// The server presents the key of the first algorithm both sides support,
+	// which must be a pinned one
+	if algorithms := pinnedAlgorithms(opts.HostKeys); algorithms != nil {
+		if opts.FIPS {
+			algorithms = slices.DeleteFunc(algorithms, func(a string) bool { return !slices.Contains(config.HostKeyAlgorithms, a) })
+		}
+		config.HostKeyAlgorithms = algorithms
 	}
 
-	client, err := ssh.Dial("tcp", effectiveHost+":"+port, config)
+	// A transport tunnel takes precedence over ssh_config's ProxyJump and
+	// ProxyCommand, and jump hosts over ProxyCommand
+	proxyCommand, err := opts.Transport.proxyCommand()
+	if err != nil {
+		return nil, err
+	}
+	tunnelCommand, err := opts.Transport.tunnelCommand()
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial: %v", err)
+		return nil, err
+	}
+	jumpSpec := opts.Transport.Jump
+	if jumpSpec == "" {
+		jumpSpec = sshConfig.option("proxyjump")
+	}
+	jumps, err := parseJumps(jumpSpec)
+	if err != nil {
+		return nil, err
+	}
+	if len(jumps) > 0 && (proxyCommand != "" || tunnelCommand != "") {
+		if opts.Transport.Jump != "" {
+			return nil, fmt.Errorf("jump hosts cannot be combined with the %s transport", opts.Transport.Name)
+		}
+		jumps = nil
+	}
+	if opts.jumpDepth+len(jumps) > maxJumpDepth {
+		return nil, fmt.Errorf("more than %d jump hosts to reach %s, check ProxyJump in ssh_config for loops", maxJumpDepth, host)
+	}
+	if proxyCommand == "" && len(jumps) == 0 && !strings.EqualFold(sshConfig.option("proxycommand"), "none") {
+		proxyCommand = sshConfig.option("proxycommand")
 	}
 
-	return &Client{client}, nil
+	addr := net.JoinHostPort(effectiveHost, port)
+	tokens := map[byte]string{
+		'h': effectiveHost,
+		'n': host,
+		'p': port,
+		'r': effectiveUser,
+	}
+	connect := func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	switch {
+	case tunnelCommand != "":
+		tunnelCommand = expandPercent(tunnelCommand, shellTokens(tokens))
+		connect = func(context.Context) (net.Conn, error) {
+			return dialLocalTunnel(tunnelCommand)
+		}
+	case len(jumps) > 0:
+		connect = dialJump(addr, jumps, opts)
+	case proxyCommand != "":
+		proxyCommand = expandPercent(proxyCommand, shellTokens(tokens))
+		connect = func(context.Context) (net.Conn, error) {
+			return dialProxyCommand(proxyCommand)
+		}
+	}
+
+	target := Target{User: effectiveUser, Host: host, Port: port}
+	via := tunnelCommand
+	if via == "" && len(jumps) > 0 {
+		via = "jump " + joinJumps(jumps)
+	}
+	if via == "" {
+		via = proxyCommand
+	}
+	recorded := recordConnect(target, addr, via, offered, config)
+	timeout := opts.ConnectTimeout
+	if seconds, err := strconv.Atoi(sshConfig.option("connecttimeout")); err == nil && timeout == 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	attempts := opts.ConnectionAttempts
+	if n, err := strconv.Atoi(sshConfig.option("connectionattempts")); err == nil && attempts == 0 {
+		attempts = n
+	}
+	if attempts <= 0 {
+		attempts = dialAttempts
+	}
+	client, err := dial(ctx, addr, config, timeout, attempts, connect)
+	recorded(client, hostKeyKnown, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+
+	return &Client{Client: client, HostKeyKnown: hostKeyKnown, target: target, ctx: ctx}, nil
+}
+
+// parsePrivateKey parses key. An encrypted key is decrypted with the
+// passphrase from secrets, only once the server accepts it when its public
+// key is known (see encryptedSigner).
+func parsePrivateKey(key []byte, keyPath string, secrets SecretOptions) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) || !secrets.enabled() {
+		return signer, err
+	}
+	public := missing.PublicKey
+	if public == nil {
+		// Keys in the legacy PEM format only carry it in the .pub file
+		if data, err := os.ReadFile(keyPath + ".pub"); err == nil {
+			public, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+		}
+	}
+	if public == nil {
+		return decryptPrivateKey(key, keyPath, secrets)
+	}
+	return &encryptedSigner{key: key, path: keyPath, secrets: secrets, public: public}, nil
 }
 
-func RunCommand(cmd, user, host string) (string, error) {
-	client, err := NewClient(user, host)
+// decryptPrivateKey decrypts key with the passphrase from secrets, asking
+// again when a passphrase entered at the prompt is wrong.
+func decryptPrivateKey(key []byte, keyPath string, secrets SecretOptions) (ssh.Signer, error) {
+	account := "passphrase:" + keyPath
+	for attempt := 1; ; attempt++ {
+		passphrase, err := secrets.lookup(account)
+		if err != nil {
+			console.Printf("[WARNING] Skipping encrypted key %s: %v\n", keyPath, err)
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+		clear(passphrase)
+		if err == nil {
+			return signer, nil
+		}
+		if !errors.Is(err, x509.IncorrectPasswordError) || !secrets.prompting() || attempt == promptAttempts {
+			console.Printf("[WARNING] Skipping encrypted key %s: %v\n", keyPath, err)
+			return nil, err
+		}
+		console.Printf("[WARNING] Wrong passphrase for %s\n", keyPath)
+		forgetPrompted(account)
+	}
+}
+
+// encryptedSigner stands in for an encrypted private key whose public key is
+// known. The key is decrypted when the server asks for a signature, which it
+// only does after accepting the public key, so there is no passphrase prompt
+// for keys the server does not know or when an earlier key succeeded.
+type encryptedSigner struct {
+	key     []byte
+	path    string
+	secrets SecretOptions
+	public  ssh.PublicKey
+
+	mu     sync.Mutex
+	signer ssh.AlgorithmSigner
+	err    error
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.public
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.mu.Lock()
+	if s.signer == nil && s.err == nil {
+		var signer ssh.Signer
+		if signer, s.err = decryptPrivateKey(s.key, s.path, s.secrets); s.err == nil {
+			var ok bool
+			if s.signer, ok = signer.(ssh.AlgorithmSigner); !ok {
+				s.err = fmt.Errorf("key %s does not support signature algorithms", s.path)
+			}
+		}
+	}
+	signer, err := s.signer, s.err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func RunCommand(ctx context.Context, cmd, user, host string, opts Options) (string, error) {
+	client, err := NewClient(ctx, user, host, opts)
 	if err != nil {
 		return "", err
 	}
 	defer client.Close()
 
-	session, err := client.NewSession()
+	return client.Run(cmd)
+}
+
+// Run executes cmd in a new session on the established connection and
+// returns its stdout. Stderr is passed through to the console.
+func (c *Client) Run(cmd string) (string, error) {
+	return c.RunInput(cmd, nil)
+}
+
+// RunInput is like Run but feeds stdin to the command, which keeps secrets
+// off the remote command line and disk.
+func (c *Client) RunInput(cmd string, stdin io.Reader) (string, error) {
+	session, err := c.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %v", err)
 	}
 	defer session.Close()
 
-	// Connect command's stdout/stderr directly to console
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
+	// Capture stdout for the caller, pass stderr through to the console
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	session.Stdin = stdin
+
+	if err := c.runSession(session, cmd); err != nil {
+		return "", err
+	}
 
-	err = session.Run(cmd)
+	return stdout.String(), nil
+}
+
+// Stream runs cmd with stdin as its input and passes its output through to
+// the console as it is produced, e.g. for a remote build.
+func (c *Client) Stream(cmd string, stdin io.Reader) error {
+	session, err := c.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("command failed: %v", err)
+		return fmt.Errorf("failed to create session: %v", err)
 	}
+	defer session.Close()
 
-	return "", nil
+	session.Stdout = console.Writer("")
+	session.Stdin = stdin
+	return c.runSession(session, cmd)
 }
 
-func TransferFile(src, dest, user, host string) error {
-	client, err := NewClient(user, host)
+func TransferFile(ctx context.Context, src, dest, user, host string, opts Options) error {
+	client, err := NewClient(ctx, user, host, opts)
 	if err != nil {
 		return err
 	}
@@ -194,113 +493,160 @@ func TransferFile(src, dest, user, host string) error {
 		io.Copy(w, f)
 	}()
 
-	if err := session.Run(fmt.Sprintf("/usr/bin/scp -qt %s", dest)); err != nil {
+	if err := client.runSession(session, fmt.Sprintf("scp -qt %s", dest)); err != nil {
 		return fmt.Errorf("failed to transfer file: %v", err)
 	}
 
 	return nil
 }
 
-func CopyAndRun(src, command, user, host string) error {
-	client, err := NewClient(user, host)
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
-	// Create a session for file transfer
-	transferSession, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create transfer session: %v", err)
-	}
-	defer transferSession.Close()
-
-	// Transfer the file with progress
-	transferDone := make(chan error)
-	go func() {
-		defer close(transferDone)
-
-		w, err := transferSession.StdinPipe()
+// CopyAndRun copies src into the remote directory destDir using the SCP sink
+// protocol and then runs command on the remote host. destDir must already be
+// quoted for the remote shell. The command is aborted if it runs longer than
+// timeout; zero disables the limit.
+func CopyAndRun(ctx context.Context, src, destDir, command, user, host string, timeout time.Duration, opts Options) error {
+	// The SCP sink always writes the whole file
+	opts.Offset = 0
+	return copyAndRun(ctx, src, command, user, host, timeout, opts, func(client *Client, f *os.File, size int64, pw *progressWriter) error {
+		// Create a session for file transfer
+		transferSession, err := client.NewSession()
 		if err != nil {
-			transferDone <- err
-			return
+			return fmt.Errorf("failed to create transfer session: %v", err)
 		}
-		defer w.Close()
+		defer transferSession.Close()
 
-		fileInfo, err := os.Stat(src)
-		if err != nil {
-			transferDone <- err
-			return
-		}
-		fmt.Fprintf(w, "C0644 %d %s\n", fileInfo.Size(), filepath.Base(src))
-
-		f, err := os.Open(src)
-		if err != nil {
-			transferDone <- err
-			return
-		}
-		defer f.Close()
+		transferDone := make(chan error, 1)
+		go func() {
+			defer close(transferDone)
 
-		fileInfo, err = f.Stat()
-		if err != nil {
-			transferDone <- err
-			return
-		}
-		totalBytes := fileInfo.Size()
-		var copiedBytes int64
-		buf := make([]byte, 32*1024) // 32KB buffer
-
-		for {
-			n, err := f.Read(buf)
-			if n > 0 {
-				if _, err := w.Write(buf[:n]); err != nil {
-					transferDone <- err
-					return
-				}
-				copiedBytes += int64(n)
-				progress := float64(copiedBytes) / float64(totalBytes) * 100
-				fmt.Printf("\rTransferring: %.2f%%", progress)
-			}
-			if err == io.EOF {
-				break
-			}
+			w, err := transferSession.StdinPipe()
 			if err != nil {
 				transferDone <- err
 				return
 			}
+			defer w.Close()
+
+			// Announce and send exactly the size observed on the open file,
+			// so the SCP header always matches the payload. Sizes are int64
+			// throughout; archives beyond 4 GB need no special handling.
+			io.WriteString(w, scpHeader(size, src))
+			pw.w = pw.limit(w)
+			if err := copyFile(pw, f, size); err != nil {
+				transferDone <- err
+				return
+			}
+			fmt.Fprint(w, "\x00")
+		}()
+
+		// Execute the SCP command to receive the file
+		transferSession.Stdout = console.Writer("")
+
+		if err := client.runSession(transferSession, "scp -qt "+destDir); err != nil {
+			return unsupported(StrategySCP, fmt.Errorf("scp transfer failed: %v", err), err)
 		}
-		fmt.Fprint(w, "\x00")
-		fmt.Println() // New line after progress
-	}()
 
-	// Execute the SCP command to receive the file
-	transferSession.Stdout = os.Stdout
-	transferSession.Stderr = os.Stderr
+		// Wait for transfer to complete
+		if err := <-transferDone; err != nil {
+			return fmt.Errorf("file copy failed: %v", err)
+		}
+		return nil
+	})
+}
+
+// scpHeader returns the SCP sink header announcing size bytes of src.
+func scpHeader(size int64, src string) string {
+	return fmt.Sprintf("C0644 %d %s\n", size, filepath.Base(src))
+}
 
-	if err := transferSession.Run("/usr/bin/scp -qt /tmp"); err != nil {
-		return fmt.Errorf("scp transfer failed: %v", err)
+// copyAndRun connects to the remote, sends src with copy and then runs
+// command in a new session, reconnecting if the connection dropped after the
+// copy finished.
+func copyAndRun(ctx context.Context, src, command, user, host string, timeout time.Duration, opts Options, copy func(client *Client, f *os.File, size int64, pw *progressWriter) error) error {
+	client, err := NewClient(ctx, user, host, opts)
+	if err != nil {
+		return err
 	}
+	defer client.Close()
 
-	// Wait for transfer to complete
-	if err := <-transferDone; err != nil {
-		return fmt.Errorf("file copy failed: %v", err)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if opts.Offset > 0 {
+		if _, err := f.Seek(opts.Offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	// Transfer the file with progress
+	pw, endProgress := newProgressWriter(host, src, fileInfo.Size(), opts)
+	pw.written = opts.Offset
+	err = copy(client, f, fileInfo.Size()-opts.Offset, pw)
+	endProgress()
+	if err != nil {
+		return client.aborted(err)
 	}
 
-	// Create a new session for executing the command
 	commandSession, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create command session: %v", err)
+		console.Printf("[RECONNECT] Connection to %s lost after copy (%v), reconnecting\n", host, err)
+		client.Close()
+		if client, err = NewClient(ctx, user, host, opts); err != nil {
+			return err
+		}
+		defer client.Close()
+		if commandSession, err = client.NewSession(); err != nil {
+			return fmt.Errorf("failed to create command session: %v", err)
+		}
 	}
 	defer commandSession.Close()
 
 	// Set up output for the command
-	commandSession.Stdout = os.Stdout
-	commandSession.Stderr = os.Stderr
+	commandSession.Stdout = console.Writer("")
 
 	// Execute the final command in the new session
-	fmt.Printf("Running command on remote server: %s\n", command)
-	if err := commandSession.Run(command); err != nil {
-		return fmt.Errorf("command failed: %v", err)
+	console.Printf("Running command on remote server: %s\n", command)
+	if err := client.runSessionTimeout(commandSession, command, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newProgressWriter reports the progress of sending src to host on the
+// console and to opts.Copied, and returns the function removing the
+// progress line. The caller sets the destination writer, passed through
+// limit to apply opts.Bandwidth.
+func newProgressWriter(host, src string, total int64, opts Options) (*progressWriter, func()) {
+	progressID := host + ":" + src
+	pw := &progressWriter{total: total, copied: opts.Copied, report: func(percent float64) {
+		console.Progress(progressID, "Transferring to %s: %.2f%%", host, percent)
+	}}
+	if opts.Bandwidth != nil {
+		pw.flow = opts.Bandwidth.join(opts.BandwidthWeight)
+	}
+	return pw, func() {
+		if pw.flow != nil {
+			pw.flow.leave()
+			pw.flow = nil
+		}
+		console.EndProgress(progressID)
+	}
+}
+
+// copyFile sends exactly size bytes of f through pw.
+func copyFile(pw *progressWriter, f *os.File, size int64) error {
+	n, err := io.CopyBuffer(pw, io.LimitReader(f, size), make([]byte, 32*1024))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("short read: sent %d of %d bytes", n, size)
 	}
 	return nil
 }
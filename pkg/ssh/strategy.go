@@ -0,0 +1,281 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"remote-pull/internal/console"
+)
+
+// Strategies for getting a file to the remote and running a command on it,
+// from the one needing the least disk and round trips to the one needing the
+// least of the remote.
+const (
+	// StrategyStream feeds the file to the command's stdin, nothing is
+	// written to the remote disk.
+	StrategyStream = "stream"
+	// StrategySFTP uploads through the sftp subsystem, which works without
+	// any remote binaries in the PATH and from restricted shells.
+	StrategySFTP = "sftp"
+	// StrategySCP uploads with the remote scp binary in sink mode.
+	StrategySCP = "scp"
+	// StrategyShell writes the file with cat through the remote shell.
+	StrategyShell = "shell"
+)
+
+// UnsupportedError reports that the remote lacks what a strategy needs, e.g.
+// the scp binary or the sftp subsystem, or that its shell refused the
+// command. Another strategy may still work.
+type UnsupportedError struct {
+	Strategy string
+	Err      error
+}
+
+func (e *UnsupportedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnsupportedError) Unwrap() error {
+	return e.Err
+}
+
+// unsupported returns err as UnsupportedError when cause shows that the
+// remote could not run the command at all: it was not found or not
+// executable, or a restricted shell or ForceCommand wrapper rejected it.
+func unsupported(strategy string, err, cause error) error {
+	var cmdErr *CommandError
+	if !errors.As(cause, &cmdErr) {
+		return err
+	}
+	stderr := strings.ToLower(cmdErr.Stderr)
+	switch {
+	case cmdErr.ExitStatus == 126, cmdErr.ExitStatus == 127,
+		strings.Contains(stderr, "command not found"),
+		strings.Contains(stderr, "restricted"),
+		strings.Contains(stderr, "not allowed"),
+		strings.Contains(stderr, "not recognized as"):
+		return &UnsupportedError{Strategy: strategy, Err: err}
+	}
+	return err
+}
+
+// StreamRun runs command on the remote with src as its stdin, e.g. docker
+// load reading the archive as it arrives. The command is aborted if it runs
+// longer than timeout; zero disables the limit.
+func StreamRun(ctx context.Context, src, command, user, host string, timeout time.Duration, opts Options) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return PipeRun(ctx, f, fileInfo.Size(), src, command, user, host, timeout, opts)
+}
+
+// PipeRun is like StreamRun but reads the input from r as it is produced,
+// e.g. from a running docker save. size is used for the progress display
+// only and may be an estimate; name identifies the input in it.
+func PipeRun(ctx context.Context, r io.Reader, size int64, name, command, user, host string, timeout time.Duration, opts Options) error {
+	client, err := NewClient(ctx, user, host, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	copyDone := make(chan error, 1)
+	go func() {
+		defer close(copyDone)
+		defer w.Close()
+		pw, endProgress := newProgressWriter(host, name, size, opts)
+		defer endProgress()
+		// The limit applies to the bytes on the wire, after compression
+		lw := pw.limit(w)
+		pw.w = lw
+		var zw io.WriteCloser
+		if opts.Compressor != nil {
+			var err error
+			if zw, err = opts.Compressor(lw); err != nil {
+				copyDone <- err
+				return
+			}
+			pw.w = zw
+		}
+		_, err := io.CopyBuffer(pw, r, make([]byte, 32*1024))
+		if zw != nil && err == nil {
+			err = zw.Close()
+		}
+		copyDone <- err
+	}()
+
+	session.Stdout = console.Writer("")
+	console.Printf("Running command on remote server: %s\n", command)
+	if err := client.runSessionTimeout(session, command, timeout); err != nil {
+		return unsupported(StrategyStream, err, err)
+	}
+	if err := <-copyDone; err != nil {
+		return fmt.Errorf("copy failed: %v", err)
+	}
+	return nil
+}
+
+// FetchRun runs command on the remote and copies its stdout to w as it is
+// produced, the reverse of PipeRun, e.g. a docker save sending an image
+// back. size is used for the progress display only and may be an estimate;
+// name identifies the output in it.
+func FetchRun(ctx context.Context, w io.Writer, size int64, name, command, user, host string, opts Options) error {
+	client, err := NewClient(ctx, user, host, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	progressID := host + ":" + name
+	defer console.EndProgress(progressID)
+	session.Stdout = &progressWriter{w: w, total: size, copied: opts.Copied, report: func(percent float64) {
+		console.Progress(progressID, "Fetching from %s: %.2f%%", host, percent)
+	}}
+	console.Printf("Running command on remote server: %s\n", command)
+	return client.runSession(session, command)
+}
+
+// SFTPCopyAndRun uploads src to path over SFTP and then runs command on the
+// remote. path is a plain SFTP path, not quoted for any shell. With
+// opts.Offset the rest of src is written after the first Offset bytes of
+// path.
+func SFTPCopyAndRun(ctx context.Context, src, path, command, user, host string, timeout time.Duration, opts Options) error {
+	return copyAndRun(ctx, src, command, user, host, timeout, opts, func(client *Client, f *os.File, size int64, pw *progressWriter) error {
+		session, conn, err := client.sftp()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		defer trackSession(session)()
+		defer client.abortOnCancel(session)()
+
+		pw.w = pw.limit(io.Discard)
+		if err := conn.upload(io.TeeReader(f, pw), opts.Offset, size, path); err != nil {
+			return fmt.Errorf("sftp transfer failed: %v", err)
+		}
+		return nil
+	})
+}
+
+// SFTPUpload places src at path using nothing but SFTP, for accounts that
+// may not run any command. The file is written under a temporary name next
+// to path and renamed once complete, so a partial file never appears at
+// path.
+func SFTPUpload(ctx context.Context, src, path, user, host string, opts Options) error {
+	client, err := NewClient(ctx, user, host, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	session, conn, err := client.sftp()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer client.abortOnCancel(session)()
+
+	partial := path + ".partial"
+	pw, endProgress := newProgressWriter(host, src, fileInfo.Size(), opts)
+	defer endProgress()
+	pw.w = pw.limit(io.Discard)
+	if err := conn.upload(io.TeeReader(f, pw), 0, fileInfo.Size(), partial); err != nil {
+		conn.remove(partial)
+		return fmt.Errorf("sftp transfer failed: %v", client.aborted(err))
+	}
+	if err := conn.rename(partial, path); err != nil {
+		conn.remove(partial)
+		return fmt.Errorf("failed to move %s into place: %v", partial, err)
+	}
+	return nil
+}
+
+// sftp starts the sftp subsystem in a new session.
+func (c *Client) sftp() (*ssh.Session, *sftpConn, error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create transfer session: %v", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, nil, &UnsupportedError{Strategy: StrategySFTP, Err: fmt.Errorf("sftp subsystem not available: %v", err)}
+	}
+	conn, err := newSFTPConn(w, r)
+	if err != nil {
+		session.Close()
+		return nil, nil, &UnsupportedError{Strategy: StrategySFTP, Err: fmt.Errorf("sftp handshake failed: %v", err)}
+	}
+	return session, conn, nil
+}
+
+// ShellCopyAndRun writes src to path with cat through the remote shell and
+// then runs command, for remotes without scp and sftp. path must already be
+// quoted for the remote shell. With opts.Offset the rest of src is appended
+// to path.
+func ShellCopyAndRun(ctx context.Context, src, path, command, user, host string, timeout time.Duration, opts Options) error {
+	return copyAndRun(ctx, src, command, user, host, timeout, opts, func(client *Client, f *os.File, size int64, pw *progressWriter) error {
+		r, w := io.Pipe()
+		go func() {
+			pw.w = w
+			w.CloseWithError(copyFile(pw, f, size))
+		}()
+		redirect := " > "
+		if opts.Offset > 0 {
+			redirect = " >> "
+		}
+		if _, err := client.RunInput("cat"+redirect+path, r); err != nil {
+			r.Close()
+			return unsupported(StrategyShell, fmt.Errorf("shell transfer failed: %v", err), err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,208 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Target is a parsed destination of the form user@host[:port].
+type Target struct {
+	User string
+	Host string
+	// Port is empty when the target does not specify one.
+	Port string
+}
+
+// ParseTarget parses user@host, user@host:port, user@[ipv6] and
+// user@[ipv6]:port. The user may be omitted for ssh_config aliases, which is
+// checked by ValidateTarget. An unbracketed IPv6 address is accepted as long
+// as it carries no port.
+func ParseTarget(s string) (Target, error) {
+	if s == "" {
+		return Target{}, fmt.Errorf("empty remote server, expected user@host[:port] or an ssh_config alias")
+	}
+	if strings.ContainsAny(s, " \t\r\n") {
+		return Target{}, fmt.Errorf("invalid remote server %q: contains whitespace", s)
+	}
+
+	var t Target
+	hostPort := s
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		if i == 0 {
+			return Target{}, fmt.Errorf("invalid remote server %q: empty user before '@'", s)
+		}
+		if i == len(s)-1 {
+			return Target{}, fmt.Errorf("invalid remote server %q: missing host after '@'", s)
+		}
+		t.User = s[:i]
+		hostPort = s[i+1:]
+		// The user is passed to proxy commands (%r) run by the shell
+		if strings.HasPrefix(t.User, "-") {
+			return Target{}, fmt.Errorf("invalid remote server %q: user %q starts with '-'", s, t.User)
+		}
+		if i := strings.IndexFunc(t.User, isUserMetachar); i >= 0 {
+			return Target{}, fmt.Errorf("invalid remote server %q: user %q contains invalid character %q", s, t.User, t.User[i])
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(hostPort, "["):
+		end := strings.Index(hostPort, "]")
+		if end < 0 {
+			return Target{}, fmt.Errorf("invalid remote server %q: missing closing bracket", s)
+		}
+		t.Host = hostPort[1:end]
+		rest := hostPort[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return Target{}, fmt.Errorf("invalid remote server %q: unexpected %q after address", s, rest)
+			}
+			t.Port = rest[1:]
+			if t.Port == "" {
+				return Target{}, fmt.Errorf("invalid remote server %q: empty port", s)
+			}
+		}
+	case strings.Count(hostPort, ":") == 1:
+		t.Host, t.Port, _ = strings.Cut(hostPort, ":")
+		if t.Port == "" {
+			return Target{}, fmt.Errorf("invalid remote server %q: empty port", s)
+		}
+	default:
+		// Plain host name, IPv4 address or bare IPv6 address
+		t.Host = hostPort
+	}
+
+	if t.Host == "" {
+		return Target{}, fmt.Errorf("invalid remote server %q: empty host", s)
+	}
+	if strings.Contains(t.Host, ":") {
+		if net.ParseIP(t.Host) == nil {
+			return Target{}, fmt.Errorf("invalid remote server %q: %q is not a valid IPv6 address", s, t.Host)
+		}
+	} else if i := strings.IndexFunc(t.Host, func(r rune) bool { return !isHostChar(r) }); i >= 0 {
+		return Target{}, fmt.Errorf("invalid remote server %q: host %q contains invalid character %q", s, t.Host, t.Host[i])
+	}
+	if t.Port != "" {
+		if n, err := strconv.Atoi(t.Port); err != nil || n < 1 || n > 65535 {
+			return Target{}, fmt.Errorf("invalid remote server %q: bad port %q", s, t.Port)
+		}
+	}
+	return t, nil
+}
+
+// userMetachars are the shell metacharacters refused in user names, along
+// with control characters, as by OpenSSH.
+const userMetachars = "'`\"$\\;&<>|(){}[]*?!#~"
+
+func isUserMetachar(r rune) bool {
+	return strings.ContainsRune(userMetachars, r) || unicode.IsControl(r)
+}
+
+func isHostChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_'
+}
+
+// ValidateTarget checks that a connection to t can be attempted: a target
+// without user must be an ssh_config alias that provides one. Errors suggest
+// the closest configured alias when the host looks like a typo.
+func ValidateTarget(t Target) error {
+	if t.User != "" {
+		return nil
+	}
+	config, err := parseSSHConfig(t.Host, "")
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH config: %v", err)
+	}
+	if config.User != "" {
+		return nil
+	}
+
+	aliases, err := configAliases()
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH config: %v", err)
+	}
+	msg := fmt.Sprintf("missing user and no User in ssh_config for alias '%s'", t.Host)
+	isAlias := false
+	for _, alias := range aliases {
+		if strings.EqualFold(alias, t.Host) {
+			isAlias = true
+		}
+	}
+	if !isAlias {
+		if suggestion := closestAlias(t.Host, aliases); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+		} else {
+			msg = fmt.Sprintf("missing user for '%s' and no ssh_config alias provides one; use user@%s", t.Host, t.Host)
+		}
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// closestAlias returns the alias with the smallest edit distance to host, if
+// it is close enough to plausibly be a typo.
+func closestAlias(host string, aliases []string) string {
+	best, bestDist := "", 0
+	for _, alias := range aliases {
+		d := editDistance(strings.ToLower(host), strings.ToLower(alias))
+		if best == "" || d < bestDist {
+			best, bestDist = alias, d
+		}
+	}
+	if best == "" || bestDist > 2 || bestDist >= len(host) {
+		return ""
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Address returns the target as host:port, taking the port from ssh_config
+// or the default 22 when the target has none. It identifies the host key
+// the target presents, whatever the user.
+func (t Target) Address() string {
+	port := t.Port
+	if port == "" {
+		port = "22"
+		if config, err := parseSSHConfig(t.Host, t.User); err == nil && config.Port != "" {
+			port = config.Port
+		}
+	}
+	return net.JoinHostPort(t.Host, port)
+}
+
+// String formats the target so that it can be parsed again.
+func (t Target) String() string {
+	host := t.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if t.Port != "" {
+		host += ":" + t.Port
+	}
+	if t.User == "" {
+		return host
+	}
+	return t.User + "@" + host
+}
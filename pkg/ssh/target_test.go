@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Target
+	}{
+		{"user@host", Target{User: "user", Host: "host"}},
+		{"user@host:2222", Target{User: "user", Host: "host", Port: "2222"}},
+		{"alias", Target{Host: "alias"}},
+		{"user@[::1]:22", Target{User: "user", Host: "::1", Port: "22"}},
+		{"user@fe80::1", Target{User: "user", Host: "fe80::1"}},
+		{"john@corp.example@host", Target{User: "john@corp.example", Host: "host"}},
+		{"deploy.user-1@host", Target{User: "deploy.user-1", Host: "host"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.s)
+		if err != nil {
+			t.Errorf("ParseTarget(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+		if again, err := ParseTarget(got.String()); err != nil || again != got {
+			t.Errorf("ParseTarget(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+}
+
+func TestParseTargetErrors(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", "empty remote server"},
+		{"user@", "missing host"},
+		{"@host", "empty user"},
+		{"user@host:0", "bad port"},
+		{"user@host:", "empty port"},
+		{"user@[::1]:", "empty port"},
+		{"user@host name", "whitespace"},
+		{"user@ho$t", "invalid character"},
+		{"a$(id)@host", "invalid character '$'"},
+		{"a`id`@host", "invalid character '`'"},
+		{"a;id@host", "invalid character ';'"},
+		{"a|id@host", "invalid character '|'"},
+		{"a'b@host", "invalid character '\\''"},
+		{"a\x01b@host", "invalid character '\\x01'"},
+		{"-oProxyCommand=id@host", "starts with '-'"},
+	}
+	for _, tt := range tests {
+		_, err := ParseTarget(tt.s)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseTarget(%q) = %v, want an error containing %q", tt.s, err, tt.want)
+		}
+	}
+}
+
+func TestShellTokens(t *testing.T) {
+	tokens := shellTokens(map[byte]string{'h': "host.example", 'p': "22", 'r': "a$(id)"})
+	if got, want := expandPercent("tsh proxy ssh %r@%h:%p", tokens), `tsh proxy ssh 'a$(id)'@host.example:22`; got != want {
+		t.Errorf("expanded %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TransportSSH        = "ssh"
+	TransportTeleport   = "teleport"
+	TransportSSM        = "ssm"
+	TransportIAP        = "iap"
+	TransportBastion    = "bastion"
+	TransportTailscale  = "tailscale"
+	TransportCloudflare = "cloudflare"
+)
+
+// Transport selects how the connection to the SSH server is established.
+// The direct transport dials TCP (or uses ssh_config's ProxyCommand); the
+// others tunnel the SSH stream through a vendor CLI.
+type Transport struct {
+	Name string
+
+	// TeleportCluster selects the Teleport cluster; empty uses the cluster
+	// of the current tsh profile.
+	TeleportCluster string
+
+	// InstanceID is the EC2 instance reached through SSM Session Manager;
+	// empty uses the host name, which then must be an instance ID.
+	InstanceID string
+
+	// GCPProject, GCPZone and GCPInstance locate the GCE instance reached
+	// through an IAP TCP forwarding tunnel; an empty instance uses the host
+	// name.
+	GCPProject  string
+	GCPZone     string
+	GCPInstance string
+
+	// BastionName and BastionResourceGroup name the Azure Bastion host that
+	// tunnels to the VM identified by AzureVMID (its full resource ID).
+	BastionName          string
+	BastionResourceGroup string
+	AzureVMID            string
+
+	// Jump lists the hosts the connection is forwarded through, as
+	// [user@]host[:port] separated by commas like ssh -J. Empty uses
+	// ssh_config's ProxyJump.
+	Jump string
+}
+
+// proxyCommand returns the command providing the tunnel for t, or "" when
+// the server is dialed directly. Tokens (%h, %p, %r) are expanded later.
+func (t Transport) proxyCommand() (string, error) {
+	switch t.Name {
+	case "", TransportSSH, TransportBastion:
+		return "", nil
+	case TransportTeleport:
+		cmd := "tsh proxy ssh"
+		if t.TeleportCluster != "" {
+			cmd += " --cluster=" + shellQuote(t.TeleportCluster)
+		}
+		return cmd + " %r@%h:%p", nil
+	case TransportSSM:
+		target := "%h"
+		if t.InstanceID != "" {
+			target = shellQuote(t.InstanceID)
+		}
+		return "aws ssm start-session --target " + target +
+			" --document-name AWS-StartSSHSession --parameters portNumber=%p", nil
+	case TransportIAP:
+		instance := "%h"
+		if t.GCPInstance != "" {
+			instance = shellQuote(t.GCPInstance)
+		}
+		cmd := "gcloud compute start-iap-tunnel " + instance + " %p --listen-on-stdin --verbosity=warning"
+		if t.GCPProject != "" {
+			cmd += " --project=" + shellQuote(t.GCPProject)
+		}
+		if t.GCPZone != "" {
+			cmd += " --zone=" + shellQuote(t.GCPZone)
+		}
+		return cmd, nil
+	case TransportTailscale:
+		// tailscale nc dials through tailscaled itself, so MagicDNS names
+		// work even when the OS has no route into the tailnet (userspace
+		// networking mode).
+		return "tailscale nc %h %p", nil
+	case TransportCloudflare:
+		// The host is the Access application's hostname; cloudflared
+		// obtains (or reuses) the Access token and may open a browser for
+		// the login.
+		return "cloudflared access ssh --hostname %h", nil
+	default:
+		return "", fmt.Errorf("unknown transport %q", t.Name)
+	}
+}
+
+// tunnelCommand returns the command that opens a tunnel listening on a
+// local port (the %l token) for transports that cannot forward over stdio,
+// or "" when t does not use one.
+func (t Transport) tunnelCommand() (string, error) {
+	if t.Name != TransportBastion {
+		return "", nil
+	}
+	if t.BastionName == "" || t.BastionResourceGroup == "" || t.AzureVMID == "" {
+		return "", fmt.Errorf("the bastion transport requires --bastion-name, --bastion-resource-group and --azure-vm-id")
+	}
+	return "az network bastion tunnel --only-show-errors" +
+		" --name " + shellQuote(t.BastionName) +
+		" --resource-group " + shellQuote(t.BastionResourceGroup) +
+		" --target-resource-id " + shellQuote(t.AzureVMID) +
+		" --resource-port %p --port %l", nil
+}
+
+// shellQuote quotes s for the local shell running proxy commands.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:/@=,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
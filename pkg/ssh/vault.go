@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// VaultOptions selects a role of Vault's SSH secrets engine that signs a
+// short-lived client certificate at connect time.
+type VaultOptions struct {
+	Role string
+	// Mount is the path the SSH secrets engine is mounted at (default "ssh").
+	Mount string
+}
+
+var (
+	vaultMu      sync.Mutex
+	vaultSigners = map[string]ssh.Signer{}
+)
+
+// vaultSigner returns a signer for a fresh ed25519 key, or ECDSA P-256 key in
+// FIPS mode, whose certificate was signed by Vault for principal.
+// Certificates are reused for the lifetime of the process, so multi-host runs
+// only ask Vault once per principal.
+func vaultSigner(opts VaultOptions, principal string, fips bool) (ssh.Signer, error) {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+
+	cacheKey := fmt.Sprintf("%s/%s/%s/%t", opts.Mount, opts.Role, principal, fips)
+	if signer, ok := vaultSigners[cacheKey]; ok {
+		return signer, nil
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var priv any
+	if fips {
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	mount := opts.Mount
+	if mount == "" {
+		mount = "ssh"
+	}
+	body, _ := json.Marshal(map[string]string{
+		"public_key":       string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		"valid_principals": principal,
+		"cert_type":        "user",
+	})
+	url := strings.TrimRight(addr, "/") + "/v1/" + strings.Trim(mount, "/") + "/sign/" + opts.Role
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			SignedKey string `json:"signed_key"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode < 300 {
+		return nil, fmt.Errorf("unexpected response from vault: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vault refused to sign key for role %s: %s (HTTP %d)", opts.Role, strings.Join(result.Errors, "; "), resp.StatusCode)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(result.Data.SignedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed certificate: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("vault returned a key that is not a certificate")
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, err
+	}
+	vaultSigners[cacheKey] = certSigner
+	return certSigner, nil
+}
+
+// vaultToken returns the token from VAULT_TOKEN or the vault CLI's token
+// helper file.
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(filepath.Join(homeDir(), ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("no vault token: set VAULT_TOKEN or run 'vault login'")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
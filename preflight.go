@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newPreflightCmd(opts *transfer.Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "preflight <[user@]host[:port]>",
+		Short: "Check that a remote host is ready to receive images",
+		Long: `Validate SSH authentication, the host key, the remote docker installation,
+sudo requirements, temp directory writability and free disk space, and print
+a pass/fail checklist.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.Preflight(cmd.Context(), args[0], *opts)
+		},
+	}
+}
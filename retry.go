@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"remote-pull/internal/console"
+	"remote-pull/internal/report"
+	"remote-pull/internal/transfer"
+	"remote-pull/pkg/ssh"
+)
+
+// defaultFailedFile records the hosts of the last run that failed.
+const defaultFailedFile = "remote-pull-failed.json"
+
+func newRetryFailedCmd() *cobra.Command {
+	var failedFile string
+	cmd := &cobra.Command{
+		Use:   "retry-failed",
+		Short: "Repeat the last run for the hosts it failed on",
+		Long: `Repeat the last transfer, compose deployment or estimate for the hosts
+recorded in the failed hosts file, including hosts skipped as unreachable,
+with the options of that run. Options given here are added after them. The
+file is updated with the hosts that still fail, and removed once all succeed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failed, err := report.ReadFailed(failedFile)
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no failed hosts recorded in %s", failedFile)
+			} else if err != nil {
+				return err
+			}
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+
+			// The run cleans up itself when interrupted
+			signal.Ignore(os.Interrupt)
+			extra := retryArgs(cmd, []string{"failed-file"})
+			still := report.Failed{Args: failed.Args}
+			groups := retryGroups(failed.Images)
+			if len(failed.Hosts) > 0 {
+				// A compose deployment takes its images from the file
+				groups = append(groups, nil)
+			}
+			// keep records a group again as it was
+			keep := func(group []report.FailedImage) {
+				if group == nil {
+					still.Hosts = append(still.Hosts, failed.Hosts...)
+				} else {
+					still.Images = append(still.Images, group...)
+				}
+			}
+			exitCode := 0
+			for i, group := range groups {
+				// After an interrupt the remaining images stay recorded
+				if exitCode == 130 || exitCode < 0 {
+					keep(group)
+					continue
+				}
+				// Every run records what still fails in its own file,
+				// merged into failedFile at the end
+				runFailed := fmt.Sprintf("%s.retry-%d", failedFile, i)
+				images := make([]string, len(group))
+				for j, image := range group {
+					images[j] = image.Image
+				}
+				hosts := failed.Hosts
+				if group != nil {
+					hosts = group[0].Hosts
+					console.Printf("[RETRY] Transferring %s to %d hosts again\n", strings.Join(images, ", "), len(hosts))
+				} else {
+					console.Printf("[RETRY] Deploying to %d hosts again\n", len(hosts))
+				}
+				runArgs := slices.Concat(retryHostKeys(failed.Args, hosts), extra, []string{"--failed-file=" + runFailed}, images, []string{"--"}, hosts)
+				run := exec.CommandContext(cmd.Context(), exe, runArgs...)
+				run.Stdin, run.Stdout, run.Stderr = os.Stdin, os.Stdout, os.Stderr
+				err := run.Run()
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					exitCode = exitErr.ExitCode()
+				} else if err != nil {
+					return err
+				}
+				recorded, readErr := report.ReadFailed(runFailed)
+				os.Remove(runFailed)
+				switch {
+				case readErr == nil:
+					still.Args = recorded.Args
+					still.Images = append(still.Images, recorded.Images...)
+					still.Hosts = append(still.Hosts, recorded.Hosts...)
+				case err != nil:
+					// The run failed before recording anything
+					keep(group)
+				}
+			}
+			if err := report.WriteFailed(failedFile, still); err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&failedFile, "failed-file", defaultFailedFile, "File the failed hosts were recorded in")
+	return cmd
+}
+
+// failedHosts returns the hosts a run that returned err failed on: the
+// hosts listed by a *transfer.HostsError, or all hosts for other errors.
+func failedHosts(err error, hosts []string) []string {
+	var hostsErr *transfer.HostsError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &hostsErr):
+		return hostsErr.Hosts
+	}
+	return hosts
+}
+
+// writeFailed records failed for retry-failed in path, unless path is empty.
+func writeFailed(path string, failed report.Failed) {
+	if path == "" {
+		return
+	}
+	if err := report.WriteFailed(path, failed); err != nil {
+		console.Printf("[WARNING] %v\n", err)
+	}
+}
+
+// retryHostKeys leaves the --host-key pins of hosts that are not retried
+// out of args, as the run refuses pins that match none of its hosts.
+func retryHostKeys(args, hosts []string) []string {
+	addresses := hostAddresses(hosts)
+	return slices.DeleteFunc(slices.Clone(args), func(arg string) bool {
+		pin, ok := strings.CutPrefix(arg, "--host-key=")
+		if !ok {
+			return false
+		}
+		target, _, _ := strings.Cut(pin, "=")
+		t, err := ssh.ParseTarget(target)
+		return err == nil && !addresses[t.Address()]
+	})
+}
+
+// retryGroups groups the images that failed on the same hosts, so each
+// group is repeated in one run.
+func retryGroups(images []report.FailedImage) [][]report.FailedImage {
+	var groups [][]report.FailedImage
+	for _, image := range images {
+		i := slices.IndexFunc(groups, func(group []report.FailedImage) bool {
+			return slices.Equal(group[0].Hosts, image.Hosts)
+		})
+		if i < 0 {
+			groups = append(groups, []report.FailedImage{image})
+		} else {
+			groups[i] = append(groups[i], image)
+		}
+	}
+	return groups
+}
+
+// retryArgs returns the options given to cmd as command line arguments,
+// leaving out the flags in skip, so a run can be repeated by retry-failed.
+func retryArgs(cmd *cobra.Command, skip []string) []string {
+	var args []string
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if slices.Contains(skip, flag.Name) {
+			return
+		}
+		if values, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, v := range values.GetSlice() {
+				args = append(args, "--"+flag.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+flag.Name+"="+flag.Value.String())
+	})
+	return args
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newRollbackCmd(opts *transfer.Options) *cobra.Command {
+	var steps int
+	cmd := &cobra.Command{
+		Use:   "rollback <[user@]host[:port]> <image>",
+		Short: "Reactivate the previous version of an image on a remote host",
+		Long: `Tag a version of the image retained by --retain, <image>:prev1 unless
+--steps selects an older one, as <image>:current again. The two versions swap
+tags, so running rollback twice restores the newer one. --post-cmd can restart
+the service on the reactivated image.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.Rollback(cmd.Context(), args[0], args[1], steps, *opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&steps, "steps", 1, "Reactivate the version retained as prev<steps>")
+	flags.StringVar(&opts.PostLoad.Command, "post-cmd", "", "Remote command run after the rollback, a template with {{.Image}}, {{.ImageID}} etc.")
+	flags.StringArrayVar(&opts.PostLoad.Vars, "post-var", nil, "Additional KEY=VALUE variable for --post-cmd (repeatable)")
+	flags.StringVar(&opts.HealthCheck.Command, "healthcheck", "", "Remote command that must succeed after --post-cmd, retried until --healthcheck-timeout")
+	flags.DurationVar(&opts.HealthCheck.Interval, "healthcheck-interval", 5*time.Second, "Delay between health check attempts")
+	flags.DurationVar(&opts.HealthCheck.Timeout, "healthcheck-timeout", 2*time.Minute, "Time the health check may take to pass")
+	return cmd
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/update"
+)
+
+func newSelfUpdateCmd() *cobra.Command {
+	var (
+		repo  string
+		force bool
+	)
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with the latest release",
+		Long: `Download the latest release for this platform from GitHub, verify it against
+the release checksums and their signature and replace the running binary in
+place. Development builds and versions newer than the latest release are only
+replaced with --force.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return update.Run(repo, version, force)
+		},
+	}
+	cmd.Flags().StringVar(&repo, "repo", update.DefaultRepository, "GitHub repository to take releases from")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace development builds and downgrade versions newer than the latest release")
+	return cmd
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newSIFCmd(opts *transfer.Options) *cobra.Command {
+	var sopts transfer.SIFOptions
+	cmd := &cobra.Command{
+		Use:   "sif <image> <[user@]host[:port]>",
+		Short: "Transfer an image as Apptainer/Singularity SIF file",
+		Long: `Convert a Docker image to a SIF file for hosts running Apptainer or
+Singularity instead of Docker, such as HPC login nodes. By default the image
+archive is transferred and converted with apptainer on the remote; with
+--local-build the SIF file is built locally and transferred instead.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.PushSIF(cmd.Context(), args[0], args[1], sopts, *opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&sopts.Dest, "dest", "", "Path of the SIF file on the remote (default <name>_<tag>.sif in the home directory)")
+	flags.BoolVar(&sopts.LocalBuild, "local-build", false, "Build the SIF file with the local apptainer")
+	flags.BoolVar(&opts.SkipPull, "skip-pull", false, "Skip pulling the image locally before transfer")
+	return cmd
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newInspectSizeCmd(opts *transfer.Options) *cobra.Command {
+	var pull bool
+	cmd := &cobra.Command{
+		Use:   "inspect-size <image> [[user@]host[:port]]",
+		Short: "Show the size of an image per layer, and what a host already has",
+		Long: `Export the image locally and list its layers with their size, estimated
+gzip and zstd compressed size, and the instruction that created them. With a
+host, the layers it already has are marked and the amount a transfer would
+send is shown. Nothing is transferred.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			host := ""
+			if len(args) == 2 {
+				host = args[1]
+			}
+			o := *opts
+			o.SkipPull = !pull
+			return transfer.InspectSize(cmd.Context(), args[0], host, o)
+		},
+	}
+	cmd.Flags().BoolVar(&pull, "pull", false, "Pull the image before inspecting it")
+	return cmd
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/spf13/pflag"
+
+	"remote-pull/internal/discovery"
+	"remote-pull/internal/inventory"
+	"remote-pull/pkg/ssh"
+)
+
+// targetFlags selects transfer targets from a host list, an inventory or a
+// discovery backend in addition to the host arguments.
+type targetFlags struct {
+	hosts []string
+
+	inventoryFile string
+	limit         string
+
+	ec2     discovery.EC2Options
+	awsUser string
+
+	kubeNodes bool
+	kube      discovery.KubeOptions
+	kubeUser  string
+
+	// hostKeys collects the host keys pinned in the inventory for the
+	// resolved targets, keyed by host:port.
+	hostKeys map[string][]string
+	// weights collects the bandwidth weights of the resolved inventory
+	// hosts.
+	weights map[string]float64
+}
+
+func (f *targetFlags) register(flags *pflag.FlagSet) {
+	flags.StringSliceVar(&f.hosts, "hosts", nil, "Comma-separated list of [user@]host[:port] targets")
+	flags.StringVarP(&f.inventoryFile, "inventory", "i", "", "Ansible INI inventory to resolve targets from")
+	flags.StringVarP(&f.limit, "limit", "l", "", "Ansible host pattern selecting inventory hosts (default all)")
+	flags.StringArrayVar(&f.ec2.Tags, "aws-tag", nil, "Target running EC2 instances with this tag (key=value, repeatable)")
+	flags.StringArrayVar(&f.ec2.Filters, "aws-filter", nil, "Additional EC2 DescribeInstances filter (Name=...,Values=..., repeatable)")
+	flags.StringVar(&f.ec2.Region, "aws-region", "", "AWS region for EC2 discovery")
+	flags.StringVar(&f.ec2.Profile, "aws-profile", "", "AWS CLI profile for EC2 discovery")
+	flags.BoolVar(&f.ec2.PublicIP, "aws-public-ip", false, "Connect to the public instead of the private IP of discovered instances")
+	flags.StringVar(&f.awsUser, "aws-user", "", "SSH user for discovered EC2 instances (default from ssh_config)")
+	flags.BoolVar(&f.kubeNodes, "kube-nodes", false, "Target the nodes of a Kubernetes cluster (discovered through kubectl)")
+	flags.StringVar(&f.kube.Context, "context", "", "kubeconfig context for --kube-nodes (default current context)")
+	flags.StringVar(&f.kube.Selector, "selector", "", "Label selector restricting --kube-nodes, e.g. role=worker")
+	flags.StringVar(&f.kube.AddressType, "kube-address-type", "InternalIP", "Node address type to connect to (InternalIP, ExternalIP or Hostname)")
+	flags.StringVar(&f.kubeUser, "kube-user", "", "SSH user for Kubernetes nodes (default from ssh_config)")
+}
+
+// names returns the names of the flags selecting targets.
+func (f *targetFlags) names() []string {
+	flags := pflag.NewFlagSet("targets", pflag.ContinueOnError)
+	(&targetFlags{}).register(flags)
+	var names []string
+	flags.VisitAll(func(flag *pflag.Flag) {
+		names = append(names, flag.Name)
+	})
+	return names
+}
+
+// active reports whether targets come from the flags, so no positional host
+// argument is required.
+func (f *targetFlags) active() bool {
+	return len(f.hosts) > 0 || f.inventoryFile != "" || f.ec2Active() || f.kubeNodes
+}
+
+func (f *targetFlags) ec2Active() bool {
+	return len(f.ec2.Tags) > 0 || len(f.ec2.Filters) > 0
+}
+
+func (f *targetFlags) resolve() ([]string, error) {
+	targets := slices.Clone(f.hosts)
+	if f.inventoryFile != "" {
+		hosts, err := f.inventoryTargets(f.limit)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, hosts...)
+	}
+	if f.ec2Active() {
+		addresses, err := discovery.EC2Addresses(f.ec2)
+		if err != nil {
+			return nil, fmt.Errorf("EC2 discovery failed: %v", err)
+		}
+		for _, addr := range addresses {
+			targets = append(targets, ssh.Target{User: f.awsUser, Host: addr}.String())
+		}
+	}
+	if f.kubeNodes {
+		addresses, err := discovery.KubeNodeAddresses(f.kube)
+		if err != nil {
+			return nil, fmt.Errorf("Kubernetes node discovery failed: %v", err)
+		}
+		for _, addr := range addresses {
+			targets = append(targets, ssh.Target{User: f.kubeUser, Host: addr}.String())
+		}
+	}
+	return targets, nil
+}
+
+// inventoryTargets resolves the hosts selected by limit in the Ansible
+// inventory into target strings, and records their pinned host keys.
+func (f *targetFlags) inventoryTargets(limit string) ([]string, error) {
+	inv, err := inventory.Load(f.inventoryFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inventory: %v", err)
+	}
+	hosts, err := inv.Resolve(limit)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		t := ssh.Target{User: h.User(), Host: h.Address(), Port: h.Port()}
+		target := t.String()
+		targets = append(targets, target)
+		if keys := h.HostKeys(); len(keys) > 0 {
+			for _, key := range keys {
+				if err := ssh.CheckHostKeyPin(key); err != nil {
+					return nil, fmt.Errorf("inventory host %s: %v", h.Name, err)
+				}
+			}
+			if f.hostKeys == nil {
+				f.hostKeys = map[string][]string{}
+			}
+			f.hostKeys[t.Address()] = keys
+		}
+		weight, err := h.BandwidthWeight()
+		if err != nil {
+			return nil, fmt.Errorf("inventory host %s: %v", h.Name, err)
+		}
+		if weight > 0 {
+			if f.weights == nil {
+				f.weights = map[string]float64{}
+			}
+			f.weights[target] = weight
+		}
+	}
+	return targets, nil
+}
+
+// pinHostKeys adds the host keys pinned in the inventory to hostKeys, where
+// they are used unless the host has keys pinned with --host-key, and returns
+// them as --host-key arguments for retry-failed.
+func (f *targetFlags) pinHostKeys(hostKeys map[string][]string) []string {
+	var args []string
+	for address, keys := range f.hostKeys {
+		if _, ok := hostKeys[address]; ok {
+			continue
+		}
+		hostKeys[address] = keys
+		for _, key := range keys {
+			args = append(args, "--host-key="+address+"="+key)
+		}
+	}
+	return args
+}
+
+// hostAddresses returns the host:port of every host, as HostKeys is keyed.
+func hostAddresses(hosts []string) map[string]bool {
+	addresses := map[string]bool{}
+	for _, host := range hosts {
+		if t, err := ssh.ParseTarget(host); err == nil {
+			addresses[t.Address()] = true
+		}
+	}
+	return addresses
+}
+
+// checkHostKeyPins fails for host keys pinned with --host-key for none of
+// hosts, which would otherwise leave the host to known_hosts unnoticed.
+func checkHostKeyPins(hostKeys map[string][]string, hosts []string) error {
+	addresses := hostAddresses(hosts)
+	for _, address := range slices.Sorted(maps.Keys(hostKeys)) {
+		if !addresses[address] {
+			return fmt.Errorf("--host-key for %s matches none of the hosts", address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"remote-pull/internal/transfer"
+)
+
+func newVolumeCmd(opts *transfer.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "volume",
+		Short: "Transfer Docker volumes",
+	}
+
+	var vopts transfer.VolumeOptions
+	push := &cobra.Command{
+		Use:   "push <volume> <[user@]host[:port]>",
+		Short: "Copy a named local volume to a remote host",
+		Long: `Archive a named local volume through a helper container, transfer it and
+restore it into a volume of the same name on the remote host.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return transfer.PushVolume(cmd.Context(), args[0], args[1], vopts, *opts)
+		},
+	}
+	flags := push.Flags()
+	flags.StringVar(&vopts.HelperImage, "helper-image", transfer.DefaultHelperImage, "Image used to archive and restore the volume")
+	flags.BoolVar(&vopts.Force, "force", false, "Restore into a volume that already exists on the remote")
+
+	cmd.AddCommand(push)
+	return cmd
+}